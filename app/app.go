@@ -11,10 +11,19 @@ import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"github.com/funte/xmlymft/common"
 
+	"xmlymft-fyne-gui/app/download"
+	"xmlymft-fyne-gui/app/favorite"
+	"xmlymft-fyne-gui/app/feed"
+	"xmlymft-fyne-gui/app/mpris"
 	"xmlymft-fyne-gui/app/mytheme"
+	"xmlymft-fyne-gui/app/player"
+	"xmlymft-fyne-gui/app/player/otosink"
+	"xmlymft-fyne-gui/app/settings"
 	"xmlymft-fyne-gui/app/store"
+	"xmlymft-fyne-gui/app/subscription"
 	"xmlymft-fyne-gui/resources"
 	"xmlymft-fyne-gui/utils"
 )
@@ -64,20 +73,219 @@ func Run() {
 
 	cmd := startServer(port, window)
 
-	s := store.NewStore(window, serverURL)
+	guiSettings, err := settings.GetSettings()
+	if err != nil {
+		utils.AbortOnError(err, window)
+		guiSettings = settings.NewSettings()
+	}
+
+	records, err := download.LoadRecords(download.RecordsFilePath)
+	if err != nil {
+		dialog.ShowError(err, window)
+	}
+
+	downloader := download.NewManager(serverURL, *guiSettings, records)
+	downloader.OnFailed = func(job download.Job, err error) {
+		dialog.ShowError(fmt.Errorf("%s: %w", job.Track.Name, err), window)
+	}
+	downloader.OnLowSpace = func(err error) {
+		dialog.ShowError(fmt.Errorf("已暂停全部下载, %w", err), window)
+	}
+	restored, err := downloader.LoadJournal(download.JournalFilePath)
+	if err != nil {
+		dialog.ShowError(err, window)
+	} else if restored > 0 {
+		message := fmt.Sprintf("有 %d 个未完成的下载, 是否继续下载?", restored)
+		dialog.ShowConfirm("继续下载", message, func(ok bool) {
+			if ok {
+				downloader.ResumeAll()
+			}
+		}, window)
+	}
+	subscriptions, err := subscription.NewWatcher(serverURL, subscription.SubscriptionsFilePath, downloader)
+	if err != nil {
+		dialog.ShowError(err, window)
+	}
+	subscriptions.Start()
+	feedServer := feed.NewServer(downloader)
+	if err := feedServer.Apply(guiSettings.FeedServer, guiSettings.FeedPort); err != nil {
+		dialog.ShowError(fmt.Errorf("播客订阅服务: %w", err), window)
+	}
+	cacheProxy := download.NewCacheProxy(downloader)
+	if err := cacheProxy.Start(); err != nil {
+		log.Printf("cache proxy: %s", err)
+	}
+	sink, err := otosink.NewSink()
+	if err != nil {
+		dialog.ShowError(err, window)
+		sink = new(player.NullSink)
+	}
+	positions, err := player.LoadPositions(player.PositionsFilePath)
+	if err != nil {
+		dialog.ShowError(err, window)
+	}
+	preferences, err := player.LoadPreferences(player.PreferencesFilePath)
+	if err != nil {
+		dialog.ShowError(err, window)
+	}
+	queue, err := player.LoadQueue(player.QueueFilePath)
+	if err != nil {
+		dialog.ShowError(err, window)
+	}
+	playerPanel := player.NewPanel(
+		window, player.NewPlayer(sink), queue, positions, preferences, newTrackResolver(downloader, cacheProxy),
+	)
+
+	// Media keys and desktop media widgets on Linux.
+	mprisService, err := mpris.Start(playerPanel)
+	if err != nil {
+		log.Printf("MPRIS: %s", err)
+	}
+	playerPanel.OnChanged = mprisService.Update
+
+	favorites, err := favorite.LoadFavorites(favorite.FavoritesFilePath)
+	if err != nil {
+		dialog.ShowError(err, window)
+	}
+	s := store.NewStore(window, serverURL, downloader, subscriptions, positions, favorites)
+	s.OnPlay = func(album common.AlbumInfo, tracks []download.AlbumTrack, index int) {
+		items := make([]player.Item, len(tracks))
+		for i, track := range tracks {
+			items[i] = player.Item{Album: album, Track: track.Track, Position: track.Position}
+		}
+		playerPanel.PlayList(items, index)
+	}
+	s.OnQueue = func(album common.AlbumInfo, track download.AlbumTrack, next bool) {
+		item := player.Item{Album: album, Track: track.Track, Position: track.Position}
+		if next {
+			playerPanel.PlayNext(item)
+		} else {
+			playerPanel.AddToQueue(item)
+		}
+	}
+	storeView := s.Contents()
+	downloadView := download.NewView(window, downloader).Contents()
+
+	// Main views, only one is shown at a time.
+	views := container.NewMax(storeView, downloadView)
+	showView := func(view fyne.CanvasObject) {
+		for _, o := range views.Objects {
+			if o == view {
+				o.Show()
+			} else {
+				o.Hide()
+			}
+		}
+	}
+	// The view to back to from the queue view.
+	lastView := storeView
+	queueView := player.NewQueueView(queue, playerPanel, func() {
+		showView(lastView)
+	})
+	views.Add(queueView.Contents())
+	queue.OnChanged = func() {
+		queueView.Refresh()
+		playerPanel.Refresh()
+	}
+
+	favoriteView := favorite.NewView(window, favorites, func(album common.AlbumInfo) {
+		showView(storeView)
+		go func() {
+			if err := s.ShowAlbum(album); err != nil {
+				dialog.ShowError(err, window)
+			}
+		}()
+	}, func(tracks []favorite.Track, index int) {
+		items := make([]player.Item, len(tracks))
+		for i, track := range tracks {
+			items[i] = player.Item{Album: track.Album, Track: track.Track, Position: track.Position}
+		}
+		playerPanel.PlayList(items, index)
+	})
+	views.Add(favoriteView.Contents())
+	favorites.OnChanged = func() {
+		favoriteView.Refresh()
+		s.Refresh()
+	}
+	favoriteView.OnImport = func() {
+		showImportDialog(window, favorites, subscriptions)
+	}
+	favoriteView.OnExport = func() {
+		showExportDialog(window, favorites, subscriptions)
+	}
+	showView(storeView)
+	playerPanel.OnOpenQueue = func() {
+		if queueView.Contents().Visible() {
+			showView(lastView)
+			return
+		}
+		lastView = storeView
+		for _, view := range []fyne.CanvasObject{downloadView, favoriteView.Contents()} {
+			if view.Visible() {
+				lastView = view
+			}
+		}
+		queueView.Refresh()
+		showView(queueView.Contents())
+	}
+
 	onOpenFavorite := func() {
-		log.Println("open favorite")
+		if favoriteView.Contents().Visible() {
+			showView(storeView)
+		} else {
+			favoriteView.Refresh()
+			showView(favoriteView.Contents())
+		}
+	}
+	onOpenDownload := func() {
+		if downloadView.Visible() {
+			showView(storeView)
+		} else {
+			showView(downloadView)
+		}
+	}
+	onOpenSettings := func() {
+		showSettingsDialog(window, downloader.Settings(), func(saved settings.Settings) {
+			downloader.ApplySettings(saved)
+			if err := feedServer.Apply(saved.FeedServer, saved.FeedPort); err != nil {
+				dialog.ShowError(fmt.Errorf("播客订阅服务: %w", err), window)
+			}
+		})
 	}
 	onSearch := func(keyword string) {
+		showView(storeView)
 		s.Search(keyword, 0)
 	}
 	context := container.NewBorder(
-		newToolbar(window, onOpenFavorite, onSearch), nil, nil, nil,
-		s.Contents(),
+		newToolbar(window, onOpenFavorite, onOpenDownload, onOpenSettings, onSearch),
+		playerPanel.Contents(), nil, nil,
+		views,
 	)
 	window.SetContent(context)
 
+	window.SetCloseIntercept(func() {
+		active, _, _ := downloader.Progress()
+		if active == 0 {
+			window.Close()
+			return
+		}
+		message := fmt.Sprintf("还有 %d 个下载未完成, 下次启动时可以继续. 确定退出?", active)
+		dialog.ShowConfirm("退出", message, func(ok bool) {
+			if ok {
+				window.Close()
+			}
+		}, window)
+	})
+
 	window.Resize(fyne.NewSize(360.0*mytheme.Factor, 480.0*mytheme.Factor))
 	window.ShowAndRun()
+	subscriptions.Stop()
+	feedServer.Stop()
+	mprisService.Close()
+	playerPanel.Close()
+	cacheProxy.Stop()
+	if err := downloader.SaveJournal(); err != nil {
+		log.Printf("save download journal: %s", err)
+	}
 	cmd.Process.Kill()
 }
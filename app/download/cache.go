@@ -0,0 +1,533 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/tags"
+)
+
+// Suffix of the file caching a streamed track.
+const CacheSuffix = ".cache"
+
+// Size of the chunks written to the cache file and the player.
+const cacheChunkSize = 32 * 1024
+
+// Byte range [start, end) of a file.
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// A track streamed through the proxy and its cache file. The player always
+// gets the bytes of the cache file, the completed file is tagged and saved as
+// the track only after evicted, so the bytes never shift while playing.
+type cacheEntry struct {
+	job       Job
+	fileType  string
+	size      int64
+	trackpath string
+	cachepath string
+
+	lock    sync.Mutex
+	address string
+	file    *os.File
+	// Cached ranges, sorted and merged.
+	ranges []byteRange
+	// Number of requests serving the entry.
+	serving int
+	// Whether the entry is evicted, closed when no request serving.
+	evicted bool
+	// Whether the cache file is closed, saved as the track or removed.
+	closed bool
+}
+
+// Length of the cached bytes from offset, 0 if offset is not cached.
+func (e *cacheEntry) cached(offset int64) int64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, r := range e.ranges {
+		if r.start <= offset && offset < r.end {
+			return r.end - offset
+		}
+	}
+	return 0
+}
+
+// Start of the first cached range after offset, the size if none.
+func (e *cacheEntry) nextCached(offset int64) int64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, r := range e.ranges {
+		if r.start > offset {
+			return r.start
+		}
+	}
+	return e.size
+}
+
+// Add a cached range.
+func (e *cacheEntry) add(start int64, end int64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	ranges := append(e.ranges, byteRange{start, end})
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.end {
+			if r.end > last.end {
+				last.end = r.end
+			}
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	e.ranges = merged
+}
+
+// Whether the whole file is cached, requires lock.
+func (e *cacheEntry) complete() bool {
+	return len(e.ranges) == 1 && e.ranges[0].start == 0 && e.ranges[0].end >= e.size
+}
+
+// CacheProxy streams the tracks to the player from a local HTTP server and
+// saves them into the download directory at the same time. A track streamed
+// to the end is recorded as downloaded, and the ranges already cached are
+// served from the cache file when seeking.
+type CacheProxy struct {
+	manager *Manager
+
+	lock     sync.Mutex
+	server   *http.Server
+	listener net.Listener
+	// Streamed tracks, only the last opened one is kept: trackId -> entry.
+	entries map[int]*cacheEntry
+}
+
+// Start listening on a random port of the loopback interface.
+func (p *CacheProxy) Start() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tracks/", p.handleTrack)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("cache proxy: %s", err)
+		}
+	}()
+
+	p.lock.Lock()
+	p.server, p.listener = server, listener
+	p.lock.Unlock()
+	return nil
+}
+
+// Stop the server, save the completed cache files and remove the others.
+func (p *CacheProxy) Stop() {
+	p.lock.Lock()
+	if p.server != nil {
+		p.server.Close()
+	}
+	p.server, p.listener = nil, nil
+	idle := p.evict(-1)
+	p.lock.Unlock()
+
+	for _, entry := range idle {
+		p.close(entry)
+	}
+}
+
+// Open a track to stream with its address queried by TrackAddress, returns
+// the address on the proxy and the type and size of the track. The address
+// of the CDN is returned if the proxy is not running, caching is disabled or
+// the free space is low. The other tracks streamed before are evicted.
+func (p *CacheProxy) Open(
+	album common.AlbumInfo, track common.TrackInfo, position int, address common.QueryTrackAddressResult,
+) (common.QueryTrackAddressResult, error) {
+	p.lock.Lock()
+	address, idle, err := p.open(album, track, position, address)
+	p.lock.Unlock()
+
+	for _, entry := range idle {
+		p.close(entry)
+	}
+	return address, err
+}
+
+// Open a track with its CDN address, returns the evicted entries not served,
+// requires lock.
+func (p *CacheProxy) open(
+	album common.AlbumInfo, track common.TrackInfo, position int, address common.QueryTrackAddressResult,
+) (common.QueryTrackAddressResult, []*cacheEntry, error) {
+	cfg := p.manager.Settings()
+	cacheable := p.listener != nil && cfg.CachePlayback && address.ByteSize > 0
+	if entry, ok := p.entries[track.Id]; ok && cacheable && entry.fileType == address.Type {
+		entry.lock.Lock()
+		entry.address = address.Address
+		entry.lock.Unlock()
+		address.Address = p.trackURL(track.Id, address.Type)
+		return address, p.evict(track.Id), nil
+	}
+	idle := p.evict(-1)
+	if !cacheable {
+		return address, idle, nil
+	}
+	if _, err := p.manager.CheckSpace(int64(address.ByteSize)); errors.Is(err, ErrLowSpace) {
+		return address, idle, nil
+	}
+
+	// The name is claimed like a queued job's, a download of another track
+	// of the same name is suffixed.
+	p.manager.lock.Lock()
+	job := p.manager.newJob(album, track, position)
+	p.manager.claimName(job)
+	p.manager.lock.Unlock()
+	trackpath, err := trackPath(cfg, *job, address.Type)
+	if err != nil {
+		return address, idle, err
+	}
+	os.MkdirAll(filepath.Dir(trackpath), 0755)
+	// Named uniquely, an evicted entry of the track may be still served.
+	file, err := os.CreateTemp(filepath.Dir(trackpath), filepath.Base(trackpath)+".*"+CacheSuffix)
+	if err != nil {
+		return address, idle, err
+	}
+	p.entries[track.Id] = &cacheEntry{
+		job:       *job,
+		fileType:  address.Type,
+		size:      int64(address.ByteSize),
+		trackpath: trackpath,
+		cachepath: file.Name(),
+		address:   address.Address,
+		file:      file,
+	}
+	address.Address = p.trackURL(track.Id, address.Type)
+	return address, idle, nil
+}
+
+// Evict the entries except the track keep, returns the ones not served to
+// close, the others are closed by the last request. Requires lock.
+func (p *CacheProxy) evict(keep int) []*cacheEntry {
+	idle := []*cacheEntry{}
+	for trackId, entry := range p.entries {
+		if trackId == keep {
+			continue
+		}
+		delete(p.entries, trackId)
+		entry.lock.Lock()
+		entry.evicted = true
+		if entry.serving == 0 {
+			idle = append(idle, entry)
+		}
+		entry.lock.Unlock()
+	}
+	return idle
+}
+
+// Close an evicted entry, the completed cache file is saved as the downloaded
+// track, an incomplete one is removed.
+func (p *CacheProxy) close(entry *cacheEntry) {
+	entry.lock.Lock()
+	if entry.closed {
+		entry.lock.Unlock()
+		return
+	}
+	entry.closed = true
+	complete := entry.complete()
+	entry.lock.Unlock()
+
+	if !complete {
+		entry.file.Close()
+		os.Remove(entry.cachepath)
+	} else if err := p.save(entry); err != nil {
+		log.Printf("save cached %s: %s", entry.job.Track.Name, err)
+	}
+	p.manager.lock.Lock()
+	p.manager.releaseName(entry.job)
+	p.manager.lock.Unlock()
+}
+
+// Address of a track on the proxy, requires lock.
+func (p *CacheProxy) trackURL(trackId int, fileType string) string {
+	return fmt.Sprintf("http://%s/tracks/%d.%s", p.listener.Addr(), trackId, fileType)
+}
+
+func (p *CacheProxy) entry(trackId int) (*cacheEntry, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	entry, ok := p.entries[trackId]
+	return entry, ok
+}
+
+// Parse a Range header like "bytes=100-" or "bytes=100-199" to [start, end),
+// the whole file if empty.
+func parseRange(header string, size int64) (start int64, end int64, ok bool) {
+	if header == "" {
+		return 0, size, true
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	dash := strings.Index(spec, "-")
+	if spec == header || dash <= 0 || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(spec[:dash], 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end = size
+	if last := spec[dash+1:]; last != "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < start {
+			return 0, 0, false
+		}
+		if n+1 < size {
+			end = n + 1
+		}
+	}
+	return start, end, true
+}
+
+func (p *CacheProxy) handleTrack(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/tracks/")
+	extension := filepath.Ext(name)
+	trackId, err := strconv.Atoi(strings.TrimSuffix(name, extension))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	entry, ok := p.entry(trackId)
+	if !ok || !strings.EqualFold(strings.TrimPrefix(extension, "."), entry.fileType) {
+		http.NotFound(w, r)
+		return
+	}
+
+	entry.lock.Lock()
+	evicted := entry.evicted
+	if !evicted {
+		entry.serving++
+	}
+	entry.lock.Unlock()
+	if evicted {
+		http.NotFound(w, r)
+		return
+	}
+	defer p.release(entry)
+
+	start, end, ok := parseRange(r.Header.Get("Range"), entry.size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", entry.size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/"+entry.fileType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start, 10))
+	if r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, entry.size))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := p.copyRange(r.Context(), entry, w, start, end); err != nil && r.Context().Err() == nil {
+		log.Printf("stream %s: %s", entry.job.Track.Name, err)
+	}
+}
+
+// Write the range [start, end) of a track, the cached parts are read from the
+// cache file and the others are fetched and cached.
+func (p *CacheProxy) copyRange(ctx context.Context, entry *cacheEntry, w io.Writer, start int64, end int64) error {
+	for offset := start; offset < end; {
+		if n := entry.cached(offset); n > 0 {
+			if n > end-offset {
+				n = end - offset
+			}
+			if _, err := io.Copy(w, io.NewSectionReader(entry.file, offset, n)); err != nil {
+				return err
+			}
+			offset += n
+			continue
+		}
+		fetchEnd := entry.nextCached(offset)
+		if fetchEnd > end {
+			fetchEnd = end
+		}
+		n, err := p.fetch(ctx, entry, w, offset, fetchEnd)
+		offset += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch the range [start, end) of a track from the CDN, write it to the cache
+// file and w. Returns the bytes written.
+func (p *CacheProxy) fetch(ctx context.Context, entry *cacheEntry, w io.Writer, start int64, end int64) (int64, error) {
+	resp, err := p.request(ctx, entry, start, end)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, cacheChunkSize)
+	offset := start
+	for offset < end {
+		size := int64(len(buf))
+		if size > end-offset {
+			size = end - offset
+		}
+		n, err := resp.Body.Read(buf[:size])
+		if n > 0 {
+			if _, err := entry.file.WriteAt(buf[:n], offset); err != nil {
+				return offset - start, err
+			}
+			entry.add(offset, offset+int64(n))
+			offset += int64(n)
+			if _, err := w.Write(buf[:n]); err != nil {
+				return offset - start, err
+			}
+		}
+		if err == io.EOF && offset < end {
+			return offset - start, fmt.Errorf("%w: %d/%d bytes", ErrIncomplete, offset, end)
+		} else if err != nil && err != io.EOF {
+			return offset - start, err
+		}
+	}
+	return offset - start, nil
+}
+
+// Request the range [start, end) of a track from the CDN. The address is
+// queried again once if expired.
+func (p *CacheProxy) request(ctx context.Context, entry *cacheEntry, start int64, end int64) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		entry.lock.Lock()
+		address := entry.address
+		entry.lock.Unlock()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			if rangeStart, _ := parseContentRange(resp.Header.Get("Content-Range")); rangeStart != start {
+				resp.Body.Close()
+				return nil, fmt.Errorf("%w: unexpected content range %q", ErrIncomplete, resp.Header.Get("Content-Range"))
+			}
+			return resp, nil
+		case http.StatusOK:
+			// Range ignored by the server, skip to the start.
+			if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			return resp, nil
+		}
+		resp.Body.Close()
+		expired := resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound ||
+			resp.StatusCode == http.StatusGone
+		if !expired || attempt > 1 {
+			return nil, fmt.Errorf("stream failed: %s", resp.Status)
+		}
+		result, err := p.manager.TrackAddress(entry.job.Track.Id)
+		if err != nil {
+			return nil, err
+		}
+		entry.lock.Lock()
+		entry.address = result.Address
+		entry.lock.Unlock()
+	}
+}
+
+// Release a request of an entry, an evicted entry is closed when no request
+// serving.
+func (p *CacheProxy) release(entry *cacheEntry) {
+	entry.lock.Lock()
+	entry.serving--
+	idle := entry.serving == 0 && entry.evicted
+	entry.lock.Unlock()
+
+	if idle {
+		p.close(entry)
+	}
+}
+
+// Save a completed cache file as the downloaded track, like a finished
+// download job.
+func (p *CacheProxy) save(entry *cacheEntry) error {
+	cachepath := entry.cachepath
+	if err := entry.file.Close(); err != nil {
+		os.Remove(cachepath)
+		return err
+	}
+	// Downloaded meanwhile.
+	if p.manager.isDownloaded(entry.job.Track.Id) {
+		return os.Remove(cachepath)
+	}
+	if err := tags.Validate(cachepath, entry.fileType); err != nil {
+		os.Remove(cachepath)
+		return err
+	}
+	// The name may be taken after opened, e.g. by a file copied into the
+	// download directory, which is never adopted or overwritten.
+	p.manager.lock.Lock()
+	if p.manager.fileTaken(entry.job.Track.Id, entry.trackpath) {
+		p.manager.lock.Unlock()
+		os.Remove(cachepath)
+		return fmt.Errorf("%s is taken by another file", entry.trackpath)
+	}
+	err := os.Rename(cachepath, entry.trackpath)
+	p.manager.lock.Unlock()
+	if err != nil {
+		os.Remove(cachepath)
+		return err
+	}
+	// A file without tags is still usable.
+	if err := p.manager.tagTrack(entry.job, entry.trackpath, entry.fileType); err != nil {
+		log.Printf("tag %s: %s", entry.trackpath, err)
+	}
+	if err := p.manager.recordTrack(entry.job, entry.trackpath, entry.fileType); err != nil {
+		return err
+	}
+	p.manager.notify()
+	p.manager.updatePlaylists(entry.job.Album.Id)
+	return nil
+}
+
+func NewCacheProxy(manager *Manager) *CacheProxy {
+	return &CacheProxy{
+		manager: manager,
+		entries: map[int]*cacheEntry{},
+	}
+}
@@ -0,0 +1,189 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/funte/xmlymft/common"
+)
+
+// Start a cache proxy streaming every track as the data.
+func newTestCacheProxy(t *testing.T, data []byte) (*CacheProxy, string) {
+	t.Helper()
+	m, dir := newTestManager(t)
+	m.serverURL = newTestServer(t, data).URL
+	p := NewCacheProxy(m)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Stop)
+	return p, dir
+}
+
+// Get a range of an address, the whole file if rangeHeader is empty.
+func get(t *testing.T, address string, rangeHeader string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func cacheFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "专辑", "*"+CacheSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCacheProxy(t *testing.T) {
+	data := testMP3(200 * 1024)
+	for i := 2; i < len(data); i++ {
+		data[i] = byte(i % 251)
+	}
+	p, dir := newTestCacheProxy(t, data)
+	album := common.AlbumInfo{Id: 1, Title: "专辑"}
+	open := func(trackId int) string {
+		t.Helper()
+		address, err := p.manager.TrackAddress(trackId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		address, err = p.Open(album, common.TrackInfo{Id: trackId, Name: fmt.Sprintf("第%d集", trackId)}, trackId, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(address.Address, "127.0.0.1") {
+			t.Fatalf("address %s not on the proxy", address.Address)
+		}
+		return address.Address
+	}
+
+	first := open(1)
+	// Seek before streamed to the end.
+	if status, body := get(t, first, "bytes=100000-100999"); status != http.StatusPartialContent ||
+		!bytes.Equal(body, data[100000:101000]) {
+		t.Fatalf("range: status %d, %d bytes", status, len(body))
+	}
+	if status, body := get(t, first, ""); status != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("whole file: status %d, %d bytes", status, len(body))
+	}
+	// The completed track is not tagged while it may be played, the bytes
+	// served don't shift.
+	if status, body := get(t, first, "bytes=1000-1999"); status != http.StatusPartialContent ||
+		!bytes.Equal(body, data[1000:2000]) {
+		t.Errorf("range after completed: status %d, bytes differ", status)
+	}
+	if _, ok := p.manager.Downloaded(1); ok {
+		t.Error("track saved while playing")
+	}
+	if status, _ := get(t, strings.TrimSuffix(first, ".mp3")+".m4a", ""); status != http.StatusNotFound {
+		t.Errorf("other extension: status %d, want 404", status)
+	}
+
+	// Opening another track evicts the first, saved as downloaded.
+	second := open(2)
+	record, ok := p.manager.Downloaded(1)
+	if !ok {
+		t.Fatal("completed track not saved when evicted")
+	}
+	if saved, err := os.ReadFile(record.Path); err != nil || !bytes.HasPrefix(saved, []byte("ID3")) {
+		t.Errorf("saved track not tagged: %v", err)
+	}
+	if status, _ := get(t, first, ""); status != http.StatusNotFound {
+		t.Errorf("evicted track: status %d, want 404", status)
+	}
+	p.lock.Lock()
+	entries := len(p.entries)
+	p.lock.Unlock()
+	if entries != 1 {
+		t.Errorf("%d entries kept, want 1", entries)
+	}
+
+	// An incomplete track is removed when evicted.
+	get(t, second, "bytes=0-999")
+	open(3)
+	if _, ok := p.manager.Downloaded(2); ok {
+		t.Error("incomplete track saved")
+	}
+	if files := cacheFiles(t, dir); len(files) != 1 {
+		t.Errorf("cache files %v, want only the opened track's", files)
+	}
+	p.Stop()
+	if files := cacheFiles(t, dir); len(files) != 0 {
+		t.Errorf("cache files %v left after stopped", files)
+	}
+}
+
+func TestCacheProxyNameTaken(t *testing.T) {
+	data := testMP3(10 * 1024)
+	p, dir := newTestCacheProxy(t, data)
+	album := common.AlbumInfo{Id: 1, Title: "专辑"}
+	open := func(trackId int) string {
+		t.Helper()
+		address, err := p.manager.TrackAddress(trackId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		address, err = p.Open(album, common.TrackInfo{Id: trackId, Name: "同名"}, 1, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return address.Address
+	}
+
+	first := open(1)
+	entry, _ := p.entry(1)
+	// The name is claimed, a download of another track of the name is
+	// suffixed.
+	p.manager.lock.Lock()
+	job := p.manager.newJob(album, common.TrackInfo{Id: 2, Name: "同名"}, 1)
+	p.manager.lock.Unlock()
+	if job.NameSuffix == "" {
+		t.Error("name of the streamed track not claimed")
+	}
+
+	// A file copied to the path while streaming is not overwritten.
+	writeFile(t, entry.trackpath, "user file")
+	if status, body := get(t, first, ""); status != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("whole file: status %d, %d bytes", status, len(body))
+	}
+	open(3)
+	if content, err := os.ReadFile(entry.trackpath); err != nil || string(content) != "user file" {
+		t.Errorf("unrecorded file overwritten: %q, %v", content, err)
+	}
+	if _, ok := p.manager.Downloaded(1); ok {
+		t.Error("track saved over an unrecorded file")
+	}
+	if files := cacheFiles(t, dir); len(files) != 1 {
+		t.Errorf("cache files %v, want only the opened track's", files)
+	}
+
+	// The claim is released when closed.
+	p.manager.lock.Lock()
+	defer p.manager.lock.Unlock()
+	if key, _ := nameKey(p.manager.settings, entry.job); p.manager.names[key] == 1 {
+		t.Error("name of the closed track still claimed")
+	}
+}
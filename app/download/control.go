@@ -0,0 +1,134 @@
+package download
+
+import (
+	"os"
+
+	"xmlymft-fyne-gui/app/settings"
+)
+
+// Find a job by id, requires lock.
+func (m *Manager) jobById(jobId uint) *Job {
+	for _, job := range m.jobs {
+		if job.Id == jobId {
+			return job
+		}
+	}
+	return nil
+}
+
+// Remove a job from the queue, requires lock.
+func (m *Manager) removeJob(job *Job) {
+	for i, j := range m.jobs {
+		if j == job {
+			m.jobs = append(m.jobs[:i], m.jobs[i+1:]...)
+			break
+		}
+	}
+	if key, err := nameKey(m.settings, *job); err == nil && m.names[key] == job.Track.Id {
+		delete(m.names, key)
+	}
+}
+
+// Stop a running job, requires lock.
+func (m *Manager) stop(job *Job) {
+	if cancel, running := m.cancels[job.Id]; running {
+		cancel()
+		// Wake up the job waiting for a host.
+		m.hostCond.Broadcast()
+	}
+}
+
+// Delete the partial files of a job.
+func removePartial(cfg settings.Settings, job Job) {
+	for _, fileType := range knownTypes {
+		if trackpath, err := trackPath(cfg, job, fileType); err == nil {
+			os.Remove(trackpath + PartSuffix)
+		}
+	}
+}
+
+// Pause a waiting or running job, the partial file is kept to resume.
+func (m *Manager) Pause(jobId uint) {
+	m.lock.Lock()
+	if job := m.jobById(jobId); job != nil && job.IsActive() {
+		job.State = JobPaused
+		job.Speed = 0
+		m.stop(job)
+	}
+	m.lock.Unlock()
+
+	m.saveJournal()
+	m.notify()
+}
+
+// PauseAll pause all waiting and running jobs.
+func (m *Manager) PauseAll() {
+	m.lock.Lock()
+	for _, job := range m.jobs {
+		if job.IsActive() {
+			job.State = JobPaused
+			job.Speed = 0
+			m.stop(job)
+		}
+	}
+	m.lock.Unlock()
+
+	m.saveJournal()
+	m.notify()
+}
+
+// Resume queue a paused or failed job again.
+func (m *Manager) Resume(jobId uint) {
+	m.lock.Lock()
+	if job := m.jobById(jobId); job != nil && (job.State == JobPaused || job.State == JobFailed) {
+		job.requeue()
+	}
+	m.schedule()
+	m.lock.Unlock()
+
+	m.saveJournal()
+	m.notify()
+}
+
+// ResumeAll queue all paused jobs again.
+func (m *Manager) ResumeAll() {
+	m.lock.Lock()
+	for _, job := range m.jobs {
+		if job.State == JobPaused {
+			job.requeue()
+		}
+	}
+	m.schedule()
+	m.lock.Unlock()
+
+	m.saveJournal()
+	m.notify()
+}
+
+// Cancel remove a job and delete its partial file, a done job is removed
+// from the list only.
+func (m *Manager) Cancel(jobId uint) {
+	m.lock.Lock()
+	cfg := m.settings
+	job := m.jobById(jobId)
+	if job == nil {
+		m.lock.Unlock()
+		return
+	}
+	if _, running := m.cancels[job.Id]; running {
+		// Removed when stopped.
+		m.removing[job.Id] = true
+		m.stop(job)
+		m.lock.Unlock()
+		return
+	}
+	m.removeJob(job)
+	snapshot := *job
+	m.lock.Unlock()
+
+	if snapshot.State != JobDone {
+		removePartial(cfg, snapshot)
+	}
+	m.saveJournal()
+	m.notify()
+}
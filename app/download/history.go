@@ -0,0 +1,119 @@
+package download
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// History search the download records, the latest downloaded first.
+func (m *Manager) History(keyword string) []Record {
+	return m.records.Search(keyword)
+}
+
+// Redownload download a recorded track again, returns the job id.
+func (m *Manager) Redownload(record Record) uint {
+	return m.Enqueue(record.Album, record.Track, record.Position)
+}
+
+// Open the folder containing a file with the default file manager.
+func openFolder(path string) error {
+	folderURL, err := url.Parse(storage.NewFileURI(filepath.Dir(path)).String())
+	if err != nil {
+		return err
+	}
+	return fyne.CurrentApp().OpenURL(folderURL)
+}
+
+// The download history view listing every downloaded track.
+type HistoryView struct {
+	appwin  fyne.Window
+	manager *Manager
+
+	contents    fyne.CanvasObject
+	searchEntry *widget.Entry
+	summary     *widget.Label
+	recordList  *widget.List
+
+	// Records matching the search keyword.
+	records []Record
+	// Whether the file of a record is missing, trackId -> missing.
+	missing map[int]bool
+}
+
+// Get the contents to show.
+func (v *HistoryView) Contents() fyne.CanvasObject {
+	return v.contents
+}
+
+// Refresh search the records again.
+func (v *HistoryView) Refresh() {
+	v.records = v.manager.History(v.searchEntry.Text)
+	v.missing = map[int]bool{}
+	for _, record := range v.records {
+		if _, err := os.Stat(record.Path); err != nil {
+			v.missing[record.Track.Id] = true
+		}
+	}
+	v.summary.SetText(fmt.Sprintf("共 %d 条记录, 文件缺失 %d", len(v.records), len(v.missing)))
+	v.recordList.Refresh()
+}
+
+func (v *HistoryView) openFolder(record Record) {
+	if err := openFolder(record.Path); err != nil {
+		dialog.ShowError(err, v.appwin)
+	}
+}
+
+func (v *HistoryView) redownload(record Record) {
+	v.manager.Redownload(record)
+	dialog.ShowInformation("重新下载", record.Track.Name+" 已加入下载队列", v.appwin)
+}
+
+// NewHistoryView create the history view, onBack is called when the back
+// button tapped.
+func NewHistoryView(window fyne.Window, manager *Manager, onBack func()) *HistoryView {
+	view := new(HistoryView)
+	view.appwin = window
+	view.manager = manager
+
+	view.searchEntry = widget.NewEntry()
+	view.searchEntry.SetPlaceHolder("搜索专辑, 音频或路径")
+	view.searchEntry.OnChanged = func(string) {
+		view.Refresh()
+	}
+	view.summary = widget.NewLabel("")
+	view.recordList = widget.NewList(
+		func() int {
+			return len(view.records)
+		},
+		func() fyne.CanvasObject {
+			return NewHistoryViewItem(view.openFolder, view.redownload)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			if i < len(view.records) {
+				record := view.records[i]
+				o.(*HistoryViewItem).Update(record, view.missing[record.Track.Id])
+			}
+		},
+	)
+	backBtn := widget.NewButtonWithIcon("", theme.NavigateBackIcon(), onBack)
+	backBtn.Importance = widget.LowImportance
+	header := container.NewVBox(
+		container.NewBorder(nil, nil, backBtn, nil, view.searchEntry),
+		view.summary,
+	)
+	view.contents = container.NewBorder(header, nil, nil, nil, view.recordList)
+
+	view.Refresh()
+
+	return view
+}
@@ -0,0 +1,71 @@
+package download
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+// Download history list row.
+type HistoryViewItem struct {
+	widget.BaseWidget
+
+	record Record
+
+	title         *widget.Label
+	detail        *widget.Label
+	openBtn       *widget.Button
+	redownloadBtn *widget.Button
+}
+
+func (h *HistoryViewItem) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(
+		nil, nil, nil, container.NewHBox(h.openBtn, h.redownloadBtn),
+		container.NewVBox(h.title, h.detail),
+	))
+}
+
+// Update the item with a record, missing is whether the file is missing.
+func (h *HistoryViewItem) Update(record Record, missing bool) {
+	h.record = record
+	h.title.SetText(record.Album.Title + " - " + record.Track.Name)
+
+	// Records before the history have no download time.
+	downloadedAt := "-"
+	if record.DownloadedAt > 0 {
+		downloadedAt = record.Time().Format("2006-01-02 15:04")
+	}
+	detail := fmt.Sprintf("%s  %s  %s", downloadedAt, utils.FormatBytes(record.Size), record.Type)
+	if missing {
+		detail += "  文件缺失"
+		h.openBtn.Disable()
+		h.redownloadBtn.Show()
+	} else {
+		h.openBtn.Enable()
+		h.redownloadBtn.Hide()
+	}
+	h.detail.SetText(detail)
+}
+
+func NewHistoryViewItem(onOpen func(record Record), onRedownload func(record Record)) *HistoryViewItem {
+	item := &HistoryViewItem{
+		title:  widget.NewLabel(""),
+		detail: widget.NewLabel(""),
+	}
+	item.detail.TextStyle = fyne.TextStyle{Italic: true}
+	item.openBtn = widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		onOpen(item.record)
+	})
+	item.openBtn.Importance = widget.LowImportance
+	item.redownloadBtn = widget.NewButtonWithIcon("", theme.DownloadIcon(), func() {
+		onRedownload(item.record)
+	})
+	item.redownloadBtn.Importance = widget.LowImportance
+	item.ExtendBaseWidget(item)
+	return item
+}
@@ -0,0 +1,114 @@
+package download
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+type JobState int
+
+const (
+	JobQueued JobState = iota
+	JobRunning
+	JobPaused
+	JobFailed
+	JobDone
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobQueued:
+		return "等待"
+	case JobRunning:
+		return "下载中"
+	case JobPaused:
+		return "暂停"
+	case JobFailed:
+		return "失败"
+	case JobDone:
+		return "完成"
+	}
+	return "未知"
+}
+
+// Download job of a track.
+type Job struct {
+	Id    uint
+	Album common.AlbumInfo
+	Track common.TrackInfo
+	// Track position in the album play list, starts from 1.
+	Position int
+	// Appended to the file name if the name collides with another track.
+	NameSuffix string
+	State      JobState
+	// Error message of the last failure.
+	Error string
+	// Current attempt, starts from 1.
+	Attempt int
+	// Downloaded file path.
+	Path string
+
+	// Received bytes and total bytes, total is 0 if unknown.
+	Received int64
+	Total    int64
+	// Download speed in bytes per second.
+	Speed float64
+
+	// Last speed sample.
+	sampledAt       time.Time
+	sampledReceived int64
+}
+
+// Whether the job is waiting or running.
+func (j *Job) IsActive() bool {
+	return j.State == JobQueued || j.State == JobRunning
+}
+
+// Queue the job again, the error and the attempts of the last run are
+// cleared.
+func (j *Job) requeue() {
+	j.State = JobQueued
+	j.Error = ""
+	j.Attempt = 0
+}
+
+// Download progress in [0, 1].
+func (j *Job) Progress() float64 {
+	if j.State == JobDone {
+		return 1
+	}
+	if j.Total <= 0 {
+		return 0
+	}
+	return float64(j.Received) / float64(j.Total)
+}
+
+// Estimated remaining time, 0 if unknown.
+func (j *Job) ETA() time.Duration {
+	if j.Speed <= 0 || j.Total <= 0 || j.Received >= j.Total {
+		return 0
+	}
+	return time.Duration(float64(j.Total-j.Received) / j.Speed * float64(time.Second))
+}
+
+// Short status text like "下载中 1.2 MB/s 00:35".
+func (j *Job) StatusText() string {
+	if j.State != JobRunning {
+		return j.State.String()
+	}
+	text := j.State.String()
+	if j.Attempt > 1 {
+		text += fmt.Sprintf("(重试 %d)", j.Attempt-1)
+	}
+	if j.Speed > 0 {
+		text += fmt.Sprintf(" %s/s", utils.FormatBytes(int64(j.Speed)))
+	}
+	if eta := j.ETA(); eta > 0 {
+		text += " " + utils.FormatDuration(eta)
+	}
+	return text
+}
@@ -0,0 +1,85 @@
+package download
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Download list row, shows the job state and the job controls.
+type JobViewItem struct {
+	widget.BaseWidget
+
+	manager *Manager
+	jobId   uint
+
+	title     *widget.Label
+	state     *widget.Label
+	toggleBtn *widget.Button
+	cancelBtn *widget.Button
+}
+
+func (j *JobViewItem) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(
+		nil, nil, nil, container.NewHBox(j.state, j.toggleBtn, j.cancelBtn),
+		j.title,
+	))
+}
+
+// Update the item with a job.
+func (j *JobViewItem) Update(job Job) {
+	j.jobId = job.Id
+	j.title.SetText(job.Album.Title + " - " + job.Track.Name)
+
+	state := job.StatusText()
+	switch job.State {
+	case JobRunning:
+		state = fmt.Sprintf("%.0f%% %s", job.Progress()*100, state)
+	case JobFailed:
+		state += ": " + job.Error
+	}
+	j.state.SetText(state)
+
+	switch job.State {
+	case JobQueued, JobRunning:
+		j.toggleBtn.SetIcon(theme.MediaPauseIcon())
+		j.toggleBtn.Show()
+	case JobPaused, JobFailed:
+		j.toggleBtn.SetIcon(theme.MediaPlayIcon())
+		j.toggleBtn.Show()
+	default:
+		j.toggleBtn.Hide()
+	}
+	if job.State == JobDone {
+		j.cancelBtn.SetIcon(theme.DeleteIcon())
+	} else {
+		j.cancelBtn.SetIcon(theme.CancelIcon())
+	}
+}
+
+func NewJobViewItem(manager *Manager) *JobViewItem {
+	item := &JobViewItem{
+		manager: manager,
+		title:   widget.NewLabel(""),
+		state:   widget.NewLabel(""),
+	}
+	item.toggleBtn = widget.NewButtonWithIcon("", theme.MediaPauseIcon(), func() {
+		if job, ok := manager.JobById(item.jobId); ok {
+			if job.IsActive() {
+				manager.Pause(job.Id)
+			} else {
+				manager.Resume(job.Id)
+			}
+		}
+	})
+	item.toggleBtn.Importance = widget.LowImportance
+	item.cancelBtn = widget.NewButtonWithIcon("", theme.CancelIcon(), func() {
+		manager.Cancel(item.jobId)
+	})
+	item.cancelBtn.Importance = widget.LowImportance
+	item.ExtendBaseWidget(item)
+	return item
+}
@@ -0,0 +1,90 @@
+package download
+
+import (
+	"encoding/json"
+	"os"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+const JournalFilePath = "./downloads.json"
+
+// Save the unfinished jobs to the journal file.
+func (m *Manager) saveJournal() error {
+	if m.journalPath == "" {
+		return nil
+	}
+
+	m.lock.RLock()
+	jobs := []Job{}
+	for _, job := range m.jobs {
+		if job.State != JobDone {
+			jobs = append(jobs, *job)
+		}
+	}
+	m.lock.RUnlock()
+
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+
+	m.journalLock.Lock()
+	defer m.journalLock.Unlock()
+
+	tmppath := m.journalPath + ".tmp"
+	if err = os.WriteFile(tmppath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, m.journalPath)
+}
+
+// SaveJournal save the unfinished jobs, called before exit.
+func (m *Manager) SaveJournal() error {
+	return m.saveJournal()
+}
+
+// LoadJournal restore the unfinished jobs saved in the journal file and keep
+// saving to it, returns the number of restored jobs. The restored waiting and
+// running jobs are paused until resumed. A journal failed to parse is moved
+// aside, not saved to if failed to read or move.
+func (m *Manager) LoadJournal(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		m.journalPath = path
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	jobs := []*Job{}
+	if err = json.Unmarshal(data, &jobs); err != nil {
+		moved, err := utils.SetAsideCorrupt(path, err)
+		if moved {
+			m.journalPath = path
+		}
+		return 0, err
+	}
+	m.journalPath = path
+
+	m.lock.Lock()
+	restored := 0
+	for _, job := range jobs {
+		if m.findJob(job.Track.Id) != nil {
+			continue
+		}
+		if job.IsActive() {
+			job.State = JobPaused
+		}
+		job.Speed = 0
+		if job.Id > m.nextJobId {
+			m.nextJobId = job.Id
+		}
+		m.jobs = append(m.jobs, job)
+		m.claimName(job)
+		restored++
+	}
+	m.lock.Unlock()
+
+	m.notify()
+	return restored, nil
+}
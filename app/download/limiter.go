@@ -0,0 +1,107 @@
+package download
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Max time to sleep at once while waiting for tokens, so a rate change
+// applies soon.
+const maxLimiterSleep = time.Millisecond * 100
+
+// Token bucket rate limiter shared by all downloads, the bucket holds at
+// most one second of tokens.
+type RateLimiter struct {
+	lock sync.Mutex
+	// Bytes per second, unlimited if 0.
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// SetRate change the rate in bytes per second, 0 for unlimited.
+func (l *RateLimiter) SetRate(rate int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.rate = math.Max(0, float64(rate))
+	l.tokens = math.Min(l.tokens, l.rate)
+}
+
+// WaitN block until n bytes are allowed or the context done, returns the
+// error of the context if done.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.lock.Lock()
+		if l.rate <= 0 {
+			l.lock.Unlock()
+			return nil
+		}
+		now := time.Now()
+		if !l.last.IsZero() {
+			l.tokens = math.Min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		}
+		l.last = now
+		// Requests larger than the bucket take the whole bucket, readers
+		// limit the reads by chunkSize to avoid this.
+		need := math.Min(float64(n), l.rate)
+		if l.tokens >= need {
+			l.tokens -= need
+			l.lock.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		l.lock.Unlock()
+
+		if wait > maxLimiterSleep {
+			wait = maxLimiterSleep
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Max bytes to read at once, a tenth of the rate.
+func (l *RateLimiter) chunkSize(n int) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate <= 0 {
+		return n
+	}
+	return int(math.Max(1, math.Min(float64(n), l.rate/10)))
+}
+
+func NewRateLimiter(rate int64) *RateLimiter {
+	limiter := new(RateLimiter)
+	limiter.SetRate(rate)
+	return limiter
+}
+
+// Reader limited by a rate limiter, stops waiting when the context done.
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+func (r *limitedReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b[:r.limiter.chunkSize(len(b))])
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,123 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l := NewRateLimiter(0)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := l.WaitN(context.Background(), 1<<20); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("unlimited waited %v", elapsed)
+	}
+	if n := l.chunkSize(4096); n != 4096 {
+		t.Errorf("unlimited chunk size = %d, want 4096", n)
+	}
+}
+
+func TestRateLimiterRate(t *testing.T) {
+	const rate = 10000
+	l := NewRateLimiter(rate)
+	if n := l.chunkSize(1 << 20); n != rate/10 {
+		t.Errorf("chunk size = %d, want %d", n, rate/10)
+	}
+	// The bucket starts empty, 3000 bytes take about 0.3s.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.WaitN(context.Background(), 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > time.Second {
+		t.Errorf("3000 bytes at %d B/s took %v, want about 300ms", rate, elapsed)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := NewRateLimiter(10000)
+	l.WaitN(context.Background(), 1)
+	// Tokens filled while idle are used at once.
+	time.Sleep(150 * time.Millisecond)
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("waited %v with tokens filled", elapsed)
+	}
+
+	// A lower rate limits the tokens held to the new rate.
+	l.SetRate(100)
+	l.lock.Lock()
+	tokens := l.tokens
+	l.lock.Unlock()
+	if tokens > 100 {
+		t.Errorf("bucket holds %v tokens after lowering the rate, want at most 100", tokens)
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	l := NewRateLimiter(100)
+	done := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		l.WaitN(context.Background(), 100)
+		done <- time.Since(start)
+	}()
+	// At 100 B/s the wait takes a second, raised at runtime it ends soon.
+	time.Sleep(50 * time.Millisecond)
+	l.SetRate(1 << 20)
+	select {
+	case elapsed := <-done:
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("waited %v after the rate raised", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("still waiting after the rate raised")
+	}
+
+	// Unlimited at runtime.
+	l.SetRate(1)
+	go func() {
+		start := time.Now()
+		l.WaitN(context.Background(), 100)
+		done <- time.Since(start)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	l.SetRate(0)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after the limit removed")
+	}
+}
+
+func TestRateLimiterContext(t *testing.T) {
+	l := NewRateLimiter(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- l.WaitN(ctx, 1000)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after canceled")
+	}
+	if err := l.WaitN(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v with a done context, want canceled", err)
+	}
+}
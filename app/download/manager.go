@@ -0,0 +1,663 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/settings"
+	"xmlymft-fyne-gui/app/tags"
+	"xmlymft-fyne-gui/utils"
+)
+
+// Track file types returned by the server.
+var knownTypes = []string{"m4a", "mp3"}
+
+// Min interval between progress notifications.
+const progressNotifyInterval = time.Millisecond * 200
+
+// Min interval between download speed samples.
+const speedSampleInterval = time.Second
+
+// A track and its position in the album play list, starts from 1.
+type AlbumTrack struct {
+	Track    common.TrackInfo
+	Position int
+}
+
+type Manager struct {
+	serverURL string
+	settings  settings.Settings
+	records   *Records
+
+	lock sync.RWMutex
+	// All jobs in enqueued order.
+	jobs      []*Job
+	nextJobId uint
+	// Number of running jobs.
+	running uint
+	// Cancel functions of the running jobs: jobId -> cancel.
+	cancels map[uint]context.CancelFunc
+	// Canceled running jobs to remove when stopped.
+	removing map[uint]bool
+	// Number of downloads per host, waited by hostCond.
+	hosts    map[string]int
+	hostCond *sync.Cond
+	limiter  *RateLimiter
+	// Album cover image cache: albumId -> image data.
+	covers map[int][]byte
+	// Claimed file names without extension: lower case path -> track id.
+	names map[string]int
+
+	// Unfinished jobs are saved to the journal file if set.
+	journalPath string
+	journalLock sync.Mutex
+
+	// Serialize writing playlists.
+	playlistLock sync.Mutex
+
+	listeners []func()
+	// Last time listeners notified of progress.
+	progressNotifiedAt time.Time
+	// Last time the free space checked.
+	spaceCheckedAt time.Time
+
+	// Called when a job failed.
+	OnFailed func(job Job, err error)
+	// Called when the queue paused for low disk space.
+	OnLowSpace func(err error)
+}
+
+// Enqueue add a track download job and returns the job id.
+// If the track is already in the queue, returns the existing job id, a paused,
+// failed or done job is queued again.
+func (m *Manager) Enqueue(album common.AlbumInfo, track common.TrackInfo, position int) uint {
+	m.lock.Lock()
+	id := m.enqueue(album, track, position).Id
+	m.schedule()
+	m.lock.Unlock()
+
+	m.saveJournal()
+	m.notify()
+	return id
+}
+
+// EnqueueAll add download jobs of tracks of an album, tracks already on
+// disk or in the queue are skipped.
+func (m *Manager) EnqueueAll(album common.AlbumInfo, tracks []AlbumTrack) (queued int, skipped int) {
+	m.lock.Lock()
+	for _, track := range tracks {
+		if job := m.findJob(track.Track.Id); job != nil && job.State != JobFailed {
+			skipped++
+			continue
+		}
+		if m.isDownloaded(track.Track.Id) {
+			skipped++
+			continue
+		}
+		m.addJob(m.newJob(album, track.Track, track.Position))
+		queued++
+	}
+	m.schedule()
+	m.lock.Unlock()
+
+	m.saveJournal()
+	m.notify()
+	return
+}
+
+// Jobs returns a snapshot of all jobs.
+func (m *Manager) Jobs() []Job {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	jobs := make([]Job, len(m.jobs))
+	for i, job := range m.jobs {
+		jobs[i] = *job
+	}
+	return jobs
+}
+
+// Job returns a snapshot of the job of a track.
+func (m *Manager) Job(trackId int) (Job, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	job := m.findJob(trackId)
+	if job == nil {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// JobById returns a snapshot of a job.
+func (m *Manager) JobById(jobId uint) (Job, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	job := m.jobById(jobId)
+	if job == nil {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Progress returns the aggregate progress of the running and queued jobs.
+// total is 0 if unknown.
+func (m *Manager) Progress() (active int, received int64, total int64) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, job := range m.jobs {
+		if !job.IsActive() {
+			continue
+		}
+		active++
+		received += job.Received
+		total += job.Total
+	}
+	return
+}
+
+// Settings returns the current settings.
+func (m *Manager) Settings() settings.Settings {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.settings
+}
+
+// ApplySettings change the settings. The download limits apply to running
+// jobs at once, other settings apply to jobs started later.
+func (m *Manager) ApplySettings(cfg settings.Settings) {
+	m.limiter.SetRate(int64(cfg.RateLimit) * 1024)
+
+	m.lock.Lock()
+	m.settings = cfg
+	// More downloads may be allowed.
+	m.schedule()
+	m.hostCond.Broadcast()
+	m.names = map[string]int{}
+	for _, job := range m.jobs {
+		if job.NameSuffix == "" {
+			m.claimName(job)
+		}
+	}
+	m.lock.Unlock()
+}
+
+// AddListener add a function called whenever any job changed.
+func (m *Manager) AddListener(listener func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.listeners = append(m.listeners, listener)
+}
+
+func (m *Manager) notify() {
+	m.lock.RLock()
+	listeners := m.listeners
+	m.lock.RUnlock()
+
+	for _, listener := range listeners {
+		listener()
+	}
+}
+
+// Add a job or queue the failed job again, requires lock.
+func (m *Manager) enqueue(album common.AlbumInfo, track common.TrackInfo, position int) *Job {
+	job := m.findJob(track.Id)
+	if job == nil {
+		job = m.newJob(album, track, position)
+		m.addJob(job)
+	} else if !job.IsActive() {
+		job.requeue()
+		job.Received, job.Total = 0, 0
+	}
+	return job
+}
+
+// Create a job not in the queue yet, requires lock.
+// If the file name is taken by another track, the track id is appended to
+// the name. Names are claimed in enqueued order and the downloaded files are
+// owned by their records, so the same tracks always get the same names.
+func (m *Manager) newJob(album common.AlbumInfo, track common.TrackInfo, position int) *Job {
+	job := &Job{
+		Album:    album,
+		Track:    track,
+		Position: position,
+		State:    JobQueued,
+	}
+	if m.nameTaken(*job) {
+		job.NameSuffix = nameSuffix(track.Id)
+	}
+	return job
+}
+
+// Suffix of a file name with the track id, unique to the track.
+func nameSuffix(trackId int) string {
+	return fmt.Sprintf(" (%d)", trackId)
+}
+
+// Whether the file name of a job without suffix is taken by another track,
+// requires lock. A name is taken if claimed by another job in the queue, or a
+// file with the name is recorded for another track or not recorded at all, as
+// an unrecorded file can't be told to belong to the track.
+func (m *Manager) nameTaken(job Job) bool {
+	job.NameSuffix = ""
+	key, err := nameKey(m.settings, job)
+	if err != nil {
+		return false
+	}
+	if trackId, claimed := m.names[key]; claimed {
+		return trackId != job.Track.Id
+	}
+	for _, fileType := range knownTypes {
+		trackpath, err := trackPath(m.settings, job, fileType)
+		if err != nil {
+			return false
+		}
+		if m.fileTaken(job.Track.Id, trackpath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a file exists and is recorded for another track or not recorded.
+func (m *Manager) fileTaken(trackId int, trackpath string) bool {
+	if owner, recorded := m.records.Owner(trackpath); recorded {
+		return owner != trackId
+	}
+	_, err := os.Stat(trackpath)
+	return err == nil
+}
+
+// Add a job created by newJob to the queue, requires lock.
+func (m *Manager) addJob(job *Job) {
+	m.nextJobId++
+	job.Id = m.nextJobId
+	m.jobs = append(m.jobs, job)
+	m.claimName(job)
+}
+
+// Set the name suffix of a job found taken when started.
+func (m *Manager) setNameSuffix(jobId uint, suffix string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if job := m.jobById(jobId); job != nil {
+		job.NameSuffix = suffix
+	}
+}
+
+// Claim the file name of a job if not claimed, requires lock.
+func (m *Manager) claimName(job *Job) {
+	key, err := nameKey(m.settings, *job)
+	if err != nil {
+		return
+	}
+	if _, claimed := m.names[key]; !claimed {
+		m.names[key] = job.Track.Id
+	}
+}
+
+// Release the file name claimed for a track not in the queue, requires lock.
+func (m *Manager) releaseName(job Job) {
+	if m.findJob(job.Track.Id) != nil {
+		return
+	}
+	if key, err := nameKey(m.settings, job); err == nil && m.names[key] == job.Track.Id {
+		delete(m.names, key)
+	}
+}
+
+// Find the job of a track, requires lock.
+func (m *Manager) findJob(trackId int) *Job {
+	for _, job := range m.jobs {
+		if job.Track.Id == trackId {
+			return job
+		}
+	}
+	return nil
+}
+
+// Start queued jobs until all workers are busy, requires lock. The cancel
+// function is registered with the state change, so a job paused or canceled
+// right after started is stopped.
+func (m *Manager) schedule() {
+	for _, job := range m.jobs {
+		if m.running >= uint(m.settings.MaxDownloads) {
+			break
+		}
+		// A paused job resumed before stopped waits for the stop.
+		if _, stopping := m.cancels[job.Id]; job.State == JobQueued && !stopping {
+			ctx, cancel := context.WithCancel(context.Background())
+			job.State = JobRunning
+			m.running++
+			m.cancels[job.Id] = cancel
+			go m.run(ctx, job)
+		}
+	}
+}
+
+func (m *Manager) run(ctx context.Context, job *Job) {
+	m.lock.Lock()
+	snapshot, cfg := *job, m.settings
+	m.lock.Unlock()
+
+	m.notify()
+	onProgress := func(received int64, total int64) {
+		m.updateProgress(job, received, total)
+		m.watchSpace(0)
+	}
+	var trackpath string
+	var err error
+	for attempt := 1; ; attempt++ {
+		// The track address is queried again for each attempt, as the signed
+		// address may expire.
+		trackpath, err = m.downloadTrack(ctx, cfg, snapshot, onProgress)
+		if err == nil || ctx.Err() != nil || attempt >= cfg.RetryAttempts {
+			break
+		}
+		m.lock.Lock()
+		job.Attempt = attempt + 1
+		job.Error = err.Error()
+		m.lock.Unlock()
+		m.notify()
+		select {
+		case <-time.After(retryDelay(cfg, attempt)):
+		case <-ctx.Done():
+		}
+	}
+
+	m.lock.Lock()
+	m.running--
+	stopped := ctx.Err() != nil
+	// Release the context.
+	m.cancels[job.Id]()
+	delete(m.cancels, job.Id)
+	if stopped {
+		// Paused or canceled, the partial file is kept to resume.
+		err = nil
+		if m.removing[job.Id] {
+			delete(m.removing, job.Id)
+			m.removeJob(job)
+			go removePartial(cfg, snapshot)
+		}
+	} else if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.State = JobDone
+		job.Path = trackpath
+	}
+	job.Speed = 0
+	snapshot = *job
+	m.schedule()
+	m.lock.Unlock()
+
+	if err := m.saveJournal(); err != nil {
+		log.Printf("save download journal: %s", err)
+	}
+	m.notify()
+	if err != nil && m.OnFailed != nil {
+		m.OnFailed(snapshot, err)
+	}
+	if snapshot.State == JobDone {
+		m.updatePlaylists(snapshot.Album.Id)
+	}
+}
+
+func (m *Manager) updateProgress(job *Job, received int64, total int64) {
+	now := time.Now()
+
+	m.lock.Lock()
+	job.Received = received
+	job.Total = total
+	if job.sampledAt.IsZero() || received < job.sampledReceived {
+		job.sampledAt, job.sampledReceived = now, received
+	} else if elapsed := now.Sub(job.sampledAt); elapsed >= speedSampleInterval {
+		job.Speed = float64(received-job.sampledReceived) / elapsed.Seconds()
+		job.sampledAt, job.sampledReceived = now, received
+	}
+	shouldNotify := now.Sub(m.progressNotifiedAt) >= progressNotifyInterval
+	if shouldNotify {
+		m.progressNotifiedAt = now
+	}
+	m.lock.Unlock()
+
+	if shouldNotify {
+		m.notify()
+	}
+}
+
+// Get the file path of a job with the file type.
+func trackPath(cfg settings.Settings, job Job, fileType string) (string, error) {
+	root, err := cfg.DownloadRoot()
+	if err != nil {
+		return "", err
+	}
+	name, err := expandTemplate(cfg.NameTemplate, job.Album, job.Track, job.Position, fileType)
+	if err != nil {
+		return "", err
+	}
+	if job.NameSuffix != "" {
+		ext := filepath.Ext(name)
+		name = strings.TrimSuffix(name, ext) + job.NameSuffix + ext
+	}
+	trackpath := filepath.Clean(filepath.Join(root, name))
+	if !utils.IsWithin(root, trackpath) {
+		return "", fmt.Errorf("%s is outside the download directory", trackpath)
+	}
+	return trackpath, nil
+}
+
+// Key of the claimed file name of a job.
+func nameKey(cfg settings.Settings, job Job) (string, error) {
+	job.NameSuffix = ""
+	trackpath, err := trackPath(cfg, job, "")
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(trackpath), nil
+}
+
+// TrackAddress query the address of a track, the address may expire.
+func (m *Manager) TrackAddress(trackId int) (common.QueryTrackAddressResult, error) {
+	url := fmt.Sprintf("%s/track?id=%s", m.serverURL, strconv.Itoa(trackId))
+	// trackAddressResp, err := utils.HTTPGet[utils.QueryTrackAddressResponse](url)
+	trackAddressResp, err := utils.HTTPGetQueryTrackAddressResponse(url)
+	if err != nil {
+		return common.QueryTrackAddressResult{}, err
+	}
+	if trackAddressResp.Error != "" {
+		return common.QueryTrackAddressResult{}, errors.New(trackAddressResp.Error)
+	}
+	return trackAddressResp.Data, nil
+}
+
+// Downloaded returns the record of a track if its file is intact.
+func (m *Manager) Downloaded(trackId int) (Record, bool) {
+	record, ok := m.records.Get(trackId)
+	if !ok || !isRecordIntact(record) {
+		return Record{}, false
+	}
+	return record, true
+}
+
+// Download a track and returns the file path.
+func (m *Manager) downloadTrack(
+	ctx context.Context, cfg settings.Settings, job Job,
+	onProgress func(received int64, total int64),
+) (string, error) {
+	queryTrackAddressResult, err := m.TrackAddress(job.Track.Id)
+	if err != nil {
+		return "", err
+	}
+	if err = ctx.Err(); err != nil {
+		return "", err
+	}
+
+	trackpath, err := trackPath(cfg, job, queryTrackAddressResult.Type)
+	if err != nil {
+		return "", err
+	}
+	// The track may be downloaded before under another path, e.g. with another
+	// name template or download directory.
+	if record, ok := m.records.Get(job.Track.Id); ok && record.Path != trackpath && isRecordIntact(record) {
+		return record.Path, nil
+	}
+	// The name may be taken after the job created, e.g. by a file copied into
+	// the download directory, which is never adopted or overwritten.
+	if job.NameSuffix == "" && m.fileTaken(job.Track.Id, trackpath) {
+		job.NameSuffix = nameSuffix(job.Track.Id)
+		m.setNameSuffix(job.Id, job.NameSuffix)
+		if trackpath, err = trackPath(cfg, job, queryTrackAddressResult.Type); err != nil {
+			return "", err
+		}
+	}
+	os.MkdirAll(filepath.Dir(trackpath), 0755)
+	// If track file exists and is intact.
+	if _, err = os.Stat(trackpath); err == nil {
+		if m.isIntact(job, trackpath, queryTrackAddressResult.Type) {
+			return trackpath, nil
+		}
+		if _, recorded := m.records.Owner(trackpath); recorded {
+			return "", fmt.Errorf("%s is the download of another track", trackpath)
+		}
+		os.Remove(trackpath)
+	}
+	// Pausing the queue stops this job too.
+	if err = m.pauseIfLowSpace(int64(queryTrackAddressResult.ByteSize)); err != nil {
+		return "", err
+	}
+	// Download and write.
+	host := hostOf(queryTrackAddressResult.Address)
+	if err = m.acquireHost(ctx, host); err != nil {
+		return "", err
+	}
+	err = transfer(
+		ctx, queryTrackAddressResult.Address, trackpath,
+		int64(queryTrackAddressResult.ByteSize), onProgress, m.limiter,
+	)
+	m.releaseHost(host)
+	if err != nil {
+		return "", err
+	}
+	if err = tags.Validate(trackpath, queryTrackAddressResult.Type); err != nil {
+		os.Remove(trackpath)
+		return "", err
+	}
+	// A file without tags is still usable.
+	if err = m.tagTrack(job, trackpath, queryTrackAddressResult.Type); err != nil {
+		log.Printf("tag %s: %s", trackpath, err)
+	}
+	return trackpath, m.recordTrack(job, trackpath, queryTrackAddressResult.Type)
+}
+
+// Get the host of an URL, "" if invalid.
+func hostOf(address string) string {
+	u, err := url.Parse(address)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// Wait until a download from the host is allowed or the job stopped.
+func (m *Manager) acquireHost(ctx context.Context, host string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for m.settings.MaxDownloadsPerHost > 0 && m.hosts[host] >= m.settings.MaxDownloadsPerHost {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m.hostCond.Wait()
+	}
+	m.hosts[host]++
+	return nil
+}
+
+func (m *Manager) releaseHost(host string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.hosts[host]--
+	if m.hosts[host] <= 0 {
+		delete(m.hosts, host)
+	}
+	m.hostCond.Broadcast()
+}
+
+// Check an existing track file matches its record, or has valid headers if
+// not recorded. An unrecorded valid file is recorded only if its name has the
+// track id suffix, a file under the plain name may be another track.
+func (m *Manager) isIntact(job Job, trackpath string, fileType string) bool {
+	if record, ok := m.records.Get(job.Track.Id); ok && record.Path == trackpath {
+		return isRecordIntact(record)
+	}
+	if _, recorded := m.records.Owner(trackpath); recorded || job.NameSuffix != nameSuffix(job.Track.Id) {
+		return false
+	}
+	if tags.Validate(trackpath, fileType) != nil {
+		return false
+	}
+	return m.recordTrack(job, trackpath, fileType) == nil
+}
+
+// Whether the recorded file exists with the recorded size.
+func isRecordIntact(record Record) bool {
+	info, err := os.Stat(record.Path)
+	return err == nil && info.Size() == record.Size
+}
+
+// Whether the track is recorded and the file is intact.
+func (m *Manager) isDownloaded(trackId int) bool {
+	record, ok := m.records.Get(trackId)
+	return ok && isRecordIntact(record)
+}
+
+// Record the size and hash of a downloaded track file.
+func (m *Manager) recordTrack(job Job, trackpath string, fileType string) error {
+	size, digest, err := fileDigest(trackpath)
+	if err != nil {
+		return err
+	}
+	return m.records.Put(Record{
+		Album:        job.Album,
+		Track:        job.Track,
+		Position:     job.Position,
+		Path:         trackpath,
+		Size:         size,
+		SHA256:       digest,
+		Type:         fileType,
+		DownloadedAt: time.Now().Unix(),
+	})
+}
+
+func NewManager(serverURL string, cfg settings.Settings, records *Records) *Manager {
+	manager := new(Manager)
+	manager.serverURL = serverURL
+	manager.settings = cfg
+	manager.records = records
+	manager.cancels = map[uint]context.CancelFunc{}
+	manager.removing = map[uint]bool{}
+	manager.hosts = map[string]int{}
+	manager.hostCond = sync.NewCond(&manager.lock)
+	manager.limiter = NewRateLimiter(int64(cfg.RateLimit) * 1024)
+	manager.covers = map[int][]byte{}
+	manager.names = map[string]int{}
+	return manager
+}
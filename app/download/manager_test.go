@@ -0,0 +1,274 @@
+package download
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/settings"
+)
+
+// Create a manager downloading to a temporary directory.
+func newTestManager(t *testing.T) (*Manager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	records, err := LoadRecords(filepath.Join(dir, "records.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := *settings.NewSettings()
+	cfg.DownloadDir = dir
+	return NewManager("http://127.0.0.1:0", cfg, records), dir
+}
+
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewJobNameCollision(t *testing.T) {
+	album := common.AlbumInfo{Id: 1, Title: "专辑"}
+	first := common.TrackInfo{Id: 10, Name: "第1集"}
+	second := common.TrackInfo{Id: 20, Name: "第1集"}
+
+	t.Run("claimed in queue", func(t *testing.T) {
+		m, _ := newTestManager(t)
+		m.addJob(m.newJob(album, first, 1))
+		if job := m.newJob(album, second, 2); job.NameSuffix != " (20)" {
+			t.Errorf("suffix = %q, want %q", job.NameSuffix, " (20)")
+		}
+		if job := m.newJob(album, first, 1); job.NameSuffix != "" {
+			t.Errorf("suffix of the claiming track = %q, want none", job.NameSuffix)
+		}
+	})
+
+	t.Run("recorded for another track", func(t *testing.T) {
+		m, dir := newTestManager(t)
+		trackpath := filepath.Join(dir, "专辑", "第1集.m4a")
+		writeFile(t, trackpath, "data")
+		if err := m.records.Put(Record{Album: album, Track: first, Path: trackpath, Size: 4}); err != nil {
+			t.Fatal(err)
+		}
+		// As after a restart, nothing claimed in the queue.
+		job := m.newJob(album, second, 2)
+		if job.NameSuffix != " (20)" {
+			t.Fatalf("suffix = %q, want %q", job.NameSuffix, " (20)")
+		}
+		if m.isIntact(*job, trackpath, "m4a") {
+			t.Error("file of another track is intact for the job")
+		}
+		if owner, _ := m.records.Owner(trackpath); owner != first.Id {
+			t.Errorf("owner = %d, want %d", owner, first.Id)
+		}
+		if job := m.newJob(album, first, 1); job.NameSuffix != "" {
+			t.Errorf("suffix of the recorded track = %q, want none", job.NameSuffix)
+		}
+	})
+
+	t.Run("unrecorded file", func(t *testing.T) {
+		m, dir := newTestManager(t)
+		trackpath := filepath.Join(dir, "专辑", "第1集.mp3")
+		writeFile(t, trackpath, "data")
+		job := m.newJob(album, second, 2)
+		if job.NameSuffix != " (20)" {
+			t.Fatalf("suffix = %q, want %q", job.NameSuffix, " (20)")
+		}
+		plain := *job
+		plain.NameSuffix = ""
+		if m.isIntact(plain, trackpath, "mp3") {
+			t.Error("unrecorded file under the plain name is adopted")
+		}
+		if _, recorded := m.records.Get(second.Id); recorded {
+			t.Error("unrecorded file is recorded")
+		}
+	})
+
+	t.Run("free name", func(t *testing.T) {
+		m, _ := newTestManager(t)
+		if job := m.newJob(album, second, 2); job.NameSuffix != "" {
+			t.Errorf("suffix = %q, want none", job.NameSuffix)
+		}
+	})
+}
+
+func TestEnqueueAllSkipsOnlyOwnDownloads(t *testing.T) {
+	m, dir := newTestManager(t)
+	// No download is started.
+	m.settings.MaxDownloads = 0
+	album := common.AlbumInfo{Id: 1, Title: "专辑"}
+	tracks := []AlbumTrack{
+		{Track: common.TrackInfo{Id: 10, Name: "第1集"}, Position: 1},
+		{Track: common.TrackInfo{Id: 20, Name: "第2集"}, Position: 2},
+	}
+	downloaded := filepath.Join(dir, "专辑", "第1集.m4a")
+	writeFile(t, downloaded, "data")
+	if err := m.records.Put(Record{Album: album, Track: tracks[0].Track, Path: downloaded, Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	// Another track's file under the name of the second track.
+	writeFile(t, filepath.Join(dir, "专辑", "第2集.mp3"), "data")
+
+	queued, skipped := m.EnqueueAll(album, tracks)
+	if queued != 1 || skipped != 1 {
+		t.Fatalf("queued %d, skipped %d, want 1 and 1", queued, skipped)
+	}
+	job, ok := m.Job(20)
+	if !ok || job.NameSuffix != " (20)" {
+		t.Errorf("job = %+v, want the suffix %q", job, " (20)")
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	m, dir := newTestManager(t)
+	album := common.AlbumInfo{Id: 1, Title: "专辑"}
+	tracks := []AlbumTrack{}
+	for i, state := range []JobState{JobQueued, JobRunning, JobPaused, JobFailed, JobDone} {
+		track := common.TrackInfo{Id: 10 * (i + 1), Name: fmt.Sprintf("第%d集", i+1), Duration: 60}
+		job := m.newJob(album, track, i+1)
+		job.State = state
+		m.addJob(job)
+		tracks = append(tracks, AlbumTrack{Track: track, Position: i + 1})
+	}
+	// Downloaded without a job, and a new track.
+	downloaded := common.TrackInfo{Id: 60, Name: "第6集", Duration: 60}
+	trackpath := filepath.Join(dir, "专辑", "第6集.mp3")
+	writeFile(t, trackpath, "data")
+	if err := m.records.Put(Record{Album: album, Track: downloaded, Path: trackpath, Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	tracks = append(tracks,
+		AlbumTrack{Track: downloaded, Position: 6},
+		AlbumTrack{Track: common.TrackInfo{Id: 70, Name: "第7集", Duration: 60}, Position: 7},
+	)
+
+	// The failed, done but not recorded, and new tracks.
+	if size, want := m.EstimateSize(tracks), int64(3*60*EstimatedBitrate/8); size != want {
+		t.Errorf("size = %d, want %d", size, want)
+	}
+}
+
+// An mp3 file with a frame header, valid for tags.Validate.
+func testMP3(size int) []byte {
+	data := make([]byte, size)
+	data[0], data[1] = 0xff, 0xfb
+	return data
+}
+
+// Start a server of the track addresses and the track files, every track is
+// the data.
+func newTestServer(t *testing.T, data []byte) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/track", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": common.QueryTrackAddressResult{
+				Type:     "mp3",
+				ByteSize: len(data),
+				Address:  server.URL + "/files/" + r.URL.Query().Get("id") + ".mp3",
+			},
+		})
+	})
+	mux.HandleFunc("/files/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "track.mp3", time.Time{}, bytes.NewReader(data))
+	})
+	return server
+}
+
+// Wait until no job is running.
+func waitIdle(t *testing.T, m *Manager) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.lock.RLock()
+		running := m.running
+		m.lock.RUnlock()
+		if running == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("jobs still running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	m, dir := newTestManager(t)
+	m.serverURL = newTestServer(t, testMP3(1000)).URL
+	album := common.AlbumInfo{Id: 1, Title: "专辑"}
+	m.Enqueue(album, common.TrackInfo{Id: 10, Name: "第1集"}, 1)
+	waitIdle(t, m)
+
+	job, _ := m.Job(10)
+	if job.State != JobDone {
+		t.Fatalf("job %v: %s", job.State, job.Error)
+	}
+	if want := filepath.Join(dir, "专辑", "第1集.mp3"); job.Path != want {
+		t.Errorf("path = %s, want %s", job.Path, want)
+	}
+	if _, ok := m.Downloaded(10); !ok {
+		t.Error("downloaded track not recorded")
+	}
+}
+
+func TestResumeClearsLastRun(t *testing.T) {
+	m, _ := newTestManager(t)
+	// No download is started.
+	m.settings.MaxDownloads = 0
+	album := common.AlbumInfo{Id: 1, Title: "专辑"}
+	for _, resume := range []func(jobId uint){m.Resume, func(uint) { m.ResumeAll() }} {
+		jobId := m.Enqueue(album, common.TrackInfo{Id: 1, Name: "第1集"}, 1)
+		// Paused while retrying.
+		m.lock.Lock()
+		job := m.jobById(jobId)
+		job.State, job.Error, job.Attempt = JobPaused, "timeout", 3
+		m.lock.Unlock()
+
+		resume(jobId)
+		if job, _ := m.Job(1); job.State != JobQueued || job.Error != "" || job.Attempt != 0 {
+			t.Errorf("resumed job is %v, error %q, attempt %d", job.State, job.Error, job.Attempt)
+		}
+	}
+}
+
+func TestPauseAndCancelRightAfterStarted(t *testing.T) {
+	m, dir := newTestManager(t)
+	m.serverURL = newTestServer(t, testMP3(1000)).URL
+	album := common.AlbumInfo{Id: 1, Title: "专辑"}
+	for i := 1; i <= 20; i++ {
+		paused := m.Enqueue(album, common.TrackInfo{Id: i, Name: fmt.Sprintf("暂停%d", i)}, i)
+		m.Pause(paused)
+		canceled := m.Enqueue(album, common.TrackInfo{Id: 100 + i, Name: fmt.Sprintf("取消%d", i)}, i)
+		m.Cancel(canceled)
+	}
+	waitIdle(t, m)
+
+	jobs := m.Jobs()
+	if len(jobs) != 20 {
+		t.Errorf("%d jobs left, want the 20 paused", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.State != JobPaused {
+			t.Errorf("job of %s is %v, want paused", job.Track.Name, job.State)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "专辑", "*.mp3"))
+	if len(files) != 0 {
+		t.Errorf("downloaded %v", files)
+	}
+}
@@ -0,0 +1,91 @@
+package download
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+// Placeholders like "{track}" or "{index:04}" in the naming template.
+var placeholderRegexp = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+
+// ValidateTemplate check the naming template has known placeholders only.
+func ValidateTemplate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("empty naming template")
+	}
+	for _, match := range placeholderRegexp.FindAllStringSubmatch(template, -1) {
+		if !isPlaceholder(match[1]) {
+			return fmt.Errorf("unknown placeholder %s", match[0])
+		}
+	}
+	if filepath.IsAbs(template) {
+		return fmt.Errorf("naming template must be a relative path")
+	}
+	return nil
+}
+
+func isPlaceholder(name string) bool {
+	switch name {
+	case "album", "albumId", "track", "trackId", "index", "order", "ext":
+		return true
+	}
+	return false
+}
+
+// Expand the naming template to a relative file path.
+// Each placeholder value is sanitized as a single file name, so a "/" in the
+// album title never creates a subdirectory, the expanded path is sanitized
+// again per element.
+//
+// Placeholders:
+//
+//	{album}    album title
+//	{albumId}  album id
+//	{track}    track name
+//	{trackId}  track id
+//	{index}    track position in the album play list, starts from 1
+//	{order}    track upload order given by the server
+//	{ext}      file type like "m4a"
+//
+// Number placeholders accept a zero padded width like "{index:04}".
+func expandTemplate(
+	template string,
+	album common.AlbumInfo, track common.TrackInfo, position int, fileType string,
+) (string, error) {
+	if err := ValidateTemplate(template); err != nil {
+		return "", err
+	}
+	name := placeholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		match := placeholderRegexp.FindStringSubmatch(placeholder)
+		width, _ := strconv.Atoi(match[2])
+		number := func(n int) string {
+			return fmt.Sprintf("%0*d", width, n)
+		}
+		value := placeholder
+		switch match[1] {
+		case "album":
+			value = album.Title
+		case "albumId":
+			value = number(album.Id)
+		case "track":
+			value = track.Name
+		case "trackId":
+			value = number(track.Id)
+		case "index":
+			value = number(position)
+		case "order":
+			value = number(track.Index)
+		case "ext":
+			value = fileType
+		}
+		return utils.SanitizeFilename(value)
+	})
+	return utils.SanitizePath(name), nil
+}
@@ -0,0 +1,118 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+// Name of the playlist of all downloaded tracks in the download root.
+const GlobalPlaylistName = "all.m3u8"
+
+const PlaylistExt = ".m3u8"
+
+// Build an extended M3U playlist of the records, track paths are relative to
+// dir if possible.
+func buildPlaylist(dir string, records []Record) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	for _, record := range records {
+		trackpath := record.Path
+		if rel, err := filepath.Rel(dir, record.Path); err == nil {
+			trackpath = rel
+		}
+		// Titles are single line.
+		title := strings.Join(strings.Fields(record.Album.Title+" - "+record.Track.Name), " ")
+		fmt.Fprintf(&buf, "#EXTINF:%d,%s\n", record.Track.Duration, title)
+		buf.WriteString(filepath.ToSlash(trackpath) + "\n")
+	}
+	return buf.Bytes()
+}
+
+// Write a playlist file.
+func writePlaylist(path string, records []Record) error {
+	tmppath := path + ".tmp"
+	if err := os.WriteFile(tmppath, buildPlaylist(filepath.Dir(path), records), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, path)
+}
+
+// Downloaded records of an album whose files exist, ordered by the album track
+// order.
+func (m *Manager) albumRecords(albumId int) []Record {
+	records := []Record{}
+	for _, record := range m.records.All() {
+		if record.Album.Id != albumId {
+			continue
+		}
+		if _, err := os.Stat(record.Path); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// WritePlaylists write the playlists of an album, one in each directory
+// containing its tracks, and the global playlist if enabled.
+func (m *Manager) WritePlaylists(albumId int) error {
+	m.playlistLock.Lock()
+	defer m.playlistLock.Unlock()
+
+	cfg := m.Settings()
+
+	records := m.albumRecords(albumId)
+	dirs := map[string][]Record{}
+	for _, record := range records {
+		dir := filepath.Dir(record.Path)
+		dirs[dir] = append(dirs[dir], record)
+	}
+	for dir, records := range dirs {
+		name := utils.SanitizeFilename(records[0].Album.Title) + PlaylistExt
+		if err := writePlaylist(filepath.Join(dir, name), records); err != nil {
+			return err
+		}
+	}
+
+	if !cfg.GlobalPlaylist {
+		return nil
+	}
+	root, err := cfg.DownloadRoot()
+	if err != nil {
+		return err
+	}
+	return m.writeGlobalPlaylist(root)
+}
+
+// Write the playlist of all downloaded tracks ordered by album title and
+// track order.
+func (m *Manager) writeGlobalPlaylist(root string) error {
+	records := []Record{}
+	for _, record := range m.records.All() {
+		if !utils.IsWithin(root, record.Path) {
+			continue
+		}
+		if _, err := os.Stat(record.Path); err == nil {
+			records = append(records, record)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Album.Title < records[j].Album.Title
+	})
+	return writePlaylist(filepath.Join(root, GlobalPlaylistName), records)
+}
+
+// Write the playlists of an album in background.
+func (m *Manager) updatePlaylists(albumId int) {
+	go func() {
+		if err := m.WritePlaylists(albumId); err != nil {
+			log.Printf("write playlists: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,208 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+const RecordsFilePath = "./records.json"
+
+// Record of a downloaded track.
+type Record struct {
+	Album    common.AlbumInfo `json:"album"`
+	Track    common.TrackInfo `json:"track"`
+	Position int              `json:"position"`
+	Path     string           `json:"path"`
+	// Expected file size.
+	Size int64 `json:"size"`
+	// SHA-256 of the file in hex.
+	SHA256 string `json:"sha256"`
+	// File type of the track address, "mp3" or "m4a".
+	Type string `json:"type"`
+	// Unix time of the download.
+	DownloadedAt int64 `json:"downloadedAt"`
+}
+
+// Time of the download.
+func (r Record) Time() time.Time {
+	return time.Unix(r.DownloadedAt, 0)
+}
+
+// Whether the keyword matches the album, track or path of the record.
+func (r Record) Matches(keyword string) bool {
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	if keyword == "" {
+		return true
+	}
+	for _, field := range []string{
+		r.Album.Title, r.Track.Name, r.Path,
+		strconv.Itoa(r.Album.Id), strconv.Itoa(r.Track.Id),
+	} {
+		if strings.Contains(strings.ToLower(field), keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// Downloaded track records saved in a JSON file.
+type Records struct {
+	path string
+
+	lock sync.RWMutex
+	// trackId -> record.
+	records map[int]*Record
+}
+
+// Get the record of a track.
+func (r *Records) Get(trackId int) (Record, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	record, ok := r.records[trackId]
+	if !ok {
+		return Record{}, false
+	}
+	return *record, true
+}
+
+// Owner returns the track id of the record of a file path, the path is case
+// insensitive as on Windows and macOS.
+func (r *Records) Owner(path string) (int, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	path = filepath.Clean(path)
+	for trackId, record := range r.records {
+		if strings.EqualFold(filepath.Clean(record.Path), path) {
+			return trackId, true
+		}
+	}
+	return 0, false
+}
+
+// Get all records sorted by album and track position.
+func (r *Records) All() []Record {
+	r.lock.RLock()
+	records := make([]Record, 0, len(r.records))
+	for _, record := range r.records {
+		records = append(records, *record)
+	}
+	r.lock.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Album.Id != records[j].Album.Id {
+			return records[i].Album.Id < records[j].Album.Id
+		}
+		return records[i].Position < records[j].Position
+	})
+	return records
+}
+
+// Search records matching the keyword, the latest downloaded first.
+func (r *Records) Search(keyword string) []Record {
+	r.lock.RLock()
+	records := []Record{}
+	for _, record := range r.records {
+		if record.Matches(keyword) {
+			records = append(records, *record)
+		}
+	}
+	r.lock.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].DownloadedAt != records[j].DownloadedAt {
+			return records[i].DownloadedAt > records[j].DownloadedAt
+		}
+		return records[i].Track.Id < records[j].Track.Id
+	})
+	return records
+}
+
+// Put a record and save the records file.
+func (r *Records) Put(record Record) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.records[record.Track.Id] = &record
+	return r.save()
+}
+
+// Save the records file, requires lock.
+func (r *Records) save() error {
+	if r.path == "" {
+		return nil
+	}
+	records := make([]*Record, 0, len(r.records))
+	for _, record := range r.records {
+		records = append(records, record)
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmppath := r.path + ".tmp"
+	if err = os.WriteFile(tmppath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, r.path)
+}
+
+// LoadRecords load the records file, an empty records is returned if the file
+// not exists. A corrupt file is moved aside and an empty records returned
+// with the error, the records are not saved if the file can't be read or
+// moved.
+func LoadRecords(path string) (*Records, error) {
+	r := &Records{
+		path:    path,
+		records: map[int]*Record{},
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || path == "" {
+		return r, nil
+	} else if err != nil {
+		r.path = ""
+		return r, err
+	}
+	records := []*Record{}
+	if err = json.Unmarshal(data, &records); err != nil {
+		moved, err := utils.SetAsideCorrupt(path, err)
+		if !moved {
+			r.path = ""
+		}
+		return r, err
+	}
+	for _, record := range records {
+		r.records[record.Track.Id] = record
+	}
+	return r, nil
+}
+
+// Get the size and SHA-256 of a file.
+func fileDigest(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,36 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+func TestLoadRecordsCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.json")
+	if err := os.WriteFile(path, []byte(`[{"Track": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := LoadRecords(path)
+	if err == nil {
+		t.Fatal("corrupt records loaded without error")
+	}
+	if data, err := os.ReadFile(path + utils.CorruptSuffix); err != nil || string(data) != `[{"Track": ` {
+		t.Fatalf("corrupt records not moved aside: %q, %v", data, err)
+	}
+	// The empty records save in place of the moved file.
+	if err := r.Put(Record{Track: common.TrackInfo{Id: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Get(1); !ok {
+		t.Error("record not saved")
+	}
+}
@@ -0,0 +1,24 @@
+package download
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"xmlymft-fyne-gui/app/settings"
+)
+
+// Max delay between two attempts.
+const maxRetryDelay = time.Minute
+
+// Delay before the next attempt after n failed attempts: the backoff doubled
+// for each failure, randomized by the jitter factor.
+func retryDelay(cfg settings.Settings, n int) time.Duration {
+	delay := float64(cfg.RetryBackoff) * float64(time.Millisecond) * math.Pow(2, float64(n-1))
+	jitter := math.Max(0, math.Min(1, cfg.RetryJitter))
+	delay *= 1 + jitter*(2*rand.Float64()-1)
+	if delay > float64(maxRetryDelay) {
+		return maxRetryDelay
+	}
+	return time.Duration(delay)
+}
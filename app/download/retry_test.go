@@ -0,0 +1,83 @@
+package download
+
+import (
+	"testing"
+	"time"
+
+	"xmlymft-fyne-gui/app/settings"
+)
+
+func TestRetryDelaySequence(t *testing.T) {
+	cfg := *settings.NewSettings()
+	cfg.RetryBackoff = 1000
+	cfg.RetryJitter = 0
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{6, 32 * time.Second},
+		// Capped.
+		{7, maxRetryDelay},
+		{100, maxRetryDelay},
+		{5000, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		if delay := retryDelay(cfg, tt.n); delay != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.n, delay, tt.want)
+		}
+	}
+
+	// A negative jitter is none.
+	cfg.RetryJitter = -1
+	if delay := retryDelay(cfg, 2); delay != 2*time.Second {
+		t.Errorf("retryDelay with negative jitter = %v, want 2s", delay)
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	cfg := *settings.NewSettings()
+	cfg.RetryBackoff = 1000
+	tests := []struct {
+		jitter float64
+		min    time.Duration
+		max    time.Duration
+	}{
+		{0.5, 2 * time.Second, 6 * time.Second},
+		{0.1, 3600 * time.Millisecond, 4400 * time.Millisecond},
+		// Clamped to 1.
+		{3, 0, 8 * time.Second},
+	}
+	for _, tt := range tests {
+		cfg.RetryJitter = tt.jitter
+		low, high := time.Duration(1<<62), time.Duration(0)
+		for i := 0; i < 2000; i++ {
+			delay := retryDelay(cfg, 3)
+			if delay < tt.min || delay > tt.max {
+				t.Fatalf("jitter %v: delay %v out of [%v, %v]", tt.jitter, delay, tt.min, tt.max)
+			}
+			if delay < low {
+				low = delay
+			}
+			if delay > high {
+				high = delay
+			}
+		}
+		// Spread over the range, below and above the backoff.
+		spread := (tt.max - tt.min) / 4
+		if low > 4*time.Second-spread || high < 4*time.Second+spread {
+			t.Errorf("jitter %v: delays in [%v, %v], want spread over [%v, %v]", tt.jitter, low, high, tt.min, tt.max)
+		}
+	}
+
+	// The jittered delay is still capped.
+	cfg.RetryJitter = 1
+	for i := 0; i < 100; i++ {
+		if delay := retryDelay(cfg, 7); delay > maxRetryDelay {
+			t.Fatalf("delay %v over the cap", delay)
+		}
+	}
+}
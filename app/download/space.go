@@ -0,0 +1,118 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"xmlymft-fyne-gui/app/settings"
+	"xmlymft-fyne-gui/utils"
+)
+
+// Bitrate in bits per second to estimate the size of tracks, higher than the
+// usual bitrate of the tracks to leave a margin.
+const EstimatedBitrate = 128000
+
+// Min interval between free space checks while downloading.
+const spaceCheckInterval = time.Second * 2
+
+var ErrLowSpace = errors.New("磁盘空间不足")
+
+// EstimateSize estimate the bytes to download the tracks by their durations,
+// tracks downloaded or queued, running or paused are excluded.
+func (m *Manager) EstimateSize(tracks []AlbumTrack) int64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	size := int64(0)
+	for _, track := range tracks {
+		if job := m.findJob(track.Track.Id); job != nil && (job.IsActive() || job.State == JobPaused) {
+			continue
+		}
+		if m.isDownloaded(track.Track.Id) {
+			continue
+		}
+		size += int64(track.Track.Duration) * EstimatedBitrate / 8
+	}
+	return size
+}
+
+// FreeSpace returns the free bytes of the download volume.
+func (m *Manager) FreeSpace() (int64, error) {
+	cfg := m.Settings()
+	root, err := cfg.DownloadRoot()
+	if err != nil {
+		return 0, err
+	}
+	// The download directory may be created later.
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err = os.Stat(root); err == nil {
+			break
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return 0, err
+		}
+		root = parent
+	}
+	return utils.FreeSpace(root)
+}
+
+// CheckSpace check whether the required bytes fit in the free space above the
+// min free space, returns the free bytes.
+func (m *Manager) CheckSpace(required int64) (int64, error) {
+	free, err := m.FreeSpace()
+	if err != nil {
+		return 0, err
+	}
+	if free-required < minFreeSpace(m.Settings()) {
+		return free, fmt.Errorf(
+			"%w: 需要 %s, 可用 %s, 保留 %s", ErrLowSpace,
+			utils.FormatBytes(required), utils.FormatBytes(free), utils.FormatBytes(minFreeSpace(m.Settings())),
+		)
+	}
+	return free, nil
+}
+
+// Min free bytes to keep on the download volume.
+func minFreeSpace(cfg settings.Settings) int64 {
+	return int64(cfg.MinFreeSpace) * 1024 * 1024
+}
+
+// Check the free space while downloading, throttled by spaceCheckInterval.
+// The queue is paused if the free space is low, returns the error.
+func (m *Manager) watchSpace(required int64) error {
+	m.lock.Lock()
+	if time.Since(m.spaceCheckedAt) < spaceCheckInterval {
+		m.lock.Unlock()
+		return nil
+	}
+	m.spaceCheckedAt = time.Now()
+	m.lock.Unlock()
+
+	return m.pauseIfLowSpace(required)
+}
+
+// Pause the queue if the required bytes not fit in the free space, returns the
+// error.
+func (m *Manager) pauseIfLowSpace(required int64) error {
+	if m.Settings().MinFreeSpace <= 0 {
+		return nil
+	}
+	_, err := m.CheckSpace(required)
+	if !errors.Is(err, ErrLowSpace) {
+		// Failed to get the free space, keep downloading.
+		return nil
+	}
+	m.PauseAll()
+	if m.OnLowSpace != nil {
+		m.OnLowSpace(err)
+	}
+	return err
+}
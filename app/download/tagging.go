@@ -0,0 +1,73 @@
+package download
+
+import (
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/tags"
+	"xmlymft-fyne-gui/utils"
+)
+
+// AlbumCreatedTime returns the creation time of an album, zero if unknown.
+func AlbumCreatedTime(album common.AlbumInfo) time.Time {
+	if album.CreatedTime <= 0 {
+		return time.Time{}
+	}
+	// The server gives milliseconds.
+	if album.CreatedTime > 1e11 {
+		return time.UnixMilli(int64(album.CreatedTime))
+	}
+	return time.Unix(int64(album.CreatedTime), 0)
+}
+
+// Release year of an album, 0 if unknown.
+func albumYear(album common.AlbumInfo) int {
+	created := AlbumCreatedTime(album)
+	if created.IsZero() {
+		return 0
+	}
+	return created.Year()
+}
+
+// Get the album cover image, fetched once per album.
+func (m *Manager) albumCover(album common.AlbumInfo) []byte {
+	m.lock.RLock()
+	cover, cached := m.covers[album.Id]
+	m.lock.RUnlock()
+	if cached {
+		return cover
+	}
+
+	url := utils.AlbumCoverURL(album.Cover)
+	if url != "" {
+		// A failed fetch is cached too, the tracks are tagged without cover.
+		cover, _ = utils.HTTPGetBytes(url)
+	}
+	m.lock.Lock()
+	m.covers[album.Id] = cover
+	m.lock.Unlock()
+	return cover
+}
+
+// AlbumCover returns the album cover image, nil if not available.
+func (m *Manager) AlbumCover(album common.AlbumInfo) []byte {
+	return m.albumCover(album)
+}
+
+// Write the album and track metadata with the album cover into a downloaded
+// track file.
+func (m *Manager) tagTrack(job Job, trackpath string, fileType string) error {
+	meta := tags.Metadata{
+		Title:       job.Track.Name,
+		Album:       job.Album.Title,
+		Artist:      job.Album.Author,
+		AlbumArtist: job.Album.Author,
+		Genre:       job.Album.Category,
+		Track:       job.Position,
+		TrackTotal:  job.Album.TracksCount,
+		Year:        albumYear(job.Album),
+		Cover:       m.albumCover(job.Album),
+	}
+	return tags.Write(trackpath, fileType, meta)
+}
@@ -0,0 +1,171 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Suffix of the partial downloaded file.
+const PartSuffix = ".part"
+
+var ErrIncomplete = errors.New("incomplete download")
+
+// Reader reports the received bytes after each read.
+type progressReader struct {
+	reader     io.Reader
+	received   int64
+	total      int64
+	onProgress func(received int64, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	if n > 0 {
+		p.received += int64(n)
+		if p.onProgress != nil {
+			p.onProgress(p.received, p.total)
+		}
+	}
+	return n, err
+}
+
+// Parse the start and total size from a Content-Range header like
+// "bytes 0-99/1000", "bytes 0-99/*" or "bytes */1000", -1 if unknown. Both
+// are -1 if the header is malformed.
+func parseContentRange(contentRange string) (start int64, total int64) {
+	start, total = -1, -1
+	spec := strings.TrimSpace(contentRange)
+	if !strings.HasPrefix(spec, "bytes ") {
+		return -1, -1
+	}
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "bytes "))
+	slash := strings.Index(spec, "/")
+	if slash < 0 {
+		return -1, -1
+	}
+	byteRange, size := spec[:slash], spec[slash+1:]
+	if size != "*" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n < 0 {
+			return -1, -1
+		}
+		total = n
+	}
+	if byteRange == "*" {
+		return
+	}
+	dash := strings.Index(byteRange, "-")
+	if dash <= 0 {
+		return -1, -1
+	}
+	first, err := strconv.ParseInt(byteRange[:dash], 10, 64)
+	if err != nil {
+		return -1, -1
+	}
+	last, err := strconv.ParseInt(byteRange[dash+1:], 10, 64)
+	if err != nil || first < 0 || last < first || (total >= 0 && last >= total) {
+		return -1, -1
+	}
+	return first, total
+}
+
+// Download the file at address to trackpath.
+// The data is streamed to a partial file first, a following call resumes from
+// it with a Range request. The partial file is renamed to trackpath only if
+// the byte count matches the expected size, the expected size is taken from
+// the response headers, or byteSize if the server gives none.
+// onProgress is called with the received and total bytes while downloading,
+// total is 0 if unknown. The download speed is limited by limiter if not nil.
+func transfer(
+	ctx context.Context, address string, trackpath string, byteSize int64,
+	onProgress func(received int64, total int64), limiter *RateLimiter,
+) error {
+	partpath := trackpath + PartSuffix
+	var offset int64
+	if info, err := os.Stat(partpath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flag := os.O_CREATE | os.O_WRONLY
+	total := int64(-1)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Range ignored by the server, download from begin.
+		offset = 0
+		flag |= os.O_TRUNC
+		total = resp.ContentLength
+	case http.StatusPartialContent:
+		start, size := parseContentRange(resp.Header.Get("Content-Range"))
+		if start != offset {
+			os.Remove(partpath)
+			return fmt.Errorf("%w: unexpected content range %q", ErrIncomplete, resp.Header.Get("Content-Range"))
+		}
+		flag |= os.O_APPEND
+		total = size
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file may be already complete.
+		_, size := parseContentRange(resp.Header.Get("Content-Range"))
+		if size >= 0 && size == offset {
+			return os.Rename(partpath, trackpath)
+		}
+		os.Remove(partpath)
+		return fmt.Errorf("%w: range not satisfiable", ErrIncomplete)
+	default:
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+	if total < 0 && byteSize > 0 {
+		total = byteSize
+	}
+
+	file, err := os.OpenFile(partpath, flag, 0644)
+	if err != nil {
+		return err
+	}
+	var body io.Reader = resp.Body
+	if limiter != nil {
+		body = &limitedReader{ctx: ctx, reader: body, limiter: limiter}
+	}
+	reader := &progressReader{
+		reader:     body,
+		received:   offset,
+		total:      total,
+		onProgress: onProgress,
+	}
+	if reader.total < 0 {
+		reader.total = 0
+	}
+	if onProgress != nil {
+		onProgress(reader.received, reader.total)
+	}
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if size := reader.received; total >= 0 && size != total {
+		return fmt.Errorf("%w: %d/%d bytes", ErrIncomplete, size, total)
+	}
+	return os.Rename(partpath, trackpath)
+}
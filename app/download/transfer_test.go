@@ -0,0 +1,174 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  int64
+		total  int64
+	}{
+		{"bytes 0-99/1000", 0, 1000},
+		{"bytes 100-999/1000", 100, 1000},
+		{"bytes 100-199/*", 100, -1},
+		{"bytes */1000", -1, 1000},
+		{"bytes */*", -1, -1},
+		{" bytes 5-9/10 ", 5, 10},
+		// Malformed.
+		{"", -1, -1},
+		{"bytes", -1, -1},
+		{"bytes=0-99/1000", -1, -1},
+		{"items 0-99/1000", -1, -1},
+		{"bytes 0-99", -1, -1},
+		{"bytes a-99/1000", -1, -1},
+		{"bytes 0-/1000", -1, -1},
+		{"bytes -5/10", -1, -1},
+		{"bytes 99-0/1000", -1, -1},
+		{"bytes 0-1000/1000", -1, -1},
+		{"bytes 0-99/abc", -1, -1},
+		{"bytes 0-99/-1", -1, -1},
+		{"bytes 0-99/1000/2000", -1, -1},
+	}
+	for _, tt := range tests {
+		if start, total := parseContentRange(tt.header); start != tt.start || total != tt.total {
+			t.Errorf("parseContentRange(%q) = %d, %d, want %d, %d", tt.header, start, total, tt.start, tt.total)
+		}
+	}
+}
+
+// Serve the data, answering the requests with handle if not nil.
+func newTransferServer(t *testing.T, data []byte, handle func(w http.ResponseWriter, r *http.Request) bool) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle != nil && handle(w, r) {
+			return
+		}
+		http.ServeContent(w, r, "track.mp3", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestTransferResume(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 100))
+	tests := []struct {
+		name string
+		// Bytes already in the partial file.
+		part   int
+		handle func(w http.ResponseWriter, r *http.Request) bool
+		// The whole file saved, or else the error.
+		err error
+		// Whether the partial file is kept for resuming.
+		keepPart bool
+	}{
+		{name: "fresh"},
+		{name: "resumed", part: 300},
+		{
+			name: "range ignored",
+			part: 300,
+			handle: func(w http.ResponseWriter, r *http.Request) bool {
+				// 200 with the whole file, the partial file is overwritten.
+				w.Write(data)
+				return true
+			},
+		},
+		{
+			name: "already complete",
+			part: len(data),
+		},
+		{
+			name: "part larger than the file",
+			part: len(data) + 10,
+			err:  ErrIncomplete,
+		},
+		{
+			name: "unexpected range",
+			part: 300,
+			handle: func(w http.ResponseWriter, r *http.Request) bool {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(data)
+				return true
+			},
+			err: ErrIncomplete,
+		},
+		{
+			name: "truncated",
+			handle: func(w http.ResponseWriter, r *http.Request) bool {
+				w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+				w.Write(data[:500])
+				return true
+			},
+			// The response body ends early.
+			keepPart: true,
+		},
+		{
+			name: "server error",
+			part: 300,
+			handle: func(w http.ResponseWriter, r *http.Request) bool {
+				w.WriteHeader(http.StatusInternalServerError)
+				return true
+			},
+			keepPart: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trackpath := filepath.Join(t.TempDir(), "track.mp3")
+			partpath := trackpath + PartSuffix
+			if tt.part > 0 {
+				part := data
+				if tt.part > len(data) {
+					part = append(append([]byte{}, data...), make([]byte, tt.part-len(data))...)
+				}
+				if err := os.WriteFile(partpath, part[:tt.part], 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var received, total int64
+			err := transfer(context.Background(), newTransferServer(t, data, tt.handle), trackpath,
+				int64(len(data)), func(r int64, t int64) { received, total = r, t }, nil)
+
+			if tt.err != nil || tt.keepPart {
+				if err == nil {
+					t.Fatal("transferred without error")
+				}
+				if tt.err != nil && !errors.Is(err, tt.err) {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+				if _, statErr := os.Stat(partpath); (statErr == nil) != tt.keepPart {
+					t.Errorf("partial file kept = %v, want %v", statErr == nil, tt.keepPart)
+				}
+				if _, statErr := os.Stat(trackpath); statErr == nil {
+					t.Error("incomplete track saved")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved, err := os.ReadFile(trackpath); err != nil || !bytes.Equal(saved, data) {
+				t.Errorf("saved %d bytes, %v, want the data", len(saved), err)
+			}
+			if _, err := os.Stat(partpath); err == nil {
+				t.Error("partial file left")
+			}
+			if tt.part < len(data) && (received != int64(len(data)) || total != int64(len(data))) {
+				t.Errorf("progress %d/%d, want %d/%d", received, total, len(data), len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package download
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"xmlymft-fyne-gui/app/tags"
+	"xmlymft-fyne-gui/utils"
+)
+
+// A truncated or corrupt file found by VerifyLibrary.
+type Problem struct {
+	Path   string
+	Reason string
+	// Record of the file, nil if not recorded.
+	Record *Record
+}
+
+func isKnownType(fileType string) bool {
+	for _, knownType := range knownTypes {
+		if strings.EqualFold(fileType, knownType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify a track file, returns the problem reason or "" if intact.
+func verifyFile(path string, fileType string, record *Record) string {
+	if record != nil {
+		size, digest, err := fileDigest(path)
+		if err != nil {
+			return err.Error()
+		}
+		if size != record.Size {
+			return fmt.Sprintf("大小不符 %s/%s", utils.FormatBytes(size), utils.FormatBytes(record.Size))
+		}
+		if record.SHA256 != "" && digest != record.SHA256 {
+			return "校验和不符"
+		}
+	}
+	if err := tags.Validate(path, fileType); err != nil {
+		return "文件损坏: " + err.Error()
+	}
+	return ""
+}
+
+// VerifyLibrary scan the download root for partial, truncated or corrupt
+// track files, and recorded files missing.
+func (m *Manager) VerifyLibrary() ([]Problem, error) {
+	cfg := m.Settings()
+	root, err := cfg.DownloadRoot()
+	if err != nil {
+		return nil, err
+	}
+	recordsByPath := map[string]*Record{}
+	for _, record := range m.records.All() {
+		record := record
+		recordsByPath[filepath.Clean(record.Path)] = &record
+	}
+
+	problems := []Problem{}
+	seen := map[string]bool{}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		// Skip unreadable directories.
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(path, PartSuffix) {
+			trackpath := strings.TrimSuffix(path, PartSuffix)
+			problems = append(problems, Problem{path, "未完成的下载", recordsByPath[trackpath]})
+			return nil
+		}
+		fileType := strings.TrimPrefix(filepath.Ext(path), ".")
+		if !isKnownType(fileType) {
+			return nil
+		}
+		seen[path] = true
+		record := recordsByPath[path]
+		if reason := verifyFile(path, fileType, record); reason != "" {
+			problems = append(problems, Problem{path, reason, record})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for path, record := range recordsByPath {
+		if !seen[path] && utils.IsWithin(root, path) {
+			problems = append(problems, Problem{path, "文件不存在", record})
+		}
+	}
+
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].Path < problems[j].Path
+	})
+	return problems, nil
+}
+
+// Requeue download the recorded tracks of the problems again, returns the
+// number of queued tracks. Broken files are deleted, partial files are kept to
+// resume, files not recorded are left untouched.
+func (m *Manager) Requeue(problems []Problem) int {
+	queued := 0
+	for _, problem := range problems {
+		if problem.Record == nil {
+			continue
+		}
+		if !strings.HasSuffix(problem.Path, PartSuffix) {
+			os.Remove(problem.Path)
+		}
+		m.Enqueue(problem.Record.Album, problem.Record.Track, problem.Record.Position)
+		queued++
+	}
+	return queued
+}
@@ -0,0 +1,155 @@
+package download
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// The "下载" view listing every download job.
+type View struct {
+	appwin  fyne.Window
+	manager *Manager
+
+	contents fyne.CanvasObject
+	// Contents of the job list, hidden when the history shown.
+	jobsContents fyne.CanvasObject
+	summary      *widget.Label
+	jobList      *widget.List
+	history      *HistoryView
+
+	// Jobs snapshot to show.
+	jobs []Job
+}
+
+// Get the contents to show.
+func (v *View) Contents() fyne.CanvasObject {
+	return v.contents
+}
+
+// Show the history or the jobs.
+func (v *View) showHistory(show bool) {
+	if show {
+		v.history.Refresh()
+		v.jobsContents.Hide()
+		v.history.Contents().Show()
+	} else {
+		v.history.Contents().Hide()
+		v.jobsContents.Show()
+	}
+}
+
+// Refresh reload jobs from the manager.
+func (v *View) Refresh() {
+	v.jobs = v.manager.Jobs()
+
+	queued, running, paused, failed, done := 0, 0, 0, 0, 0
+	for _, job := range v.jobs {
+		switch job.State {
+		case JobQueued:
+			queued++
+		case JobRunning:
+			running++
+		case JobPaused:
+			paused++
+		case JobFailed:
+			failed++
+		case JobDone:
+			done++
+		}
+	}
+	v.summary.SetText(fmt.Sprintf(
+		"共 %d 个, 下载中 %d, 等待 %d, 暂停 %d, 失败 %d, 完成 %d",
+		len(v.jobs), running, queued, paused, failed, done,
+	))
+	v.jobList.Refresh()
+}
+
+// Verify the library and ask to download the broken tracks again.
+func (v *View) verifyLibrary() {
+	progress := dialog.NewProgressInfinite("校验", "正在校验下载目录...", v.appwin)
+	progress.Show()
+	go func() {
+		problems, err := v.manager.VerifyLibrary()
+		progress.Hide()
+		if err != nil {
+			dialog.ShowError(err, v.appwin)
+			return
+		}
+		if len(problems) == 0 {
+			dialog.ShowInformation("校验", "所有文件完好", v.appwin)
+			return
+		}
+
+		recorded := 0
+		lines := make([]string, len(problems))
+		for i, problem := range problems {
+			if problem.Record != nil {
+				recorded++
+			}
+			lines[i] = fmt.Sprintf("%s: %s", problem.Path, problem.Reason)
+		}
+		message := widget.NewLabel(strings.Join(lines, "\n"))
+		message.Wrapping = fyne.TextWrapBreak
+		scroll := container.NewVScroll(message)
+		scroll.SetMinSize(fyne.NewSize(v.appwin.Canvas().Size().Width*0.8, 200))
+		title := fmt.Sprintf("发现 %d 个问题文件, 重新下载其中 %d 个已记录的音频?", len(problems), recorded)
+		dialog.ShowCustomConfirm(title, "重新下载", "关闭", scroll, func(ok bool) {
+			if ok {
+				v.manager.Requeue(problems)
+			}
+		}, v.appwin)
+	}()
+}
+
+func NewView(window fyne.Window, manager *Manager) *View {
+	view := new(View)
+	view.appwin = window
+	view.manager = manager
+
+	view.summary = widget.NewLabel("")
+	view.jobList = widget.NewList(
+		func() int {
+			return len(view.jobs)
+		},
+		func() fyne.CanvasObject {
+			return NewJobViewItem(manager)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			if i < len(view.jobs) {
+				o.(*JobViewItem).Update(view.jobs[i])
+			}
+		},
+	)
+	verifyBtn := widget.NewButtonWithIcon("校验", theme.ConfirmIcon(), view.verifyLibrary)
+	verifyBtn.Importance = widget.LowImportance
+	pauseAllBtn := widget.NewButtonWithIcon("全部暂停", theme.MediaPauseIcon(), manager.PauseAll)
+	pauseAllBtn.Importance = widget.LowImportance
+	resumeAllBtn := widget.NewButtonWithIcon("全部继续", theme.MediaPlayIcon(), manager.ResumeAll)
+	resumeAllBtn.Importance = widget.LowImportance
+	historyBtn := widget.NewButtonWithIcon("历史", theme.HistoryIcon(), func() {
+		view.showHistory(true)
+	})
+	historyBtn.Importance = widget.LowImportance
+	header := container.NewVBox(
+		view.summary,
+		container.NewHBox(layout.NewSpacer(), pauseAllBtn, resumeAllBtn, verifyBtn, historyBtn),
+	)
+	view.history = NewHistoryView(window, manager, func() {
+		view.showHistory(false)
+	})
+	view.jobsContents = container.NewBorder(header, nil, nil, nil, view.jobList)
+	view.contents = container.NewMax(view.jobsContents, view.history.Contents())
+	view.showHistory(false)
+
+	manager.AddListener(view.Refresh)
+	view.Refresh()
+
+	return view
+}
@@ -0,0 +1,340 @@
+// Package exchange exports the favorites and subscriptions to a JSON or OPML
+// file and imports them back, so album lists can be shared.
+package exchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/favorite"
+	"xmlymft-fyne-gui/app/subscription"
+)
+
+// Version of the JSON schema, increased on incompatible changes.
+const Version = 1
+
+var ErrVersion = errors.New("unsupported file version")
+var ErrFormat = errors.New("unknown file format")
+
+// File format of an exported document.
+type Format int
+
+const (
+	JSON Format = iota
+	OPML
+)
+
+func (f Format) String() string {
+	if f == OPML {
+		return "OPML"
+	}
+	return "JSON"
+}
+
+// Extension of the format, with the dot.
+func (f Format) Extension() string {
+	if f == OPML {
+		return ".opml"
+	}
+	return ".json"
+}
+
+// FormatOf get the format of a file extension, JSON if unknown.
+func FormatOf(extension string) Format {
+	switch strings.ToLower(extension) {
+	case ".opml", ".xml":
+		return OPML
+	}
+	return JSON
+}
+
+// Document of the exported favorites and subscriptions.
+type Document struct {
+	Version int `json:"version"`
+	// RFC 3339 time of exporting.
+	ExportedAt string `json:"exportedAt"`
+
+	FavoriteAlbums []favorite.Album `json:"favoriteAlbums"`
+	FavoriteTracks []favorite.Track `json:"favoriteTracks"`
+	// Subscribed albums, the seen tracks are not exported as the album is
+	// checked again when imported.
+	Subscriptions []common.AlbumInfo `json:"subscriptions"`
+}
+
+// Export build the document of the favorites and subscriptions, sorted in
+// added order for stable diffs.
+func Export(favorites *favorite.Favorites, subscriptions *subscription.Watcher) Document {
+	doc := Document{
+		Version:        Version,
+		ExportedAt:     time.Now().Format(time.RFC3339),
+		FavoriteAlbums: favorites.Albums(),
+		FavoriteTracks: favorites.Tracks(),
+		Subscriptions:  []common.AlbumInfo{},
+	}
+	sort.SliceStable(doc.FavoriteAlbums, func(i, j int) bool {
+		a, b := doc.FavoriteAlbums[i], doc.FavoriteAlbums[j]
+		return a.AddedAt < b.AddedAt || (a.AddedAt == b.AddedAt && a.Album.Id < b.Album.Id)
+	})
+	sort.SliceStable(doc.FavoriteTracks, func(i, j int) bool {
+		a, b := doc.FavoriteTracks[i], doc.FavoriteTracks[j]
+		return a.AddedAt < b.AddedAt || (a.AddedAt == b.AddedAt && a.Track.Id < b.Track.Id)
+	})
+	for _, subscription := range subscriptions.All() {
+		doc.Subscriptions = append(doc.Subscriptions, subscription.Album)
+	}
+	return doc
+}
+
+// Encode the document in the format.
+func Encode(doc Document, format Format) ([]byte, error) {
+	if format == OPML {
+		return encodeOPML(doc)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// Decode a document, the format is detected from the data.
+func Decode(data []byte) (Document, error) {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(data, []byte("{")):
+		return decodeJSON(data)
+	case bytes.HasPrefix(data, []byte("<")):
+		return decodeOPML(data)
+	}
+	return Document{}, ErrFormat
+}
+
+func decodeJSON(data []byte) (Document, error) {
+	doc := Document{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return doc, err
+	}
+	if doc.Version < 1 || doc.Version > Version {
+		return doc, fmt.Errorf("%w: %d", ErrVersion, doc.Version)
+	}
+	return doc, nil
+}
+
+// How to import a document.
+type Mode int
+
+const (
+	// Add the missing entries, the local entries are kept on conflicts.
+	Merge Mode = iota
+	// Replace the local entries with the imported ones.
+	Replace
+)
+
+func (m Mode) String() string {
+	if m == Replace {
+		return "替换"
+	}
+	return "合并"
+}
+
+// An imported entry differs from the local one with the same id.
+type Conflict struct {
+	// "收藏专辑", "收藏音频" or "订阅".
+	Kind     string
+	Id       int
+	Local    string
+	Imported string
+}
+
+// Report of an import.
+type Report struct {
+	Mode      Mode
+	Added     int
+	Unchanged int
+	Removed   int
+	Conflicts []Conflict
+	// Errors of the entries failed to import.
+	Errors []string
+}
+
+func (r Report) String() string {
+	lines := []string{fmt.Sprintf(
+		"%s导入: 新增 %d, 未变 %d, 移除 %d, 冲突 %d", r.Mode, r.Added, r.Unchanged, r.Removed, len(r.Conflicts),
+	)}
+	resolution := "保留本地"
+	if r.Mode == Replace {
+		resolution = "使用导入"
+	}
+	for _, c := range r.Conflicts {
+		lines = append(lines, fmt.Sprintf("%s %d: 本地 %q, 导入 %q, %s", c.Kind, c.Id, c.Local, c.Imported, resolution))
+	}
+	lines = append(lines, r.Errors...)
+	return strings.Join(lines, "\n")
+}
+
+func albumSummary(album common.AlbumInfo) string {
+	return album.Title
+}
+
+func trackSummary(track favorite.Track) string {
+	return fmt.Sprintf("%s 第 %d 集 %s", track.Album.Title, track.Position, track.Track.Name)
+}
+
+// Import a document with the mode. Subscribing a new album queries its play
+// list from the server, the failed albums are reported in the errors.
+func Import(
+	doc Document, mode Mode, favorites *favorite.Favorites, subscriptions *subscription.Watcher,
+) (Report, error) {
+	report := Report{Mode: mode}
+	now := time.Now().Unix()
+
+	// Favorite albums, in added order.
+	localAlbums := favorites.Albums()
+	reverse(len(localAlbums), func(i, j int) { localAlbums[i], localAlbums[j] = localAlbums[j], localAlbums[i] })
+	albumIndex := map[int]int{}
+	for i, album := range localAlbums {
+		albumIndex[album.Album.Id] = i
+	}
+	albums := []favorite.Album{}
+	if mode == Merge {
+		albums = append(albums, localAlbums...)
+	}
+	importedAlbums := map[int]bool{}
+	for _, imported := range doc.FavoriteAlbums {
+		if importedAlbums[imported.Album.Id] {
+			continue
+		}
+		importedAlbums[imported.Album.Id] = true
+		if imported.AddedAt == 0 {
+			imported.AddedAt = now
+		}
+		i, ok := albumIndex[imported.Album.Id]
+		if !ok {
+			report.Added++
+			albums = append(albums, imported)
+			continue
+		}
+		local := localAlbums[i]
+		if albumSummary(local.Album) != albumSummary(imported.Album) {
+			report.Conflicts = append(report.Conflicts, Conflict{
+				"收藏专辑", imported.Album.Id, albumSummary(local.Album), albumSummary(imported.Album),
+			})
+		} else {
+			report.Unchanged++
+		}
+		if mode == Replace {
+			albums = append(albums, imported)
+		}
+	}
+
+	// Favorite tracks, in added order.
+	localTracks := favorites.Tracks()
+	reverse(len(localTracks), func(i, j int) { localTracks[i], localTracks[j] = localTracks[j], localTracks[i] })
+	trackIndex := map[int]int{}
+	for i, track := range localTracks {
+		trackIndex[track.Track.Id] = i
+	}
+	tracks := []favorite.Track{}
+	if mode == Merge {
+		tracks = append(tracks, localTracks...)
+	}
+	importedTracks := map[int]bool{}
+	for _, imported := range doc.FavoriteTracks {
+		if importedTracks[imported.Track.Id] {
+			continue
+		}
+		importedTracks[imported.Track.Id] = true
+		if imported.AddedAt == 0 {
+			imported.AddedAt = now
+		}
+		i, ok := trackIndex[imported.Track.Id]
+		if !ok {
+			report.Added++
+			tracks = append(tracks, imported)
+			continue
+		}
+		local := localTracks[i]
+		if trackSummary(local) != trackSummary(imported) {
+			report.Conflicts = append(report.Conflicts, Conflict{
+				"收藏音频", imported.Track.Id, trackSummary(local), trackSummary(imported),
+			})
+		} else {
+			report.Unchanged++
+		}
+		if mode == Replace {
+			tracks = append(tracks, imported)
+		}
+	}
+	if mode == Replace {
+		for _, album := range localAlbums {
+			if !importedAlbums[album.Album.Id] {
+				report.Removed++
+			}
+		}
+		for _, track := range localTracks {
+			if !importedTracks[track.Track.Id] {
+				report.Removed++
+			}
+		}
+	}
+	if err := favorites.Set(albums, tracks); err != nil {
+		return report, err
+	}
+
+	// Subscriptions, the subscribed albums keep their seen tracks and take
+	// the imported album info on replacing.
+	subscribed := map[int]common.AlbumInfo{}
+	for _, subscription := range subscriptions.All() {
+		subscribed[subscription.Album.Id] = subscription.Album
+	}
+	importedSubscriptions := map[int]bool{}
+	for _, imported := range doc.Subscriptions {
+		if importedSubscriptions[imported.Id] {
+			continue
+		}
+		importedSubscriptions[imported.Id] = true
+		local, ok := subscribed[imported.Id]
+		if !ok {
+			if err := subscriptions.Subscribe(imported); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("订阅 %s: %s", imported.Title, err))
+				continue
+			}
+			report.Added++
+			continue
+		}
+		if albumSummary(local) != albumSummary(imported) {
+			report.Conflicts = append(report.Conflicts, Conflict{
+				"订阅", imported.Id, albumSummary(local), albumSummary(imported),
+			})
+		} else {
+			report.Unchanged++
+		}
+		if mode == Replace {
+			if err := subscriptions.UpdateAlbum(imported); err != nil {
+				return report, err
+			}
+		}
+	}
+	if mode == Replace {
+		for albumId := range subscribed {
+			if importedSubscriptions[albumId] {
+				continue
+			}
+			if err := subscriptions.Unsubscribe(albumId); err != nil {
+				return report, err
+			}
+			report.Removed++
+		}
+	}
+	return report, nil
+}
+
+// Reverse a list of length n by swap.
+func reverse(n int, swap func(i, j int)) {
+	for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+}
@@ -0,0 +1,243 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/favorite"
+	"xmlymft-fyne-gui/app/subscription"
+)
+
+// Start a server of album play lists, every album has the same two tracks.
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": common.QueryPlayListResult{
+				Tracks: []common.TrackInfo{{Id: 101, Name: "第1集"}, {Id: 102, Name: "第2集"}},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// Create favorites and subscriptions of the albums and tracks, not saved.
+func newTestStores(
+	t *testing.T, albums []common.AlbumInfo, tracks []favorite.Track, subscribed []common.AlbumInfo,
+) (*favorite.Favorites, *subscription.Watcher) {
+	t.Helper()
+	favorites, err := favorite.LoadFavorites("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := []favorite.Album{}
+	for i, album := range albums {
+		added = append(added, favorite.Album{Album: album, AddedAt: int64(1000 + i)})
+	}
+	if err := favorites.Set(added, tracks); err != nil {
+		t.Fatal(err)
+	}
+	subscriptions, err := subscription.NewWatcher(newTestServer(t), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, album := range subscribed {
+		if err := subscriptions.Subscribe(album); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return favorites, subscriptions
+}
+
+func testAlbum(id int, title string) common.AlbumInfo {
+	return common.AlbumInfo{Id: id, Title: title, Author: "作者", Cover: "cover.jpg", TracksCount: 2}
+}
+
+func testTrack(album common.AlbumInfo, id int, position int) favorite.Track {
+	return favorite.Track{
+		Album:    album,
+		Track:    common.TrackInfo{Id: id, Name: "音频"},
+		Position: position,
+		AddedAt:  int64(2000 + id),
+	}
+}
+
+func albumIds(albums []favorite.Album) []int {
+	ids := []int{}
+	for _, album := range albums {
+		ids = append(ids, album.Album.Id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func trackIds(tracks []favorite.Track) []int {
+	ids := []int{}
+	for _, track := range tracks {
+		ids = append(ids, track.Track.Id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Subscribed album titles by id.
+func subscribedTitles(subscriptions *subscription.Watcher) map[int]string {
+	titles := map[int]string{}
+	for _, subscription := range subscriptions.All() {
+		titles[subscription.Album.Id] = subscription.Album.Title
+	}
+	return titles
+}
+
+func TestRoundTrip(t *testing.T) {
+	first, second := testAlbum(1, "三体"), testAlbum(2, "球状闪电")
+	favorites, subscriptions := newTestStores(t,
+		[]common.AlbumInfo{first, second},
+		[]favorite.Track{testTrack(first, 11, 1), testTrack(second, 21, 3)},
+		[]common.AlbumInfo{second},
+	)
+	doc := Export(favorites, subscriptions)
+
+	for _, format := range []Format{JSON, OPML} {
+		t.Run(format.String(), func(t *testing.T) {
+			data, err := Encode(doc, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Version != Version {
+				t.Errorf("version = %d, want %d", decoded.Version, Version)
+			}
+			exported, _ := time.Parse(time.RFC3339, doc.ExportedAt)
+			if at, err := time.Parse(time.RFC3339, decoded.ExportedAt); err != nil || !at.Equal(exported) {
+				t.Errorf("exported at %q, want %q", decoded.ExportedAt, doc.ExportedAt)
+			}
+			if !reflect.DeepEqual(decoded.FavoriteAlbums, doc.FavoriteAlbums) {
+				t.Errorf("favorite albums = %+v, want %+v", decoded.FavoriteAlbums, doc.FavoriteAlbums)
+			}
+			if !reflect.DeepEqual(decoded.FavoriteTracks, doc.FavoriteTracks) {
+				t.Errorf("favorite tracks = %+v, want %+v", decoded.FavoriteTracks, doc.FavoriteTracks)
+			}
+			if !reflect.DeepEqual(decoded.Subscriptions, doc.Subscriptions) {
+				t.Errorf("subscriptions = %+v, want %+v", decoded.Subscriptions, doc.Subscriptions)
+			}
+		})
+	}
+}
+
+func TestDecodeVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"JSON v1", `{"version": 1}`, nil},
+		{"JSON without version", `{"favoriteAlbums": []}`, ErrVersion},
+		{"JSON newer", `{"version": 2}`, ErrVersion},
+		{"OPML without version", `<opml version="2.0"><head><title>t</title></head><body/></opml>`, nil},
+		{"OPML newer", `<opml version="2.0"><head><schemaVersion>2</schemaVersion></head><body/></opml>`, ErrVersion},
+		{"BOM", "\xef\xbb\xbf" + `{"version": 1}`, nil},
+		{"unknown", "version: 1", ErrFormat},
+	}
+	for _, tt := range tests {
+		if _, err := Decode([]byte(tt.data)); !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	kept, renamed, added := testAlbum(1, "本地"), testAlbum(2, "旧名"), testAlbum(3, "新增")
+	imported := Document{
+		Version: Version,
+		FavoriteAlbums: []favorite.Album{
+			{Album: testAlbum(2, "新名")}, {Album: added},
+			// Duplicates are skipped.
+			{Album: added},
+		},
+		FavoriteTracks: []favorite.Track{testTrack(kept, 11, 1), testTrack(added, 31, 1)},
+		Subscriptions:  []common.AlbumInfo{testAlbum(6, "新名"), testAlbum(7, "新订阅")},
+	}
+
+	tests := []struct {
+		mode   Mode
+		report Report
+		albums []int
+		tracks []int
+		titles map[int]string
+		// Title of the renamed favorite album after import.
+		renamed string
+	}{
+		{
+			mode:    Merge,
+			report:  Report{Mode: Merge, Added: 3, Unchanged: 1, Removed: 0},
+			albums:  []int{1, 2, 3},
+			tracks:  []int{11, 12, 31},
+			titles:  map[int]string{5: "订阅", 6: "旧名", 7: "新订阅"},
+			renamed: "旧名",
+		},
+		{
+			mode:    Replace,
+			report:  Report{Mode: Replace, Added: 3, Unchanged: 1, Removed: 3},
+			albums:  []int{2, 3},
+			tracks:  []int{11, 31},
+			titles:  map[int]string{6: "新名", 7: "新订阅"},
+			renamed: "新名",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			favorites, subscriptions := newTestStores(t,
+				[]common.AlbumInfo{kept, renamed},
+				[]favorite.Track{testTrack(kept, 11, 1), testTrack(kept, 12, 2)},
+				[]common.AlbumInfo{testAlbum(5, "订阅"), testAlbum(6, "旧名")},
+			)
+			report, err := Import(imported, tt.mode, favorites, subscriptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Added != tt.report.Added || report.Unchanged != tt.report.Unchanged ||
+				report.Removed != tt.report.Removed || len(report.Errors) > 0 {
+				t.Errorf("report = %+v, want %+v", report, tt.report)
+			}
+			// The renamed favorite album and the renamed subscription.
+			if len(report.Conflicts) != 2 || report.Conflicts[0].Id != 2 || report.Conflicts[1].Id != 6 {
+				t.Errorf("conflicts = %+v, want albums 2 and 6", report.Conflicts)
+			}
+
+			if ids := albumIds(favorites.Albums()); !reflect.DeepEqual(ids, tt.albums) {
+				t.Errorf("favorite albums = %v, want %v", ids, tt.albums)
+			}
+			if ids := trackIds(favorites.Tracks()); !reflect.DeepEqual(ids, tt.tracks) {
+				t.Errorf("favorite tracks = %v, want %v", ids, tt.tracks)
+			}
+			if titles := subscribedTitles(subscriptions); !reflect.DeepEqual(titles, tt.titles) {
+				t.Errorf("subscriptions = %v, want %v", titles, tt.titles)
+			}
+			// The conflicts are resolved as reported.
+			for _, album := range favorites.Albums() {
+				if album.Album.Id == renamed.Id && album.Album.Title != tt.renamed {
+					t.Errorf("favorite album 2 is %q, want %q", album.Album.Title, tt.renamed)
+				}
+			}
+			// Updated subscriptions keep the seen tracks.
+			for _, subscription := range subscriptions.All() {
+				if len(subscription.Seen) != 2 {
+					t.Errorf("subscription %d seen %v, want 2 tracks", subscription.Album.Id, subscription.Seen)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package exchange
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/favorite"
+)
+
+// Section names of the top level outlines.
+const (
+	sectionFavoriteAlbums = "favoriteAlbums"
+	sectionFavoriteTracks = "favoriteTracks"
+	sectionSubscriptions  = "subscriptions"
+)
+
+type opml struct {
+	XMLName xml.Name  `xml:"opml"`
+	Version string    `xml:"version,attr"`
+	Head    opmlHead  `xml:"head"`
+	Body    []outline `xml:"body>outline"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+	// Version of the schema, same as the JSON version.
+	SchemaVersion int `xml:"schemaVersion,omitempty"`
+}
+
+// An album or track outline, or a section of them with the section attribute.
+type outline struct {
+	Text        string    `xml:"text,attr"`
+	Section     string    `xml:"section,attr,omitempty"`
+	AlbumId     int       `xml:"albumId,attr,omitempty"`
+	Title       string    `xml:"title,attr,omitempty"`
+	Author      string    `xml:"author,attr,omitempty"`
+	Cover       string    `xml:"cover,attr,omitempty"`
+	TracksCount int       `xml:"tracksCount,attr,omitempty"`
+	TrackId     int       `xml:"trackId,attr,omitempty"`
+	Position    int       `xml:"position,attr,omitempty"`
+	AlbumTitle  string    `xml:"albumTitle,attr,omitempty"`
+	AddedAt     int64     `xml:"addedAt,attr,omitempty"`
+	Outlines    []outline `xml:"outline"`
+}
+
+func albumOutline(album common.AlbumInfo, addedAt int64) outline {
+	return outline{
+		Text:        album.Title,
+		AlbumId:     album.Id,
+		Title:       album.Title,
+		Author:      album.Author,
+		Cover:       album.Cover,
+		TracksCount: album.TracksCount,
+		AddedAt:     addedAt,
+	}
+}
+
+func (o outline) album() common.AlbumInfo {
+	title := o.Title
+	if title == "" {
+		title = o.Text
+	}
+	return common.AlbumInfo{
+		Id:          o.AlbumId,
+		Title:       title,
+		Author:      o.Author,
+		Cover:       o.Cover,
+		TracksCount: o.TracksCount,
+	}
+}
+
+func encodeOPML(doc Document) ([]byte, error) {
+	albums := outline{Text: "收藏专辑", Section: sectionFavoriteAlbums}
+	for _, album := range doc.FavoriteAlbums {
+		albums.Outlines = append(albums.Outlines, albumOutline(album.Album, album.AddedAt))
+	}
+	tracks := outline{Text: "收藏音频", Section: sectionFavoriteTracks}
+	for _, track := range doc.FavoriteTracks {
+		o := albumOutline(track.Album, track.AddedAt)
+		o.Text = track.Track.Name
+		o.AlbumTitle = track.Album.Title
+		o.Title = track.Track.Name
+		o.TrackId = track.Track.Id
+		o.Position = track.Position
+		tracks.Outlines = append(tracks.Outlines, o)
+	}
+	subscriptions := outline{Text: "订阅", Section: sectionSubscriptions}
+	for _, album := range doc.Subscriptions {
+		subscriptions.Outlines = append(subscriptions.Outlines, albumOutline(album, 0))
+	}
+
+	data, err := xml.MarshalIndent(opml{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:         "喜马拉雅收藏和订阅",
+			DateCreated:   dateCreated(doc.ExportedAt),
+			SchemaVersion: doc.Version,
+		},
+		Body: []outline{albums, tracks, subscriptions},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+func decodeOPML(data []byte) (Document, error) {
+	file := opml{}
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return Document{}, err
+	}
+	if file.Head.SchemaVersion > Version {
+		return Document{}, fmt.Errorf("%w: %d", ErrVersion, file.Head.SchemaVersion)
+	}
+	doc := Document{Version: Version}
+	if t, err := time.Parse(time.RFC1123Z, file.Head.DateCreated); err == nil {
+		doc.ExportedAt = t.Format(time.RFC3339)
+	}
+	for _, section := range file.Body {
+		switch section.Section {
+		case sectionFavoriteAlbums:
+			for _, o := range section.Outlines {
+				if o.AlbumId == 0 {
+					continue
+				}
+				doc.FavoriteAlbums = append(doc.FavoriteAlbums, favorite.Album{Album: o.album(), AddedAt: o.AddedAt})
+			}
+		case sectionFavoriteTracks:
+			for _, o := range section.Outlines {
+				if o.AlbumId == 0 || o.TrackId == 0 {
+					continue
+				}
+				album := o.album()
+				album.Title = o.AlbumTitle
+				name := o.Title
+				if name == "" {
+					name = o.Text
+				}
+				doc.FavoriteTracks = append(doc.FavoriteTracks, favorite.Track{
+					Album:    album,
+					Track:    common.TrackInfo{Id: o.TrackId, Name: name},
+					Position: o.Position,
+					AddedAt:  o.AddedAt,
+				})
+			}
+		case sectionSubscriptions:
+			for _, o := range section.Outlines {
+				if o.AlbumId != 0 {
+					doc.Subscriptions = append(doc.Subscriptions, o.album())
+				}
+			}
+		default:
+			// Plain outlines of other apps, import the ones with album ids as
+			// subscriptions.
+			for _, o := range append([]outline{section}, section.Outlines...) {
+				if o.AlbumId != 0 {
+					doc.Subscriptions = append(doc.Subscriptions, o.album())
+				}
+			}
+		}
+	}
+	return doc, nil
+}
+
+// RFC 822 time of OPML from the RFC 3339 time, empty if invalid.
+func dateCreated(exportedAt string) string {
+	t, err := time.Parse(time.RFC3339, exportedAt)
+	if err != nil {
+		return ""
+	}
+	return t.Format(time.RFC1123Z)
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+
+	"xmlymft-fyne-gui/app/exchange"
+	"xmlymft-fyne-gui/app/favorite"
+	"xmlymft-fyne-gui/app/subscription"
+)
+
+// Show the dialog to export the favorites and subscriptions, the format is
+// chosen by the file extension.
+func showExportDialog(window fyne.Window, favorites *favorite.Favorites, subscriptions *subscription.Watcher) {
+	save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if writer == nil {
+			return
+		}
+		defer writer.Close()
+
+		doc := exchange.Export(favorites, subscriptions)
+		data, err := exchange.Encode(doc, exchange.FormatOf(writer.URI().Extension()))
+		if err == nil {
+			_, err = writer.Write(data)
+		}
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		message := fmt.Sprintf(
+			"已导出 %d 个收藏专辑, %d 个收藏音频, %d 个订阅到 %s",
+			len(doc.FavoriteAlbums), len(doc.FavoriteTracks), len(doc.Subscriptions), writer.URI().Name(),
+		)
+		dialog.ShowInformation("导出", message, window)
+	}, window)
+	save.SetFileName("xmlymft" + exchange.JSON.Extension())
+	save.SetFilter(storage.NewExtensionFileFilter([]string{".json", ".opml", ".xml"}))
+	save.Show()
+}
+
+// Show the dialog to import the favorites and subscriptions from a JSON or
+// OPML file, then ask the import mode and show the report.
+func showImportDialog(window fyne.Window, favorites *favorite.Favorites, subscriptions *subscription.Watcher) {
+	open := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		doc, err := exchange.Decode(data)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("%s: %w", filepath.Base(reader.URI().Path()), err), window)
+			return
+		}
+
+		modes := []string{exchange.Merge.String(), exchange.Replace.String()}
+		modeRadio := widget.NewRadioGroup(modes, nil)
+		modeRadio.Horizontal = true
+		modeRadio.Required = true
+		modeRadio.SetSelected(exchange.Merge.String())
+		summary := widget.NewLabel(fmt.Sprintf(
+			"%d 个收藏专辑, %d 个收藏音频, %d 个订阅\n合并: 保留本地的收藏和订阅, 冲突时保留本地\n替换: 移除文件中没有的收藏和订阅",
+			len(doc.FavoriteAlbums), len(doc.FavoriteTracks), len(doc.Subscriptions),
+		))
+		contents := container.NewVBox(summary, modeRadio)
+		dialog.ShowCustomConfirm("导入", "导入", "取消", contents, func(ok bool) {
+			if !ok {
+				return
+			}
+			mode := exchange.Merge
+			if modeRadio.Selected == exchange.Replace.String() {
+				mode = exchange.Replace
+			}
+			importDocument(window, doc, mode, favorites, subscriptions)
+		}, window)
+	}, window)
+	open.SetFilter(storage.NewExtensionFileFilter([]string{".json", ".opml", ".xml"}))
+	open.Show()
+}
+
+// Import the document, new subscriptions query the server so it runs in
+// background.
+func importDocument(
+	window fyne.Window, doc exchange.Document, mode exchange.Mode,
+	favorites *favorite.Favorites, subscriptions *subscription.Watcher,
+) {
+	progress := dialog.NewProgressInfinite("导入", "正在导入...", window)
+	progress.Show()
+	go func() {
+		report, err := exchange.Import(doc, mode, favorites, subscriptions)
+		progress.Hide()
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		message := widget.NewLabel(report.String())
+		message.Wrapping = fyne.TextWrapBreak
+		scroll := container.NewVScroll(message)
+		scroll.SetMinSize(fyne.NewSize(window.Canvas().Size().Width*0.8, 200))
+		dialog.ShowCustom("导入完成", "关闭", scroll, window)
+	}()
+}
@@ -0,0 +1,225 @@
+package favorite
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+const FavoritesFilePath = "./favorites.json"
+
+// A favorite album, the album info is a snapshot when added.
+type Album struct {
+	Album common.AlbumInfo `json:"album"`
+	// Unix time when added.
+	AddedAt int64 `json:"addedAt"`
+}
+
+// A favorite track, the album and track info are snapshots when added.
+type Track struct {
+	Album common.AlbumInfo `json:"album"`
+	Track common.TrackInfo `json:"track"`
+	// Position in the album play list, starts from 1.
+	Position int `json:"position"`
+	// Unix time when added.
+	AddedAt int64 `json:"addedAt"`
+}
+
+// Favorites keeps the favorite albums and tracks in a JSON file.
+type Favorites struct {
+	path string
+
+	lock sync.RWMutex
+	// In added order.
+	albums []Album
+	tracks []Track
+
+	// Called when favorites changed.
+	OnChanged func()
+}
+
+type favoritesFile struct {
+	Albums []Album `json:"albums"`
+	Tracks []Track `json:"tracks"`
+}
+
+// Albums returns the favorite albums, the latest added first.
+func (f *Favorites) Albums() []Album {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	albums := make([]Album, len(f.albums))
+	for i, album := range f.albums {
+		albums[len(albums)-1-i] = album
+	}
+	return albums
+}
+
+// Tracks returns the favorite tracks, the latest added first.
+func (f *Favorites) Tracks() []Track {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	tracks := make([]Track, len(f.tracks))
+	for i, track := range f.tracks {
+		tracks[len(tracks)-1-i] = track
+	}
+	return tracks
+}
+
+// HasAlbum whether the album is a favorite.
+func (f *Favorites) HasAlbum(albumId int) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.findAlbum(albumId) >= 0
+}
+
+// HasTrack whether the track is a favorite.
+func (f *Favorites) HasTrack(trackId int) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.findTrack(trackId) >= 0
+}
+
+// AddAlbum add an album, an added album is updated with the album info.
+func (f *Favorites) AddAlbum(album common.AlbumInfo) error {
+	f.lock.Lock()
+	if i := f.findAlbum(album.Id); i >= 0 {
+		f.albums[i].Album = album
+	} else {
+		f.albums = append(f.albums, Album{Album: album, AddedAt: time.Now().Unix()})
+	}
+	err := f.save()
+	f.lock.Unlock()
+
+	f.changed()
+	return err
+}
+
+// RemoveAlbum remove an album.
+func (f *Favorites) RemoveAlbum(albumId int) error {
+	f.lock.Lock()
+	if i := f.findAlbum(albumId); i >= 0 {
+		f.albums = append(f.albums[:i], f.albums[i+1:]...)
+	}
+	err := f.save()
+	f.lock.Unlock()
+
+	f.changed()
+	return err
+}
+
+// AddTrack add a track of an album at position, an added track is updated
+// with the infos.
+func (f *Favorites) AddTrack(album common.AlbumInfo, track common.TrackInfo, position int) error {
+	f.lock.Lock()
+	favorite := Track{Album: album, Track: track, Position: position, AddedAt: time.Now().Unix()}
+	if i := f.findTrack(track.Id); i >= 0 {
+		favorite.AddedAt = f.tracks[i].AddedAt
+		f.tracks[i] = favorite
+	} else {
+		f.tracks = append(f.tracks, favorite)
+	}
+	err := f.save()
+	f.lock.Unlock()
+
+	f.changed()
+	return err
+}
+
+// RemoveTrack remove a track.
+func (f *Favorites) RemoveTrack(trackId int) error {
+	f.lock.Lock()
+	if i := f.findTrack(trackId); i >= 0 {
+		f.tracks = append(f.tracks[:i], f.tracks[i+1:]...)
+	}
+	err := f.save()
+	f.lock.Unlock()
+
+	f.changed()
+	return err
+}
+
+// Set replace all favorites, albums and tracks in added order.
+func (f *Favorites) Set(albums []Album, tracks []Track) error {
+	f.lock.Lock()
+	f.albums = append([]Album{}, albums...)
+	f.tracks = append([]Track{}, tracks...)
+	err := f.save()
+	f.lock.Unlock()
+
+	f.changed()
+	return err
+}
+
+// Index of an album, -1 if not found, requires lock.
+func (f *Favorites) findAlbum(albumId int) int {
+	for i, album := range f.albums {
+		if album.Album.Id == albumId {
+			return i
+		}
+	}
+	return -1
+}
+
+// Index of a track, -1 if not found, requires lock.
+func (f *Favorites) findTrack(trackId int) int {
+	for i, track := range f.tracks {
+		if track.Track.Id == trackId {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *Favorites) changed() {
+	if f.OnChanged != nil {
+		f.OnChanged()
+	}
+}
+
+// Save the favorites file, requires lock.
+func (f *Favorites) save() error {
+	if f.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(favoritesFile{Albums: f.albums, Tracks: f.tracks})
+	if err != nil {
+		return err
+	}
+	tmppath := f.path + ".tmp"
+	if err = os.WriteFile(tmppath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, f.path)
+}
+
+// LoadFavorites load the favorites file, no favorite if the file not exists,
+// or failed to parse and moved aside.
+func LoadFavorites(path string) (*Favorites, error) {
+	favorites := &Favorites{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return favorites, nil
+	} else if err != nil {
+		favorites.path = ""
+		return favorites, err
+	}
+	file := favoritesFile{}
+	if err = json.Unmarshal(data, &file); err != nil {
+		moved, err := utils.SetAsideCorrupt(path, err)
+		if !moved {
+			favorites.path = ""
+		}
+		return favorites, err
+	}
+	favorites.albums, favorites.tracks = file.Albums, file.Tracks
+	return favorites, nil
+}
@@ -0,0 +1,57 @@
+package favorite
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Favorite album or track list row.
+type FavoriteViewItem struct {
+	widget.BaseWidget
+
+	index int
+
+	title     *widget.Label
+	detail    *widget.Label
+	actionBtn *widget.Button
+	removeBtn *widget.Button
+}
+
+func (f *FavoriteViewItem) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(
+		nil, nil, f.actionBtn, f.removeBtn,
+		container.NewVBox(f.title, f.detail),
+	))
+}
+
+// Update the item at index of the list with a title and a detail line.
+func (f *FavoriteViewItem) Update(index int, title string, detail string) {
+	f.index = index
+	f.title.SetText(title)
+	f.detail.SetText(detail)
+}
+
+// Create a favorite list row, onAction is called with the index of the item
+// when the action button with the icon tapped, onRemove is called with the
+// index when the remove button tapped.
+func NewFavoriteViewItem(actionIcon fyne.Resource, onAction func(index int), onRemove func(index int)) *FavoriteViewItem {
+	item := &FavoriteViewItem{
+		title:  widget.NewLabel(""),
+		detail: widget.NewLabel(""),
+	}
+	item.title.Wrapping = fyne.TextTruncate
+	item.detail.Wrapping = fyne.TextTruncate
+	item.detail.TextStyle = fyne.TextStyle{Italic: true}
+	item.actionBtn = widget.NewButtonWithIcon("", actionIcon, func() {
+		onAction(item.index)
+	})
+	item.actionBtn.Importance = widget.LowImportance
+	item.removeBtn = widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		onRemove(item.index)
+	})
+	item.removeBtn.Importance = widget.LowImportance
+	item.ExtendBaseWidget(item)
+	return item
+}
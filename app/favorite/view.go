@@ -0,0 +1,161 @@
+package favorite
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/funte/xmlymft/common"
+)
+
+// Favorites view, lists the favorite albums and tracks in two tabs.
+type View struct {
+	appwin    fyne.Window
+	favorites *Favorites
+
+	contents  fyne.CanvasObject
+	albumTab  *container.TabItem
+	trackTab  *container.TabItem
+	tabs      *container.AppTabs
+	albumList *widget.List
+	trackList *widget.List
+
+	albums []Album
+	tracks []Track
+
+	// Called to show the play list of an album.
+	onOpenAlbum func(album common.AlbumInfo)
+	// Called to play the track at index of the tracks.
+	onPlay func(tracks []Track, index int)
+
+	// Called to import favorites and subscriptions from a file.
+	OnImport func()
+	// Called to export favorites and subscriptions to a file.
+	OnExport func()
+}
+
+// Get the contents to show.
+func (v *View) Contents() fyne.CanvasObject {
+	return v.contents
+}
+
+// Refresh load the favorites again.
+func (v *View) Refresh() {
+	v.albums = v.favorites.Albums()
+	v.tracks = v.favorites.Tracks()
+	v.albumTab.Text = fmt.Sprintf("专辑 (%d)", len(v.albums))
+	v.trackTab.Text = fmt.Sprintf("音频 (%d)", len(v.tracks))
+	v.tabs.Refresh()
+	v.albumList.Refresh()
+	v.trackList.Refresh()
+}
+
+func (v *View) openAlbum(index int) {
+	if index < len(v.albums) && v.onOpenAlbum != nil {
+		v.onOpenAlbum(v.albums[index].Album)
+	}
+}
+
+func (v *View) removeAlbum(index int) {
+	if index >= len(v.albums) {
+		return
+	}
+	if err := v.favorites.RemoveAlbum(v.albums[index].Album.Id); err != nil {
+		dialog.ShowError(err, v.appwin)
+	}
+}
+
+func (v *View) playTrack(index int) {
+	if index < len(v.tracks) && v.onPlay != nil {
+		v.onPlay(v.tracks, index)
+	}
+}
+
+func (v *View) removeTrack(index int) {
+	if index >= len(v.tracks) {
+		return
+	}
+	if err := v.favorites.RemoveTrack(v.tracks[index].Track.Id); err != nil {
+		dialog.ShowError(err, v.appwin)
+	}
+}
+
+// NewView create the favorites view, onOpenAlbum is called to show the play
+// list of a favorite album, onPlay is called to play the favorite tracks from
+// index.
+func NewView(
+	window fyne.Window, favorites *Favorites,
+	onOpenAlbum func(album common.AlbumInfo), onPlay func(tracks []Track, index int),
+) *View {
+	view := new(View)
+	view.appwin = window
+	view.favorites = favorites
+	view.onOpenAlbum = onOpenAlbum
+	view.onPlay = onPlay
+
+	view.albumList = widget.NewList(
+		func() int {
+			return len(view.albums)
+		},
+		func() fyne.CanvasObject {
+			return NewFavoriteViewItem(theme.NavigateNextIcon(), view.openAlbum, view.removeAlbum)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			if i < len(view.albums) {
+				album := view.albums[i].Album
+				detail := fmt.Sprintf("%s  %d 集", album.Author, album.TracksCount)
+				o.(*FavoriteViewItem).Update(i, album.Title, detail)
+			}
+		},
+	)
+	view.albumList.OnSelected = func(id widget.ListItemID) {
+		view.albumList.Unselect(id)
+		view.openAlbum(id)
+	}
+	view.trackList = widget.NewList(
+		func() int {
+			return len(view.tracks)
+		},
+		func() fyne.CanvasObject {
+			return NewFavoriteViewItem(theme.MediaPlayIcon(), view.playTrack, view.removeTrack)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			if i < len(view.tracks) {
+				track := view.tracks[i]
+				detail := fmt.Sprintf("%s  第 %d 集", track.Album.Title, track.Position)
+				o.(*FavoriteViewItem).Update(i, track.Track.Name, detail)
+			}
+		},
+	)
+	view.trackList.OnSelected = func(id widget.ListItemID) {
+		view.trackList.Unselect(id)
+		view.playTrack(id)
+	}
+	view.albumTab = container.NewTabItem("专辑", view.albumList)
+	view.trackTab = container.NewTabItem("音频", view.trackList)
+	view.tabs = container.NewAppTabs(view.albumTab, view.trackTab)
+	importBtn := widget.NewButtonWithIcon("导入", theme.FolderOpenIcon(), func() {
+		if view.OnImport != nil {
+			view.OnImport()
+		}
+	})
+	importBtn.Importance = widget.LowImportance
+	exportBtn := widget.NewButtonWithIcon("导出", theme.DocumentSaveIcon(), func() {
+		if view.OnExport != nil {
+			view.OnExport()
+		}
+	})
+	exportBtn.Importance = widget.LowImportance
+	view.contents = container.NewBorder(
+		container.NewHBox(layout.NewSpacer(), importBtn, exportBtn), nil, nil, nil,
+		view.tabs,
+	)
+
+	view.Refresh()
+
+	return view
+}
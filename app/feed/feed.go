@@ -0,0 +1,162 @@
+package feed
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/download"
+)
+
+const ITunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+// Publish time of the first episode of albums without a creation time.
+var defaultPublishTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type rss struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	ITunes  string   `xml:"xmlns:itunes,attr"`
+	Channel channel  `xml:"channel"`
+}
+
+type channel struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description"`
+	Language    string       `xml:"language"`
+	Author      string       `xml:"itunes:author,omitempty"`
+	Summary     string       `xml:"itunes:summary,omitempty"`
+	Image       *itunesImage `xml:"itunes:image,omitempty"`
+	Category    *category    `xml:"itunes:category,omitempty"`
+	Type        string       `xml:"itunes:type"`
+	Items       []item       `xml:"item"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type category struct {
+	Text string `xml:"text,attr"`
+}
+
+type item struct {
+	Title     string    `xml:"title"`
+	GUID      guid      `xml:"guid"`
+	PubDate   string    `xml:"pubDate"`
+	Enclosure enclosure `xml:"enclosure"`
+	Duration  int       `xml:"itunes:duration,omitempty"`
+	Episode   int       `xml:"itunes:episode,omitempty"`
+}
+
+type guid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type enclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// An album with its downloaded tracks.
+type Album struct {
+	Info common.AlbumInfo
+	// Records of the downloaded tracks ordered by the album track order.
+	Records []download.Record
+}
+
+// Group records by album, albums are sorted by title.
+func groupAlbums(records []download.Record) []Album {
+	byId := map[int]*Album{}
+	albums := []*Album{}
+	for _, record := range records {
+		album, ok := byId[record.Album.Id]
+		if !ok {
+			album = &Album{Info: record.Album}
+			byId[record.Album.Id] = album
+			albums = append(albums, album)
+		}
+		album.Records = append(album.Records, record)
+	}
+
+	result := make([]Album, len(albums))
+	for i, album := range albums {
+		sort.Slice(album.Records, func(i, j int) bool {
+			return album.Records[i].Position < album.Records[j].Position
+		})
+		result[i] = *album
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Info.Title < result[j].Info.Title
+	})
+	return result
+}
+
+// MIME type of a track file.
+func audioType(record download.Record) string {
+	ext := filepath.Ext(record.Path)
+	switch strings.ToLower(ext) {
+	case ".mp3":
+		return "audio/mpeg"
+	case ".m4a", ".m4b", ".mp4":
+		return "audio/mp4"
+	}
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// Build the podcast feed of an album, baseURL is the server URL seen by the
+// client.
+func buildFeed(baseURL string, album Album) ([]byte, error) {
+	// Podcast apps order the episodes by publish time, derive it from the
+	// track order so the episodes are listed in order.
+	published := download.AlbumCreatedTime(album.Info)
+	if published.IsZero() {
+		published = defaultPublishTime
+	}
+
+	ch := channel{
+		Title:       album.Info.Title,
+		Link:        fmt.Sprintf("%s/", baseURL),
+		Description: album.Info.Intro,
+		Language:    "zh-cn",
+		Author:      album.Info.Author,
+		Summary:     album.Info.Intro,
+		Image:       &itunesImage{fmt.Sprintf("%s/covers/%d", baseURL, album.Info.Id)},
+		Type:        "serial",
+	}
+	if album.Info.Category != "" {
+		ch.Category = &category{album.Info.Category}
+	}
+	for _, record := range album.Records {
+		ch.Items = append(ch.Items, item{
+			Title:   record.Track.Name,
+			GUID:    guid{Value: fmt.Sprintf("xmly-track-%d", record.Track.Id)},
+			PubDate: published.Add(time.Minute * time.Duration(record.Position)).Format(time.RFC1123Z),
+			Enclosure: enclosure{
+				URL:    fmt.Sprintf("%s/tracks/%d%s", baseURL, record.Track.Id, filepath.Ext(record.Path)),
+				Length: record.Size,
+				Type:   audioType(record),
+			},
+			Duration: record.Track.Duration,
+			Episode:  record.Position,
+		})
+	}
+
+	data, err := xml.MarshalIndent(rss{Version: "2.0", ITunes: ITunesNamespace, Channel: ch}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
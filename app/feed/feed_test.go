@@ -0,0 +1,134 @@
+package feed
+
+import (
+	"encoding/xml"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/download"
+)
+
+func testRecord(album common.AlbumInfo, trackId int, position int, path string) download.Record {
+	return download.Record{
+		Album:    album,
+		Track:    common.TrackInfo{Id: trackId, Name: fmt.Sprintf("第%d集", position), Duration: 60 * position},
+		Position: position,
+		Path:     path,
+		Size:     int64(1000 * trackId),
+	}
+}
+
+func TestGroupAlbums(t *testing.T) {
+	first := common.AlbumInfo{Id: 2, Title: "A 专辑"}
+	second := common.AlbumInfo{Id: 1, Title: "B 专辑"}
+	albums := groupAlbums([]download.Record{
+		testRecord(second, 11, 2, "b/2.mp3"),
+		testRecord(first, 21, 3, "a/3.mp3"),
+		testRecord(second, 12, 1, "b/1.mp3"),
+		testRecord(first, 22, 1, "a/1.mp3"),
+		testRecord(first, 23, 2, "a/2.mp3"),
+	})
+
+	// Sorted by title, the records by position.
+	want := []struct {
+		albumId int
+		tracks  []int
+	}{
+		{2, []int{22, 23, 21}},
+		{1, []int{12, 11}},
+	}
+	if len(albums) != len(want) {
+		t.Fatalf("%d albums, want %d", len(albums), len(want))
+	}
+	for i, album := range albums {
+		tracks := []int{}
+		for _, record := range album.Records {
+			tracks = append(tracks, record.Track.Id)
+		}
+		if album.Info.Id != want[i].albumId || fmt.Sprint(tracks) != fmt.Sprint(want[i].tracks) {
+			t.Errorf("album %d: %d tracks %v, want %d tracks %v", i, album.Info.Id, tracks, want[i].albumId, want[i].tracks)
+		}
+	}
+}
+
+// The parsed items of a feed.
+type testFeed struct {
+	Items []struct {
+		Title     string `xml:"title"`
+		GUID      string `xml:"guid"`
+		PubDate   string `xml:"pubDate"`
+		Enclosure struct {
+			URL    string `xml:"url,attr"`
+			Length int64  `xml:"length,attr"`
+			Type   string `xml:"type,attr"`
+		} `xml:"enclosure"`
+	} `xml:"channel>item"`
+}
+
+func TestBuildFeed(t *testing.T) {
+	created := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+	info := common.AlbumInfo{Id: 1, Title: "专辑", CreatedTime: int(created.UnixMilli())}
+	album := groupAlbums([]download.Record{
+		testRecord(info, 13, 3, "专辑/第3集.mp3"),
+		testRecord(info, 11, 1, "专辑/第1集.m4a"),
+		testRecord(info, 12, 2, "专辑/第2集.mp3"),
+	})[0]
+
+	data, err := buildFeed("http://192.168.1.2:8080", album)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := testFeed{}
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		url    string
+		length int64
+		typ    string
+	}{
+		{"http://192.168.1.2:8080/tracks/11.m4a", 11000, "audio/mp4"},
+		{"http://192.168.1.2:8080/tracks/12.mp3", 12000, "audio/mpeg"},
+		{"http://192.168.1.2:8080/tracks/13.mp3", 13000, "audio/mpeg"},
+	}
+	if len(feed.Items) != len(want) {
+		t.Fatalf("%d items, want %d", len(feed.Items), len(want))
+	}
+	last := time.Time{}
+	for i, item := range feed.Items {
+		enclosure := item.Enclosure
+		if enclosure.URL != want[i].url || enclosure.Length != want[i].length || enclosure.Type != want[i].typ {
+			t.Errorf("item %d enclosure %+v, want %+v", i, enclosure, want[i])
+		}
+		// Published in the track order after the album created.
+		published, err := time.Parse(time.RFC1123Z, item.PubDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !published.After(created) || !published.After(last) {
+			t.Errorf("item %d published at %v, after %v", i, published, last)
+		}
+		last = published
+	}
+}
+
+func TestBuildFeedUnknownCreatedTime(t *testing.T) {
+	info := common.AlbumInfo{Id: 1, Title: "专辑"}
+	data, err := buildFeed("http://localhost", Album{Info: info, Records: []download.Record{
+		testRecord(info, 11, 1, "专辑/第1集.mp3"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := testFeed{}
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		t.Fatal(err)
+	}
+	published, err := time.Parse(time.RFC1123Z, feed.Items[0].PubDate)
+	if err != nil || !published.Equal(defaultPublishTime.Add(time.Minute)) {
+		t.Errorf("published at %v, want %v", published, defaultPublishTime.Add(time.Minute))
+	}
+}
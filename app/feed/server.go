@@ -0,0 +1,233 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"xmlymft-fyne-gui/app/download"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>喜马拉雅免费听</title></head>
+<body>
+<h1>已下载的专辑</h1>
+<ul>
+{{range .}}<li><a href="/feeds/{{.Info.Id}}.xml">{{.Info.Title}}</a> ({{len .Records}} 集)</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// Server serves the downloaded albums as podcast feeds to the devices on the
+// LAN, it runs in the GUI process beside the API server.
+type Server struct {
+	downloader *download.Manager
+
+	lock   sync.Mutex
+	server *http.Server
+	port   int
+}
+
+// Records of the downloaded tracks whose files exist.
+func (s *Server) records() []download.Record {
+	records := []download.Record{}
+	for _, record := range s.downloader.History("") {
+		if _, err := os.Stat(record.Path); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// Find a downloaded album.
+func (s *Server) album(albumId int) (Album, bool) {
+	for _, album := range groupAlbums(s.records()) {
+		if album.Info.Id == albumId {
+			return album, true
+		}
+	}
+	return Album{}, false
+}
+
+// Parse the id in a path like "/prefix/{id}.ext".
+func parseId(path string, prefix string) (int, error) {
+	name := strings.TrimPrefix(path, prefix)
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return strconv.Atoi(name)
+}
+
+// URL of the server seen by the client.
+func baseURL(r *http.Request) string {
+	return "http://" + r.Host
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, groupAlbums(s.records())); err != nil {
+		log.Printf("feed index: %s", err)
+	}
+}
+
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	albumId, err := parseId(r.URL.Path, "/feeds/")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	album, ok := s.album(albumId)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := buildFeed(baseURL(r), album)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write(data)
+}
+
+func (s *Server) handleTrack(w http.ResponseWriter, r *http.Request) {
+	trackId, err := parseId(r.URL.Path, "/tracks/")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	// Only the recorded files are served.
+	for _, record := range s.records() {
+		if record.Track.Id != trackId {
+			continue
+		}
+		file, err := os.Open(record.Path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", audioType(record))
+		// Supports range requests for seeking.
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
+	albumId, err := parseId(r.URL.Path, "/covers/")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	album, ok := s.album(albumId)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	cover := s.downloader.AlbumCover(album.Info)
+	if len(cover) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(cover))
+	w.Write(cover)
+}
+
+// Running whether the server is running.
+func (s *Server) Running() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.server != nil
+}
+
+// Handler of the index, feeds, tracks and covers.
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/feeds/", s.handleFeed)
+	mux.HandleFunc("/tracks/", s.handleTrack)
+	mux.HandleFunc("/covers/", s.handleCover)
+	return mux
+}
+
+// Start listening on the port of all interfaces, the running server is
+// stopped first.
+func (s *Server) Start(port int) error {
+	s.Stop()
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: s.handler()}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("feed server: %s", err)
+		}
+	}()
+
+	s.lock.Lock()
+	s.server = server
+	s.port = port
+	s.lock.Unlock()
+	return nil
+}
+
+// Stop the server if running.
+func (s *Server) Stop() {
+	s.lock.Lock()
+	server := s.server
+	s.server = nil
+	s.lock.Unlock()
+
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+	}
+}
+
+// Apply start, restart or stop the server as configured.
+func (s *Server) Apply(enabled bool, port int) error {
+	if !enabled {
+		s.Stop()
+		return nil
+	}
+	s.lock.Lock()
+	running := s.server != nil && s.port == port
+	s.lock.Unlock()
+	if running {
+		return nil
+	}
+	return s.Start(port)
+}
+
+func NewServer(downloader *download.Manager) *Server {
+	return &Server{downloader: downloader}
+}
@@ -0,0 +1,109 @@
+package feed
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/download"
+	"xmlymft-fyne-gui/app/settings"
+)
+
+// Serve the records of files written to a temporary directory, returns the
+// server URL and the directory.
+func newTestFeedServer(t *testing.T, records ...download.Record) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	loaded, err := download.LoadRecords(filepath.Join(dir, "records.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		record.Path = filepath.Join(dir, record.Path)
+		if err := os.MkdirAll(filepath.Dir(record.Path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(record.Path, []byte(record.Track.Name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := loaded.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewServer(download.NewManager("http://127.0.0.1:0", *settings.NewSettings(), loaded))
+	server := httptest.NewServer(s.handler())
+	t.Cleanup(server.Close)
+	return server.URL, dir
+}
+
+func getFeed(t *testing.T, url string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestHandleTrack(t *testing.T) {
+	info := common.AlbumInfo{Id: 1, Title: "专辑"}
+	url, dir := newTestFeedServer(t,
+		testRecord(info, 11, 1, "专辑/第1集.mp3"),
+		testRecord(info, 12, 2, "专辑/第2集.m4a"),
+	)
+	// A file in the download directory not recorded.
+	if err := os.WriteFile(filepath.Join(dir, "专辑", "第3集.mp3"), []byte("unrecorded"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A recorded file deleted.
+	if err := os.Remove(filepath.Join(dir, "专辑", "第2集.m4a")); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := getFeed(t, url+"/tracks/11.mp3")
+	if resp.StatusCode != http.StatusOK || string(body) != "第1集" {
+		t.Errorf("recorded track: status %d, body %q", resp.StatusCode, body)
+	}
+	if typ := resp.Header.Get("Content-Type"); typ != "audio/mpeg" {
+		t.Errorf("content type %q, want audio/mpeg", typ)
+	}
+	for _, path := range []string{
+		"/tracks/12.m4a", "/tracks/13.mp3", "/tracks/99.mp3", "/tracks/abc.mp3",
+		"/tracks/../records.json", "/tracks/%E4%B8%93%E8%BE%91/%E7%AC%AC3%E9%9B%86.mp3",
+	} {
+		if resp, _ := getFeed(t, url+path); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandleFeed(t *testing.T) {
+	info := common.AlbumInfo{Id: 1, Title: "专辑"}
+	url, _ := newTestFeedServer(t, testRecord(info, 11, 1, "专辑/第1集.mp3"))
+
+	resp, body := getFeed(t, url+"/feeds/1.xml")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("feed: status %d", resp.StatusCode)
+	}
+	feed := testFeed{}
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 1 || feed.Items[0].Enclosure.URL != url+"/tracks/11.mp3" {
+		t.Errorf("feed items %+v", feed.Items)
+	}
+	if resp, _ := getFeed(t, url+"/feeds/2.xml"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown album: status %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,417 @@
+// Package mpris exposes the player on the D-Bus session bus as a MPRIS media
+// player, so the media keys and the desktop media widgets control it.
+package mpris
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+
+	"xmlymft-fyne-gui/app/player"
+	"xmlymft-fyne-gui/utils"
+)
+
+const (
+	BusName         = "org.mpris.MediaPlayer2.xmlymft"
+	ObjectPath      = dbus.ObjectPath("/org/mpris/MediaPlayer2")
+	RootInterface   = "org.mpris.MediaPlayer2"
+	PlayerInterface = "org.mpris.MediaPlayer2.Player"
+	Identity        = "喜马拉雅免费听"
+
+	propertiesInterface = "org.freedesktop.DBus.Properties"
+	noTrack             = dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+)
+
+// Position jump to report as seeked, larger than the drift of the updates.
+const seekedThreshold = time.Second * 2
+
+const introspectXML = `
+	<interface name="org.mpris.MediaPlayer2">
+		<method name="Raise"/>
+		<method name="Quit"/>
+		<property name="CanQuit" type="b" access="read"/>
+		<property name="CanRaise" type="b" access="read"/>
+		<property name="HasTrackList" type="b" access="read"/>
+		<property name="Identity" type="s" access="read"/>
+		<property name="SupportedUriSchemes" type="as" access="read"/>
+		<property name="SupportedMimeTypes" type="as" access="read"/>
+	</interface>
+	<interface name="org.mpris.MediaPlayer2.Player">
+		<method name="Next"/>
+		<method name="Previous"/>
+		<method name="Pause"/>
+		<method name="PlayPause"/>
+		<method name="Stop"/>
+		<method name="Play"/>
+		<method name="Seek">
+			<arg name="Offset" type="x" direction="in"/>
+		</method>
+		<method name="SetPosition">
+			<arg name="TrackId" type="o" direction="in"/>
+			<arg name="Position" type="x" direction="in"/>
+		</method>
+		<method name="OpenUri">
+			<arg name="Uri" type="s" direction="in"/>
+		</method>
+		<signal name="Seeked">
+			<arg name="Position" type="x"/>
+		</signal>
+		<property name="PlaybackStatus" type="s" access="read"/>
+		<property name="Rate" type="d" access="readwrite"/>
+		<property name="Metadata" type="a{sv}" access="read"/>
+		<property name="Volume" type="d" access="readwrite"/>
+		<property name="Position" type="x" access="read"/>
+		<property name="MinimumRate" type="d" access="read"/>
+		<property name="MaximumRate" type="d" access="read"/>
+		<property name="CanGoNext" type="b" access="read"/>
+		<property name="CanGoPrevious" type="b" access="read"/>
+		<property name="CanPlay" type="b" access="read"/>
+		<property name="CanPause" type="b" access="read"/>
+		<property name="CanSeek" type="b" access="read"/>
+		<property name="CanControl" type="b" access="read"/>
+	</interface>`
+
+// Service of the MPRIS media player.
+type Service struct {
+	conn  *dbus.Conn
+	panel *player.Panel
+	// Whether the connection is owned, closed with the service.
+	ownConn bool
+	// Bus name owned.
+	name string
+
+	lock sync.Mutex
+	// Player properties last emitted, except the position.
+	emitted map[string]dbus.Variant
+	// Position and time of the last update, to report seeking.
+	position  time.Duration
+	updatedAt time.Time
+	trackId   dbus.ObjectPath
+}
+
+// Start connect to the session bus and export the panel as a MPRIS media
+// player.
+func Start(panel *player.Panel) (*Service, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	service, err := Export(conn, panel)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	service.ownConn = true
+	return service, nil
+}
+
+// Export the panel as a MPRIS media player on a connection, a private bus
+// works too.
+func Export(conn *dbus.Conn, panel *player.Panel) (*Service, error) {
+	service := &Service{conn: conn, panel: panel}
+	service.emitted = service.playerProperties()
+
+	exports := []struct {
+		v     interface{}
+		iface string
+		// D-Bus method names differ from the Go ones.
+		mapping map[string]string
+	}{
+		{rootMethods{service}, RootInterface, nil},
+		{playerMethods{service}, PlayerInterface, map[string]string{"SeekBy": "Seek"}},
+		{properties{service}, propertiesInterface, nil},
+		{introspect.Introspectable(introspect.IntrospectDeclarationString + "<node>" + introspectXML +
+			introspect.IntrospectDataString + prop.IntrospectDataString + "</node>"),
+			"org.freedesktop.DBus.Introspectable", nil},
+	}
+	for _, e := range exports {
+		if err := conn.ExportWithMap(e.v, e.mapping, ObjectPath, e.iface); err != nil {
+			return nil, err
+		}
+	}
+
+	// Another instance owns the name, take an instance name as the
+	// specification suggests.
+	name := BusName
+	reply, err := conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	if err == nil && reply != dbus.RequestNameReplyPrimaryOwner {
+		name = fmt.Sprintf("%s.instance%d", BusName, os.Getpid())
+		reply, err = conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return nil, fmt.Errorf("D-Bus name %s already taken", name)
+	}
+	service.name = name
+	return service, nil
+}
+
+// Update emit the changed properties and the seeking, called when the panel
+// refreshed.
+func (s *Service) Update() {
+	if s == nil {
+		return
+	}
+	current := s.playerProperties()
+	position, _ := s.panel.Player().Position()
+
+	s.lock.Lock()
+	changed := map[string]dbus.Variant{}
+	for name, value := range current {
+		if last, ok := s.emitted[name]; !ok || !reflect.DeepEqual(last.Value(), value.Value()) {
+			changed[name] = value
+		}
+	}
+	s.emitted = current
+	// Jumped from where it should be by the elapsed time.
+	expected := s.position
+	if current["PlaybackStatus"].Value() == "Playing" {
+		expected += time.Duration(float64(time.Since(s.updatedAt)) * s.panel.Player().Speed())
+	}
+	trackId := s.trackId
+	seeked := trackId == currentTrackId(current) &&
+		math.Abs(float64(position-expected)) > float64(seekedThreshold)
+	s.position = position
+	s.updatedAt = time.Now()
+	s.trackId = currentTrackId(current)
+	s.lock.Unlock()
+
+	if len(changed) > 0 {
+		s.conn.Emit(ObjectPath, propertiesInterface+".PropertiesChanged",
+			PlayerInterface, changed, []string{})
+	}
+	if seeked {
+		s.conn.Emit(ObjectPath, PlayerInterface+".Seeked", position.Microseconds())
+	}
+}
+
+// Close release the bus name, and close the connection if connected by Start.
+func (s *Service) Close() error {
+	if s == nil {
+		return nil
+	}
+	if s.ownConn {
+		return s.conn.Close()
+	}
+	_, err := s.conn.ReleaseName(s.name)
+	return err
+}
+
+func trackObjectPath(item player.Item) dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("/org/mpris/MediaPlayer2/track/%d", item.Track.Id))
+}
+
+func currentTrackId(properties map[string]dbus.Variant) dbus.ObjectPath {
+	metadata := properties["Metadata"].Value().(map[string]dbus.Variant)
+	return metadata["mpris:trackid"].Value().(dbus.ObjectPath)
+}
+
+func (s *Service) metadata() map[string]dbus.Variant {
+	item, ok := s.panel.Playing()
+	if !ok {
+		return map[string]dbus.Variant{"mpris:trackid": dbus.MakeVariant(noTrack)}
+	}
+	_, duration := s.panel.Player().Position()
+	if duration <= 0 {
+		duration = time.Duration(item.Track.Duration) * time.Second
+	}
+	metadata := map[string]dbus.Variant{
+		"mpris:trackid":     dbus.MakeVariant(trackObjectPath(item)),
+		"mpris:length":      dbus.MakeVariant(duration.Microseconds()),
+		"xesam:title":       dbus.MakeVariant(item.Track.Name),
+		"xesam:album":       dbus.MakeVariant(item.Album.Title),
+		"xesam:trackNumber": dbus.MakeVariant(int32(item.Position)),
+	}
+	if item.Album.Author != "" {
+		metadata["xesam:artist"] = dbus.MakeVariant([]string{item.Album.Author})
+	}
+	if item.Album.Cover != "" {
+		metadata["mpris:artUrl"] = dbus.MakeVariant(utils.AlbumCoverURL(item.Album.Cover))
+	}
+	return metadata
+}
+
+func (s *Service) playbackStatus() string {
+	switch s.panel.Player().State() {
+	case player.Playing:
+		return "Playing"
+	case player.Paused:
+		return "Paused"
+	}
+	return "Stopped"
+}
+
+// Properties of the player interface, except the position.
+func (s *Service) playerProperties() map[string]dbus.Variant {
+	_, loaded := s.panel.Playing()
+	return map[string]dbus.Variant{
+		"PlaybackStatus": dbus.MakeVariant(s.playbackStatus()),
+		"Rate":           dbus.MakeVariant(s.panel.Player().Speed()),
+		"Metadata":       dbus.MakeVariant(s.metadata()),
+		"Volume":         dbus.MakeVariant(s.panel.Player().Volume()),
+		"MinimumRate":    dbus.MakeVariant(player.MinSpeed),
+		"MaximumRate":    dbus.MakeVariant(player.MaxSpeed),
+		"CanGoNext":      dbus.MakeVariant(s.panel.HasNext()),
+		"CanGoPrevious":  dbus.MakeVariant(s.panel.HasPrevious()),
+		"CanPlay":        dbus.MakeVariant(loaded),
+		"CanPause":       dbus.MakeVariant(loaded),
+		"CanSeek":        dbus.MakeVariant(loaded),
+		"CanControl":     dbus.MakeVariant(true),
+	}
+}
+
+func (s *Service) rootProperties() map[string]dbus.Variant {
+	return map[string]dbus.Variant{
+		"CanQuit":             dbus.MakeVariant(false),
+		"CanRaise":            dbus.MakeVariant(false),
+		"HasTrackList":        dbus.MakeVariant(false),
+		"Identity":            dbus.MakeVariant(Identity),
+		"SupportedUriSchemes": dbus.MakeVariant([]string{}),
+		"SupportedMimeTypes":  dbus.MakeVariant([]string{}),
+	}
+}
+
+// Methods of org.mpris.MediaPlayer2.
+type rootMethods struct {
+	s *Service
+}
+
+// Raise is not supported, CanRaise is false.
+func (r rootMethods) Raise() *dbus.Error {
+	return nil
+}
+
+// Quit is not supported, CanQuit is false.
+func (r rootMethods) Quit() *dbus.Error {
+	return nil
+}
+
+// Methods of org.mpris.MediaPlayer2.Player.
+type playerMethods struct {
+	s *Service
+}
+
+func (p playerMethods) Next() *dbus.Error {
+	p.s.panel.Next()
+	return nil
+}
+
+func (p playerMethods) Previous() *dbus.Error {
+	p.s.panel.Previous()
+	return nil
+}
+
+func (p playerMethods) Pause() *dbus.Error {
+	p.s.panel.Pause()
+	return nil
+}
+
+func (p playerMethods) PlayPause() *dbus.Error {
+	p.s.panel.Toggle()
+	return nil
+}
+
+func (p playerMethods) Stop() *dbus.Error {
+	p.s.panel.Stop()
+	return nil
+}
+
+func (p playerMethods) Play() *dbus.Error {
+	p.s.panel.Play()
+	return nil
+}
+
+// SeekBy seek forward or backward by offset microseconds, past the end plays
+// the next, exported as Seek.
+func (p playerMethods) SeekBy(offset int64) *dbus.Error {
+	position, duration := p.s.panel.Player().Position()
+	position += time.Duration(offset) * time.Microsecond
+	if duration > 0 && position >= duration {
+		p.s.panel.Next()
+		return nil
+	}
+	p.s.panel.Seek(position)
+	return nil
+}
+
+// SetPosition seek to position microseconds of the track, ignored if the track
+// is not the playing one.
+func (p playerMethods) SetPosition(trackId dbus.ObjectPath, position int64) *dbus.Error {
+	item, ok := p.s.panel.Playing()
+	if !ok || trackObjectPath(item) != trackId {
+		return nil
+	}
+	_, duration := p.s.panel.Player().Position()
+	at := time.Duration(position) * time.Microsecond
+	if at < 0 || (duration > 0 && at > duration) {
+		return nil
+	}
+	p.s.panel.Seek(at)
+	return nil
+}
+
+// OpenUri is not supported, no URI schemes supported.
+func (p playerMethods) OpenUri(uri string) *dbus.Error {
+	return dbus.MakeFailedError(fmt.Errorf("open URI not supported: %s", uri))
+}
+
+// Methods of org.freedesktop.DBus.Properties, the values are read from the
+// panel at calling.
+type properties struct {
+	s *Service
+}
+
+func (p properties) all(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	switch iface {
+	case RootInterface:
+		return p.s.rootProperties(), nil
+	case PlayerInterface:
+		all := p.s.playerProperties()
+		position, _ := p.s.panel.Player().Position()
+		all["Position"] = dbus.MakeVariant(position.Microseconds())
+		return all, nil
+	}
+	return nil, dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface", []interface{}{iface})
+}
+
+func (p properties) Get(iface string, name string) (dbus.Variant, *dbus.Error) {
+	all, err := p.all(iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	value, ok := all[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []interface{}{name})
+	}
+	return value, nil
+}
+
+func (p properties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	return p.all(iface)
+}
+
+func (p properties) Set(iface string, name string, value dbus.Variant) *dbus.Error {
+	if iface != PlayerInterface || (name != "Rate" && name != "Volume") {
+		return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly", []interface{}{name})
+	}
+	number, ok := value.Value().(float64)
+	if !ok {
+		return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{name})
+	}
+	if name == "Volume" {
+		p.s.panel.SetVolume(number)
+	} else if number > 0 {
+		// The specification forbids the rate 0, clamped by the player.
+		p.s.panel.SetSpeed(number)
+	}
+	return nil
+}
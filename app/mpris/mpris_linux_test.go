@@ -0,0 +1,221 @@
+package mpris
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/test"
+	"github.com/funte/xmlymft/common"
+	"github.com/godbus/dbus/v5"
+
+	"xmlymft-fyne-gui/app/player"
+)
+
+// Start a private session bus, returns its address.
+func startBus(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Skipf("dbus-daemon not started: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read bus address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+func connect(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Write an mp3 file of silent frames lasting about ten seconds.
+func writeSilentMP3(t *testing.T, path string) {
+	t.Helper()
+	// MPEG-1 layer III, 128 kbps, 44.1 kHz.
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x64})
+	if err := os.WriteFile(path, bytes.Repeat(frame, 400), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Create a panel playing silent tracks to a null sink.
+func newTestPanel(t *testing.T) *player.Panel {
+	t.Helper()
+	test.NewApp()
+	path := filepath.Join(t.TempDir(), "silence.mp3")
+	writeSilentMP3(t, path)
+
+	queue, _ := player.LoadQueue("")
+	positions, _ := player.LoadPositions("")
+	preferences, _ := player.LoadPreferences("")
+	panel := player.NewPanel(
+		test.NewWindow(nil), player.NewPlayer(&player.NullSink{Realtime: true}),
+		queue, positions, preferences,
+		func(item player.Item) (player.Source, error) {
+			return player.Source{Path: path, Type: "mp3"}, nil
+		},
+	)
+	t.Cleanup(func() { panel.Close() })
+	return panel
+}
+
+func testItems(count int) []player.Item {
+	album := common.AlbumInfo{Id: 1, Title: "专辑", Author: "作者"}
+	items := make([]player.Item, count)
+	for i := range items {
+		items[i] = player.Item{
+			Album:    album,
+			Track:    common.TrackInfo{Id: i + 1, Name: fmt.Sprintf("第%d集", i+1), Duration: 10},
+			Position: i + 1,
+		}
+	}
+	return items
+}
+
+// Client of the service on the bus.
+type client struct {
+	t   *testing.T
+	obj dbus.BusObject
+}
+
+func (c client) get(name string) interface{} {
+	c.t.Helper()
+	value, err := c.obj.GetProperty(PlayerInterface + "." + name)
+	if err != nil {
+		c.t.Fatalf("get %s: %v", name, err)
+	}
+	return value.Value()
+}
+
+func (c client) call(method string) {
+	c.t.Helper()
+	if call := c.obj.Call(PlayerInterface+"."+method, 0); call.Err != nil {
+		c.t.Fatalf("call %s: %v", method, call.Err)
+	}
+}
+
+func (c client) trackId() dbus.ObjectPath {
+	c.t.Helper()
+	metadata := c.get("Metadata").(map[string]dbus.Variant)
+	return metadata["mpris:trackid"].Value().(dbus.ObjectPath)
+}
+
+// Wait until a property has the value.
+func (c client) waitFor(name string, want interface{}) {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		value := c.get(name)
+		if value == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("%s = %v, want %v", name, value, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (c client) waitForTrack(want dbus.ObjectPath) {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		trackId := c.trackId()
+		if trackId == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("track id = %s, want %s", trackId, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestService(t *testing.T) {
+	address := startBus(t)
+	panel := newTestPanel(t)
+	service, err := Export(connect(t, address), panel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	c := client{t, connect(t, address).Object(BusName, ObjectPath)}
+
+	if status := c.get("PlaybackStatus"); status != "Stopped" {
+		t.Errorf("status = %v with nothing loaded, want Stopped", status)
+	}
+	if trackId := c.trackId(); trackId != noTrack {
+		t.Errorf("track id = %s with nothing loaded, want %s", trackId, noTrack)
+	}
+
+	panel.PlayList(testItems(3), 0)
+	c.waitFor("PlaybackStatus", "Playing")
+	metadata := c.get("Metadata").(map[string]dbus.Variant)
+	for key, want := range map[string]interface{}{
+		"mpris:trackid":     dbus.ObjectPath("/org/mpris/MediaPlayer2/track/1"),
+		"xesam:title":       "第1集",
+		"xesam:album":       "专辑",
+		"xesam:trackNumber": int32(1),
+	} {
+		if value := metadata[key].Value(); value != want {
+			t.Errorf("metadata %s = %v, want %v", key, value, want)
+		}
+	}
+	if artist, _ := metadata["xesam:artist"].Value().([]string); len(artist) != 1 || artist[0] != "作者" {
+		t.Errorf("metadata xesam:artist = %v, want [作者]", metadata["xesam:artist"])
+	}
+	if length, _ := metadata["mpris:length"].Value().(int64); length <= 0 {
+		t.Errorf("metadata mpris:length = %v, want positive", metadata["mpris:length"])
+	}
+
+	c.call("PlayPause")
+	c.waitFor("PlaybackStatus", "Paused")
+	// The position is where the player paused, after the chunk being written.
+	time.Sleep(100 * time.Millisecond)
+	position, _ := panel.Player().Position()
+	if value := c.get("Position"); value != position.Microseconds() {
+		t.Errorf("position = %v, want %d", value, position.Microseconds())
+	}
+	panel.Seek(2 * time.Second)
+	if value := c.get("Position"); value != (2 * time.Second).Microseconds() {
+		t.Errorf("position = %v after seeking, want 2s", value)
+	}
+	c.call("PlayPause")
+	c.waitFor("PlaybackStatus", "Playing")
+
+	c.call("Next")
+	c.waitForTrack("/org/mpris/MediaPlayer2/track/2")
+	c.waitFor("PlaybackStatus", "Playing")
+	if canGoPrevious := c.get("CanGoPrevious"); canGoPrevious != true {
+		t.Error("cannot go previous from the second track")
+	}
+	c.call("Previous")
+	c.waitForTrack("/org/mpris/MediaPlayer2/track/1")
+	c.waitFor("PlaybackStatus", "Playing")
+}
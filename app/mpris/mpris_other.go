@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package mpris
+
+import (
+	"xmlymft-fyne-gui/app/player"
+)
+
+// Service of the MPRIS media player, only on Linux.
+type Service struct{}
+
+// Start does nothing, MPRIS is only on Linux.
+func Start(panel *player.Panel) (*Service, error) {
+	return nil, nil
+}
+
+func (s *Service) Update() {}
+
+func (s *Service) Close() error {
+	return nil
+}
@@ -0,0 +1,30 @@
+package mytheme
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+)
+
+// Material design star icons, the default theme has none.
+var starSVG = &fyne.StaticResource{
+	StaticName: "star.svg",
+	StaticContent: []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="24" height="24" viewBox="0 0 24 24">` +
+		`<path d="M12 17.27L18.18 21l-1.64-7.03L22 9.24l-7.19-.61L12 2 9.19 8.63 2 9.24l5.46 4.73L5.82 21z"/></svg>`),
+}
+
+var starBorderSVG = &fyne.StaticResource{
+	StaticName: "star-border.svg",
+	StaticContent: []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="24" height="24" viewBox="0 0 24 24">` +
+		`<path d="M22 9.24l-7.19-.62L12 2 9.19 8.63 2 9.24l5.46 4.73L5.82 21 12 17.27 18.18 21l-1.63-7.03L22 9.24z` +
+		`M12 15.4l-3.76 2.27 1-4.28-3.32-2.88 4.38-.38L12 6.1l1.71 4.04 4.38.38-3.32 2.88 1 4.28L12 15.4z"/></svg>`),
+}
+
+// Icon of a favorite.
+func StarIcon() fyne.Resource {
+	return theme.NewThemedResource(starSVG)
+}
+
+// Icon to add to favorites.
+func StarBorderIcon() fyne.Resource {
+	return theme.NewThemedResource(starBorderSVG)
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"xmlymft-fyne-gui/app/download"
+	"xmlymft-fyne-gui/app/player"
+)
+
+// Returns the resolver of the track sources, a downloaded track is played
+// from its file, otherwise streamed through the cache proxy.
+func newTrackResolver(
+	downloader *download.Manager, proxy *download.CacheProxy,
+) func(item player.Item) (player.Source, error) {
+	return func(item player.Item) (player.Source, error) {
+		duration := time.Duration(item.Track.Duration) * time.Second
+		if record, ok := downloader.Downloaded(item.Track.Id); ok {
+			fileType := record.Type
+			if fileType == "" {
+				fileType = strings.TrimPrefix(filepath.Ext(record.Path), ".")
+			}
+			if !player.CanPlay(fileType) {
+				return player.Source{}, fmt.Errorf("%w: %s", player.ErrUnsupported, fileType)
+			}
+			return player.Source{
+				Path:     record.Path,
+				Type:     fileType,
+				Duration: duration,
+				ByteSize: record.Size,
+			}, nil
+		}
+		// Checked before opening, an unplayable track must not evict the
+		// track streaming.
+		address, err := downloader.TrackAddress(item.Track.Id)
+		if err != nil {
+			return player.Source{}, err
+		}
+		if !player.CanPlay(address.Type) {
+			return player.Source{}, fmt.Errorf("%w: %s", player.ErrUnsupported, address.Type)
+		}
+		if address, err = proxy.Open(item.Album, item.Track, item.Position, address); err != nil {
+			return player.Source{}, err
+		}
+		return player.Source{
+			URL:      address.Address,
+			Type:     address.Type,
+			Duration: duration,
+			ByteSize: int64(address.ByteSize),
+		}, nil
+	}
+}
@@ -0,0 +1,273 @@
+// Package aac decodes AAC LC audio, as in the m4a files.
+//
+// HE-AAC streams are decoded as their AAC LC core, at half the sample rate
+// without the spectral band replication, and parametric stereo is decoded as
+// mono. Only mono and stereo channel configurations are supported.
+package aac
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Samples of a channel in a frame.
+const FrameLength = 1024
+
+// Audio object types.
+const (
+	objectTypeLC   = 2
+	objectTypeSBR  = 5
+	objectTypePS   = 29
+	objectTypeEsc  = 31
+	explicitRateIx = 15
+)
+
+// Syntactic elements of a raw data block.
+const (
+	elementSCE = iota
+	elementCPE
+	elementCCE
+	elementLFE
+	elementDSE
+	elementPCE
+	elementFIL
+	elementEND
+)
+
+var ErrUnsupported = errors.New("aac: unsupported stream")
+
+// Config of a stream, from the AudioSpecificConfig of ISO/IEC 14496-3
+// 1.6.2.1.
+type Config struct {
+	// Audio object type of the core, 2 for AAC LC.
+	ObjectType int
+	// Sample rate of the core, the rate of the decoded samples.
+	SampleRate int
+	// 1 for mono or 2 for stereo.
+	Channels int
+
+	rateIndex int
+}
+
+// ParseConfig parse the AudioSpecificConfig of a stream.
+func ParseConfig(data []byte) (Config, error) {
+	r := &bitReader{data: data}
+	config := Config{}
+	objectType, err := readObjectType(r)
+	if err != nil {
+		return config, err
+	}
+	if config.rateIndex, config.SampleRate, err = readSampleRate(r); err != nil {
+		return config, err
+	}
+	channels, err := r.readBits(4)
+	if err != nil {
+		return config, err
+	}
+	config.Channels = int(channels)
+	if objectType == objectTypeSBR || objectType == objectTypePS {
+		// The extension sample rate, the core is decoded without the
+		// extension.
+		if _, _, err = readSampleRate(r); err != nil {
+			return config, err
+		}
+		if objectType, err = readObjectType(r); err != nil {
+			return config, err
+		}
+	}
+	config.ObjectType = objectType
+	if objectType != objectTypeLC {
+		return config, fmt.Errorf("%w: audio object type %d", ErrUnsupported, objectType)
+	}
+
+	// GASpecificConfig.
+	frameLengthFlag, err := r.readFlag()
+	if err != nil {
+		return config, err
+	}
+	if frameLengthFlag {
+		return config, fmt.Errorf("%w: 960 samples frames", ErrUnsupported)
+	}
+	dependsOnCoreCoder, err := r.readFlag()
+	if err != nil {
+		return config, err
+	}
+	if dependsOnCoreCoder {
+		if err = r.skipBits(14); err != nil {
+			return config, err
+		}
+	}
+	if config.Channels < 1 || config.Channels > 2 {
+		return config, fmt.Errorf("%w: channel configuration %d", ErrUnsupported, config.Channels)
+	}
+	return config, nil
+}
+
+func readObjectType(r *bitReader) (int, error) {
+	objectType, err := r.readBits(5)
+	if err != nil || objectType != objectTypeEsc {
+		return int(objectType), err
+	}
+	objectType, err = r.readBits(6)
+	return 32 + int(objectType), err
+}
+
+// Read a sampling frequency index or an explicit sample rate, returns the
+// index of the tables and the rate.
+func readSampleRate(r *bitReader) (int, int, error) {
+	index, err := r.readBits(4)
+	if err != nil {
+		return 0, 0, err
+	}
+	if index < uint32(len(sampleRates)) {
+		return int(index), sampleRates[index], nil
+	}
+	if index != explicitRateIx {
+		return 0, 0, fmt.Errorf("%w: sampling frequency index %d", ErrUnsupported, index)
+	}
+	rate, err := r.readBits(24)
+	if err != nil {
+		return 0, 0, err
+	}
+	if rate == 0 {
+		return 0, 0, fmt.Errorf("%w: sample rate 0", ErrUnsupported)
+	}
+	return rateIndexOf(int(rate)), int(rate), nil
+}
+
+// Index of the tables of an explicit sample rate, ISO/IEC 14496-3 table
+// 4.82.
+func rateIndexOf(rate int) int {
+	thresholds := []int{92017, 75132, 55426, 46009, 37566, 27713, 23004, 18783, 13856, 11502, 9391}
+	for i, threshold := range thresholds {
+		if rate >= threshold {
+			return i
+		}
+	}
+	return len(thresholds)
+}
+
+// Decoder of the raw data blocks of a stream.
+type Decoder struct {
+	config   Config
+	channels []*channelStream
+	dropped  *channelStream
+	// Decoded samples of each channel.
+	samples [][]float32
+}
+
+func NewDecoder(config Config) (*Decoder, error) {
+	if config.ObjectType != objectTypeLC || config.Channels < 1 || config.Channels > 2 {
+		return nil, fmt.Errorf("%w: %+v", ErrUnsupported, config)
+	}
+	d := &Decoder{config: config, dropped: newChannelStream(config.rateIndex)}
+	for i := 0; i < config.Channels; i++ {
+		d.channels = append(d.channels, newChannelStream(config.rateIndex))
+		d.samples = append(d.samples, make([]float32, FrameLength))
+	}
+	return d, nil
+}
+
+func (d *Decoder) Config() Config {
+	return d.config
+}
+
+// Decode a raw data block into FrameLength samples of each channel, in the
+// range of [-1, 1]. The samples are overwritten by the next call.
+func (d *Decoder) Decode(frame []byte) ([][]float32, error) {
+	r := &bitReader{data: frame}
+	decoded := 0
+	for {
+		id, err := r.readBits(3)
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case elementSCE, elementLFE:
+			if _, err = r.readBits(4); err != nil {
+				return nil, err
+			}
+			// The LFE and the channels beyond the configuration are
+			// dropped.
+			if id == elementLFE || decoded >= len(d.channels) {
+				err = d.dropped.decode(r, nil)
+				break
+			}
+			err = d.channels[decoded].decode(r, nil)
+			decoded++
+		case elementCPE:
+			if _, err = r.readBits(4); err != nil {
+				return nil, err
+			}
+			if len(d.channels) != 2 || decoded != 0 {
+				return nil, fmt.Errorf("%w: channel pair in a mono stream", ErrUnsupported)
+			}
+			err = decodeChannelPair(r, d.channels[0], d.channels[1])
+			decoded += 2
+		case elementDSE:
+			err = skipDataStream(r)
+		case elementFIL:
+			err = skipFill(r)
+		case elementEND:
+			if decoded == 0 {
+				return nil, errors.New("aac: no channel in the frame")
+			}
+			for i := 0; i < decoded && i < len(d.channels); i++ {
+				d.channels[i].synthesize(d.samples[i])
+			}
+			// A mono frame of a stereo stream.
+			if decoded < len(d.channels) {
+				copy(d.samples[1], d.samples[0])
+			}
+			return d.samples, nil
+		default:
+			return nil, fmt.Errorf("%w: syntactic element %d", ErrUnsupported, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Reset the state between frames, after seeking.
+func (d *Decoder) Reset() {
+	for _, ch := range d.channels {
+		ch.filterbank.reset()
+	}
+}
+
+func skipDataStream(r *bitReader) error {
+	header, err := r.readBits(4 + 1 + 8)
+	if err != nil {
+		return err
+	}
+	align := header>>8&1 == 1
+	count := int(header & 0xff)
+	if count == 255 {
+		esc, err := r.readBits(8)
+		if err != nil {
+			return err
+		}
+		count += int(esc)
+	}
+	if align {
+		r.byteAlign()
+	}
+	return r.skipBits(count * 8)
+}
+
+// Skip a fill element, the extension payloads like SBR are not decoded.
+func skipFill(r *bitReader) error {
+	count, err := r.readBits(4)
+	if err != nil {
+		return err
+	}
+	if count == 15 {
+		esc, err := r.readBits(8)
+		if err != nil {
+			return err
+		}
+		count += esc - 1
+	}
+	return r.skipBits(int(count) * 8)
+}
@@ -0,0 +1,301 @@
+package aac
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestCodebooks(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		codes []uint32
+		bits  []uint8
+	}{
+		{"scalefactor", scalefactorCodes[:], scalefactorBits[:]},
+		{"1", codes1[:], bits1[:]}, {"2", codes2[:], bits2[:]}, {"3", codes3[:], bits3[:]},
+		{"4", codes4[:], bits4[:]}, {"5", codes5[:], bits5[:]}, {"6", codes6[:], bits6[:]},
+		{"7", codes7[:], bits7[:]}, {"8", codes8[:], bits8[:]}, {"9", codes9[:], bits9[:]},
+		{"10", codes10[:], bits10[:]}, {"11", codes11[:], bits11[:]},
+	} {
+		// A complete prefix code, the lengths fill the code space.
+		sum := 0.0
+		for i, code := range test.codes {
+			if code>>test.bits[i] != 0 {
+				t.Errorf("codebook %s: code %d 0x%x longer than %d bits", test.name, i, code, test.bits[i])
+			}
+			sum += math.Exp2(-float64(test.bits[i]))
+			for j, other := range test.codes {
+				if i != j && test.bits[i] <= test.bits[j] && other>>(test.bits[j]-test.bits[i]) == code {
+					t.Errorf("codebook %s: code %d prefix of code %d", test.name, i, j)
+				}
+			}
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("codebook %s: Kraft sum %v, want 1", test.name, sum)
+		}
+	}
+}
+
+func TestIMDCT(t *testing.T) {
+	for _, n := range []int{256, 2048} {
+		coefs := make([]float32, n/2)
+		for i := range coefs {
+			coefs[i] = float32(i*7919%113 - 56)
+		}
+		out := make([]float64, n)
+		newIMDCT(n).transform(coefs, out)
+		n0 := (float64(n)/2 + 1) / 2
+		for i := range out {
+			want := 0.0
+			for k, coef := range coefs {
+				want += float64(coef) * math.Cos(2*math.Pi/float64(n)*(float64(i)+n0)*(float64(k)+0.5))
+			}
+			want *= 2 / float64(n)
+			if math.Abs(out[i]-want) > 1e-9 {
+				t.Fatalf("imdct %d: out[%d] = %v, want %v", n, i, out[i], want)
+			}
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, test := range []struct {
+		asc    []byte
+		config Config
+		err    error
+	}{
+		{[]byte{0x12, 0x10}, Config{ObjectType: 2, SampleRate: 44100, Channels: 2}, nil},
+		// HE-AAC of a 24000 Hz core.
+		{[]byte{0x2b, 0x11, 0x88, 0x00}, Config{ObjectType: 2, SampleRate: 24000, Channels: 2}, nil},
+		// Explicit sample rate.
+		{[]byte{0x17, 0x80, 0x2b, 0x11, 0x08}, Config{ObjectType: 2, SampleRate: 22050, Channels: 1}, nil},
+		// AAC Main.
+		{[]byte{0x0a, 0x08}, Config{}, ErrUnsupported},
+		// 960 samples frames.
+		{[]byte{0x12, 0x0c}, Config{}, ErrUnsupported},
+		// 5.1 channels.
+		{[]byte{0x12, 0x30}, Config{}, ErrUnsupported},
+		{[]byte{0x12}, Config{}, errTruncated},
+	} {
+		config, err := ParseConfig(test.asc)
+		name := fmt.Sprintf("% x", test.asc)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if config.ObjectType != test.config.ObjectType || config.SampleRate != test.config.SampleRate || config.Channels != test.config.Channels {
+			t.Errorf("%s: %+v, want %+v", name, config, test.config)
+		}
+	}
+}
+
+// Writes bits most significant bit first.
+type bitWriter struct {
+	data []byte
+	pos  int
+}
+
+func (w *bitWriter) writeBits(value uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if w.pos%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[w.pos/8] |= byte(value>>uint(i)&1) << (7 - uint(w.pos%8))
+		w.pos++
+	}
+}
+
+// Global gain and scale factor of all the bands of the test encoder, a step
+// of 2^((128-100)/4).
+const testScalefactor = 128
+
+// MDCT of 2048 samples of the sine window, scaled by 2 as the encoder of
+// ISO/IEC 14496-3 4.6.18 to the synthesis.
+func testMDCT(samples []float64) []float64 {
+	window := longWindows[0]
+	spec := make([]float64, FrameLength)
+	n0 := (2048.0/2 + 1) / 2
+	for k := range spec {
+		sum := 0.0
+		for i, sample := range samples {
+			w := window[minInt(i, 2047-i)]
+			sum += sample * w * math.Cos(2*math.Pi/2048*(float64(i)+n0)*(float64(k)+0.5))
+		}
+		spec[k] = 2 * sum
+	}
+	return spec
+}
+
+// Write an individual_channel_stream of a long window, all the bands coded
+// by codebook 11, the ics_info if info.
+func writeTestICS(w *bitWriter, spec []float64, info bool) {
+	w.writeBits(testScalefactor, 8)
+	if info {
+		// Only long sequence, sine window, max_sfb 49 and no prediction.
+		w.writeBits(0, 1+2+1)
+		w.writeBits(49, 6)
+		w.writeBits(0, 1)
+	}
+	// One section of codebook 11 of the 49 bands, 31 is the escape of the
+	// length.
+	w.writeBits(11, 4)
+	w.writeBits(31, 5)
+	w.writeBits(49-31, 5)
+	for sfb := 0; sfb < 49; sfb++ {
+		w.writeBits(scalefactorCodes[60], uint(scalefactorBits[60]))
+	}
+	// No pulse, TNS or gain control.
+	w.writeBits(0, 3)
+
+	gain := math.Exp2(0.25 * (testScalefactor - 100))
+	quantized := make([]int, len(spec))
+	for k, value := range spec {
+		q := int(math.Round(math.Pow(math.Abs(value)/gain, 0.75)))
+		if value < 0 {
+			q = -q
+		}
+		quantized[k] = q
+	}
+	abs := func(v int) int {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	for k := 0; k < len(quantized); k += 2 {
+		pair := quantized[k : k+2]
+		symbol := minInt(abs(pair[0]), 16)*17 + minInt(abs(pair[1]), 16)
+		w.writeBits(codes11[symbol], uint(bits11[symbol]))
+		for _, v := range pair {
+			if v < 0 {
+				w.writeBits(1, 1)
+			} else if v > 0 {
+				w.writeBits(0, 1)
+			}
+		}
+		for _, v := range pair {
+			if abs(v) < 16 {
+				continue
+			}
+			n := uint(math.Floor(math.Log2(float64(abs(v)))))
+			// n-4 ones and a zero.
+			w.writeBits((1<<(n-4)-1)<<1, n-4+1)
+			w.writeBits(uint32(abs(v)-1<<n), n)
+		}
+	}
+}
+
+// Samples of a sine of the frequency and the amplitude at 44100 Hz, in the
+// range of the 16-bit samples.
+func testSine(count int, frequency float64, amplitude float64) []float64 {
+	samples := make([]float64, count)
+	for i := range samples {
+		samples[i] = amplitude * 32768 * math.Sin(2*math.Pi*frequency*float64(i)/44100)
+	}
+	return samples
+}
+
+// Frame t of the encoder covers the samples from (t-1)*1024, those before
+// the start are silent.
+func testFrameSamples(samples []float64, t int) []float64 {
+	block := make([]float64, 2048)
+	for i := range block {
+		if j := (t-1)*FrameLength + i; j >= 0 && j < len(samples) {
+			block[i] = samples[j]
+		}
+	}
+	return block
+}
+
+// Compare frame t of decoded samples with the input, the output of frame t
+// is the input from (t-1)*1024.
+func checkTestFrame(t *testing.T, name string, frame int, decoded []float32, samples []float64) {
+	t.Helper()
+	for i, sample := range decoded {
+		want := samples[(frame-1)*FrameLength+i] / 32768
+		if math.Abs(float64(sample)-want) > 1e-3 {
+			t.Fatalf("%s: frame %d sample %d = %v, want %v", name, frame, i, sample, want)
+		}
+	}
+}
+
+func TestDecodeMono(t *testing.T) {
+	config, err := ParseConfig([]byte{0x12, 0x08})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const frames = 6
+	samples := testSine(frames*FrameLength, 1000, 0.5)
+	for frame := 0; frame < frames; frame++ {
+		w := &bitWriter{}
+		// A single channel element and the end.
+		w.writeBits(elementSCE, 3)
+		w.writeBits(0, 4)
+		writeTestICS(w, testMDCT(testFrameSamples(samples, frame)), true)
+		w.writeBits(elementEND, 3)
+
+		decoded, err := d.Decode(w.data)
+		if err != nil {
+			t.Fatalf("frame %d: %v", frame, err)
+		}
+		if frame > 0 {
+			checkTestFrame(t, "mono", frame, decoded[0], samples)
+		}
+	}
+
+	// Truncated frame.
+	if _, err := d.Decode([]byte{0x00, 0xc8}); err == nil {
+		t.Error("truncated frame decoded")
+	}
+}
+
+func TestDecodeMidSide(t *testing.T) {
+	config, err := ParseConfig([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const frames = 4
+	left := testSine(frames*FrameLength, 440, 0.3)
+	right := testSine(frames*FrameLength, 3000, 0.2)
+	mid, side := make([]float64, len(left)), make([]float64, len(left))
+	for i := range left {
+		mid[i], side[i] = (left[i]+right[i])/2, (left[i]-right[i])/2
+	}
+	for frame := 0; frame < frames; frame++ {
+		w := &bitWriter{}
+		// A channel pair element of a common window, all the bands of
+		// mid and side.
+		w.writeBits(elementCPE, 3)
+		w.writeBits(0, 4)
+		w.writeBits(1, 1)
+		w.writeBits(0, 1+2+1)
+		w.writeBits(49, 6)
+		w.writeBits(0, 1)
+		w.writeBits(2, 2)
+		writeTestICS(w, testMDCT(testFrameSamples(mid, frame)), false)
+		writeTestICS(w, testMDCT(testFrameSamples(side, frame)), false)
+		w.writeBits(elementEND, 3)
+
+		decoded, err := d.Decode(w.data)
+		if err != nil {
+			t.Fatalf("frame %d: %v", frame, err)
+		}
+		if frame > 0 {
+			checkTestFrame(t, "left", frame, decoded[0], left)
+			checkTestFrame(t, "right", frame, decoded[1], right)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package aac
+
+import "errors"
+
+var errTruncated = errors.New("aac: truncated frame")
+
+// Reads the bits of a frame, most significant bit first.
+type bitReader struct {
+	data []byte
+	// Position in bits.
+	pos int
+}
+
+func (r *bitReader) readBit() (uint32, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errTruncated
+	}
+	bit := uint32(r.data[r.pos>>3]>>(7-uint(r.pos&7))) & 1
+	r.pos++
+	return bit, nil
+}
+
+// Read n bits, n is at most 32.
+func (r *bitReader) readBits(n uint) (uint32, error) {
+	if r.pos+int(n) > len(r.data)*8 {
+		return 0, errTruncated
+	}
+	value := uint32(0)
+	for n > 0 {
+		// Bits left in the current byte.
+		left := 8 - uint(r.pos&7)
+		take := left
+		if take > n {
+			take = n
+		}
+		bits := uint32(r.data[r.pos>>3]>>(left-take)) & (1<<take - 1)
+		value = value<<take | bits
+		r.pos += int(take)
+		n -= take
+	}
+	return value, nil
+}
+
+func (r *bitReader) readFlag() (bool, error) {
+	bit, err := r.readBit()
+	return bit == 1, err
+}
+
+func (r *bitReader) skipBits(n int) error {
+	if r.pos+n > len(r.data)*8 {
+		return errTruncated
+	}
+	r.pos += n
+	return nil
+}
+
+func (r *bitReader) byteAlign() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+// Bits left.
+func (r *bitReader) left() int {
+	return len(r.data)*8 - r.pos
+}
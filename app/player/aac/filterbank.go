@@ -0,0 +1,240 @@
+package aac
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Window sequences.
+const (
+	onlyLongSequence = iota
+	longStartSequence
+	eightShortSequence
+	longStopSequence
+)
+
+// Rising halves of the windows, indexed by the window shape, 0 for sine and
+// 1 for Kaiser-Bessel derived.
+var (
+	longWindows  = [2][]float64{sineWindow(2048), kbdWindow(2048, 4)}
+	shortWindows = [2][]float64{sineWindow(256), kbdWindow(256, 6)}
+)
+
+// Rising half of the sine window of length n.
+func sineWindow(n int) []float64 {
+	w := make([]float64, n/2)
+	for i := range w {
+		w[i] = math.Sin(math.Pi / float64(n) * (float64(i) + 0.5))
+	}
+	return w
+}
+
+// Rising half of the Kaiser-Bessel derived window of length n.
+func kbdWindow(n int, alpha float64) []float64 {
+	kaiser := make([]float64, n/2+1)
+	for i := range kaiser {
+		x := float64(i-n/4) / float64(n/4)
+		kaiser[i] = besselI0(math.Pi * alpha * math.Sqrt(1-x*x))
+	}
+	total := 0.0
+	for _, k := range kaiser {
+		total += k
+	}
+	w := make([]float64, n/2)
+	sum := 0.0
+	for i := range w {
+		sum += kaiser[i]
+		w[i] = math.Sqrt(sum / total)
+	}
+	return w
+}
+
+// Modified Bessel function of the first kind of order 0.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / 2 / float64(k)) * (x / 2 / float64(k))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+// Inverse MDCT of n outputs from n/2 coefficients, computed by a DCT-IV of
+// n/2 points through a complex FFT of n/8 points.
+type imdct struct {
+	n int
+	// Pre and post twiddles of the DCT-IV.
+	twiddles []complex128
+	fft      *fft
+	buf      []complex128
+	dct      []float64
+}
+
+func newIMDCT(n int) *imdct {
+	m := n / 2
+	t := &imdct{
+		n:        n,
+		twiddles: make([]complex128, m/2),
+		fft:      newFFT(m / 2),
+		buf:      make([]complex128, m/2),
+		dct:      make([]float64, m),
+	}
+	for i := range t.twiddles {
+		t.twiddles[i] = cmplx.Exp(complex(0, -math.Pi*(float64(i)+0.125)/float64(m)))
+	}
+	return t
+}
+
+// Transform the coefficients into out of length n, scaled by 2/n as the
+// synthesis of ISO/IEC 14496-3 4.6.18.
+func (t *imdct) transform(coefs []float32, out []float64) {
+	m := t.n / 2
+	for i := 0; i < m/2; i++ {
+		t.buf[i] = complex(float64(coefs[2*i]), float64(coefs[m-1-2*i])) * t.twiddles[i]
+	}
+	t.fft.transform(t.buf)
+	for i := 0; i < m/2; i++ {
+		c := t.buf[i] * t.twiddles[i]
+		t.dct[2*i] = real(c)
+		t.dct[m-1-2*i] = -imag(c)
+	}
+	// The outputs are the DCT-IV extended with its symmetries, shifted by
+	// a quarter.
+	scale := 2 / float64(t.n)
+	for i := 0; i < m/2; i++ {
+		out[i] = t.dct[m/2+i] * scale
+	}
+	for i := m / 2; i < 3*m/2; i++ {
+		out[i] = -t.dct[3*m/2-1-i] * scale
+	}
+	for i := 3 * m / 2; i < 2*m; i++ {
+		out[i] = -t.dct[i-3*m/2] * scale
+	}
+}
+
+// Radix-2 complex FFT.
+type fft struct {
+	n        int
+	twiddles []complex128
+	reversed []int
+}
+
+func newFFT(n int) *fft {
+	f := &fft{n: n, twiddles: make([]complex128, n/2), reversed: make([]int, n)}
+	for i := range f.twiddles {
+		f.twiddles[i] = cmplx.Exp(complex(0, -2*math.Pi*float64(i)/float64(n)))
+	}
+	bits := 0
+	for 1<<bits < n {
+		bits++
+	}
+	for i := range f.reversed {
+		r := 0
+		for b := 0; b < bits; b++ {
+			r |= (i >> b & 1) << (bits - 1 - b)
+		}
+		f.reversed[i] = r
+	}
+	return f
+}
+
+func (f *fft) transform(x []complex128) {
+	for i, r := range f.reversed {
+		if i < r {
+			x[i], x[r] = x[r], x[i]
+		}
+	}
+	for size := 2; size <= f.n; size <<= 1 {
+		half, step := size/2, f.n/size
+		for start := 0; start < f.n; start += size {
+			for k := 0; k < half; k++ {
+				t := x[start+k+half] * f.twiddles[k*step]
+				x[start+k+half] = x[start+k] - t
+				x[start+k] += t
+			}
+		}
+	}
+}
+
+// Synthesis state of a channel.
+type filterbank struct {
+	// Second half of the last windowed output.
+	overlap [1024]float64
+	// Window shape of the last frame.
+	lastShape int
+
+	long, short *imdct
+	buf         [2048]float64
+	shortBuf    [256]float64
+}
+
+func newFilterbank() *filterbank {
+	return &filterbank{long: newIMDCT(2048), short: newIMDCT(256)}
+}
+
+// Transform the spectrum of a frame into 1024 samples of out, overlapped
+// with the last frame.
+func (f *filterbank) synthesize(spec []float32, sequence int, shape int, out []float32) {
+	buf := f.buf[:]
+	longLast, longCur := longWindows[f.lastShape], longWindows[shape]
+	shortLast, shortCur := shortWindows[f.lastShape], shortWindows[shape]
+
+	switch sequence {
+	case eightShortSequence:
+		for i := range buf {
+			buf[i] = 0
+		}
+		for w := 0; w < 8; w++ {
+			f.short.transform(spec[w*128:(w+1)*128], f.shortBuf[:])
+			rising := shortCur
+			if w == 0 {
+				rising = shortLast
+			}
+			start := 448 + w*128
+			for i := 0; i < 128; i++ {
+				buf[start+i] += f.shortBuf[i] * rising[i]
+				buf[start+128+i] += f.shortBuf[128+i] * shortCur[127-i]
+			}
+		}
+	default:
+		f.long.transform(spec, buf)
+		if sequence == longStopSequence {
+			for i := 0; i < 448; i++ {
+				buf[i] = 0
+			}
+			for i := 0; i < 128; i++ {
+				buf[448+i] *= shortLast[i]
+			}
+		} else {
+			for i := 0; i < 1024; i++ {
+				buf[i] *= longLast[i]
+			}
+		}
+		if sequence == longStartSequence {
+			for i := 0; i < 128; i++ {
+				buf[1472+i] *= shortCur[127-i]
+			}
+			for i := 1600; i < 2048; i++ {
+				buf[i] = 0
+			}
+		} else {
+			for i := 0; i < 1024; i++ {
+				buf[1024+i] *= longCur[1023-i]
+			}
+		}
+	}
+
+	for i := 0; i < 1024; i++ {
+		out[i] = float32(buf[i] + f.overlap[i])
+		f.overlap[i] = buf[1024+i]
+	}
+	f.lastShape = shape
+}
+
+func (f *filterbank) reset() {
+	f.overlap = [1024]float64{}
+	f.lastShape = 0
+}
@@ -0,0 +1,628 @@
+package aac
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Codebooks of the scale factor bands beyond the spectral codebooks.
+const (
+	zeroCodebook       = 0
+	noiseCodebook      = 13
+	intensityCodebook2 = 14
+	intensityCodebook  = 15
+)
+
+// Largest TNS filter order of AAC LC.
+const (
+	tnsMaxOrderLong  = 12
+	tnsMaxOrderShort = 7
+)
+
+var errInvalid = errors.New("aac: invalid frame")
+
+// |q|^(4/3) of the quantized values.
+var pow43 = func() []float32 {
+	table := make([]float32, 8192)
+	for i := range table {
+		table[i] = float32(math.Pow(float64(i), 4.0/3))
+	}
+	return table
+}()
+
+// The ics_info of ISO/IEC 14496-3 4.4.2.1.
+type icsInfo struct {
+	windowSequence int
+	windowShape    int
+	maxSfb         int
+	// Lengths of the window groups, one group of a long window.
+	groups []int
+	// Scale factor band offsets of the windows.
+	swbOffset []int
+	// Samples of a window.
+	windowLength int
+}
+
+func (info *icsInfo) numWindows() int {
+	if info.windowSequence == eightShortSequence {
+		return 8
+	}
+	return 1
+}
+
+func (info *icsInfo) numSwb() int {
+	return len(info.swbOffset) - 1
+}
+
+func readICSInfo(r *bitReader, rateIndex int, info *icsInfo) error {
+	header, err := r.readBits(1 + 2 + 1)
+	if err != nil {
+		return err
+	}
+	info.windowSequence = int(header >> 1 & 3)
+	info.windowShape = int(header & 1)
+	info.groups = info.groups[:0]
+	if info.windowSequence == eightShortSequence {
+		bits, err := r.readBits(4 + 7)
+		if err != nil {
+			return err
+		}
+		info.maxSfb = int(bits >> 7)
+		grouping := bits & 0x7f
+		info.groups = append(info.groups, 1)
+		for i := 6; i >= 0; i-- {
+			if grouping>>uint(i)&1 == 1 {
+				info.groups[len(info.groups)-1]++
+			} else {
+				info.groups = append(info.groups, 1)
+			}
+		}
+		info.swbOffset = swbOffsetsShort[rateIndex]
+		info.windowLength = 128
+	} else {
+		bits, err := r.readBits(6 + 1)
+		if err != nil {
+			return err
+		}
+		info.maxSfb = int(bits >> 1)
+		if bits&1 == 1 {
+			return fmt.Errorf("%w: prediction", ErrUnsupported)
+		}
+		info.groups = append(info.groups, 1)
+		info.swbOffset = swbOffsetsLong[rateIndex]
+		info.windowLength = 1024
+	}
+	if info.maxSfb > info.numSwb() {
+		return fmt.Errorf("%w: max_sfb %d", errInvalid, info.maxSfb)
+	}
+	return nil
+}
+
+// A TNS filter.
+type tnsFilter struct {
+	length    int
+	order     int
+	direction bool
+	// Coefficients of the all-pole filter, lpc[0] is 1.
+	lpc [tnsMaxOrderLong + 1]float64
+}
+
+// A channel of a single channel element or a channel pair element.
+type channelStream struct {
+	rateIndex int
+	info      icsInfo
+
+	// Codebooks and scale factors of the bands by window group, the
+	// scale factors are intensity positions and noise energies of the
+	// intensity and noise bands.
+	codebooks    [8][64]int
+	scalefactors [8][64]int
+
+	// TNS filters of the windows.
+	tnsFilters [8][]tnsFilter
+
+	quantized [FrameLength]int32
+	spec      [FrameLength]float32
+
+	filterbank *filterbank
+	random     uint32
+}
+
+func newChannelStream(rateIndex int) *channelStream {
+	return &channelStream{
+		rateIndex:  rateIndex,
+		filterbank: newFilterbank(),
+		random:     0x1f2e3d4c,
+	}
+}
+
+// Decode an individual_channel_stream and dequantize the spectrum, info is
+// the common ics_info of a channel pair or nil.
+func (ch *channelStream) decode(r *bitReader, info *icsInfo) error {
+	globalGain, err := r.readBits(8)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		ch.info = *info
+	} else if err = readICSInfo(r, ch.rateIndex, &ch.info); err != nil {
+		return err
+	}
+	if err = ch.readSections(r); err != nil {
+		return err
+	}
+	if err = ch.readScalefactors(r, int(globalGain)); err != nil {
+		return err
+	}
+
+	type pulse struct {
+		offset int
+		amp    int32
+	}
+	pulses := []pulse{}
+	pulsePresent, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+	if pulsePresent {
+		if ch.info.windowSequence == eightShortSequence {
+			return fmt.Errorf("%w: pulses in short windows", errInvalid)
+		}
+		header, err := r.readBits(2 + 6)
+		if err != nil {
+			return err
+		}
+		count, startSfb := int(header>>6)+1, int(header&0x3f)
+		if startSfb > ch.info.numSwb() {
+			return fmt.Errorf("%w: pulse start band %d", errInvalid, startSfb)
+		}
+		offset := ch.info.swbOffset[startSfb]
+		for i := 0; i < count; i++ {
+			bits, err := r.readBits(5 + 4)
+			if err != nil {
+				return err
+			}
+			offset += int(bits >> 4)
+			if offset >= FrameLength {
+				return fmt.Errorf("%w: pulse offset %d", errInvalid, offset)
+			}
+			pulses = append(pulses, pulse{offset, int32(bits & 0xf)})
+		}
+	}
+
+	tnsPresent, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+	for w := range ch.tnsFilters {
+		ch.tnsFilters[w] = ch.tnsFilters[w][:0]
+	}
+	if tnsPresent {
+		if err = ch.readTNS(r); err != nil {
+			return err
+		}
+	}
+
+	gainControl, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+	if gainControl {
+		return fmt.Errorf("%w: gain control", ErrUnsupported)
+	}
+
+	if err = ch.readSpectrum(r); err != nil {
+		return err
+	}
+	for _, p := range pulses {
+		if ch.quantized[p.offset] > 0 {
+			ch.quantized[p.offset] += p.amp
+		} else {
+			ch.quantized[p.offset] -= p.amp
+		}
+	}
+	return ch.dequantize()
+}
+
+// Read the section_data, the codebooks of the bands.
+func (ch *channelStream) readSections(r *bitReader) error {
+	lengthBits := uint(5)
+	if ch.info.windowSequence == eightShortSequence {
+		lengthBits = 3
+	}
+	escape := uint32(1)<<lengthBits - 1
+	for g := range ch.info.groups {
+		for sfb := 0; sfb < ch.info.maxSfb; {
+			codebook, err := r.readBits(4)
+			if err != nil {
+				return err
+			}
+			if codebook == 12 {
+				return fmt.Errorf("%w: reserved codebook", errInvalid)
+			}
+			length := 0
+			for {
+				incr, err := r.readBits(lengthBits)
+				if err != nil {
+					return err
+				}
+				length += int(incr)
+				if incr != escape {
+					break
+				}
+			}
+			if sfb+length > ch.info.maxSfb {
+				return fmt.Errorf("%w: section length %d", errInvalid, length)
+			}
+			for end := sfb + length; sfb < end; sfb++ {
+				ch.codebooks[g][sfb] = int(codebook)
+			}
+		}
+	}
+	return nil
+}
+
+// Read the scale_factor_data.
+func (ch *channelStream) readScalefactors(r *bitReader, globalGain int) error {
+	scalefactor, position, energy := globalGain, 0, globalGain-90
+	noiseFirst := true
+	for g := range ch.info.groups {
+		for sfb := 0; sfb < ch.info.maxSfb; sfb++ {
+			switch ch.codebooks[g][sfb] {
+			case zeroCodebook:
+				ch.scalefactors[g][sfb] = 0
+			case intensityCodebook, intensityCodebook2:
+				delta, err := scalefactorCodebook.decode(r)
+				if err != nil {
+					return err
+				}
+				position += delta - 60
+				ch.scalefactors[g][sfb] = position
+			case noiseCodebook:
+				if noiseFirst {
+					bits, err := r.readBits(9)
+					if err != nil {
+						return err
+					}
+					energy += int(bits) - 256
+					noiseFirst = false
+				} else {
+					delta, err := scalefactorCodebook.decode(r)
+					if err != nil {
+						return err
+					}
+					energy += delta - 60
+				}
+				ch.scalefactors[g][sfb] = energy
+			default:
+				delta, err := scalefactorCodebook.decode(r)
+				if err != nil {
+					return err
+				}
+				scalefactor += delta - 60
+				if scalefactor < 0 || scalefactor > 255 {
+					return fmt.Errorf("%w: scale factor %d", errInvalid, scalefactor)
+				}
+				ch.scalefactors[g][sfb] = scalefactor
+			}
+		}
+	}
+	return nil
+}
+
+// Read the tns_data.
+func (ch *channelStream) readTNS(r *bitReader) error {
+	filtersBits, lengthBits, orderBits, maxOrder := uint(2), uint(6), uint(5), tnsMaxOrderLong
+	if ch.info.windowSequence == eightShortSequence {
+		filtersBits, lengthBits, orderBits, maxOrder = 1, 4, 3, tnsMaxOrderShort
+	}
+	for w := 0; w < ch.info.numWindows(); w++ {
+		count, err := r.readBits(filtersBits)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			continue
+		}
+		coefRes, err := r.readBits(1)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < int(count); i++ {
+			filter := tnsFilter{}
+			bits, err := r.readBits(lengthBits + orderBits)
+			if err != nil {
+				return err
+			}
+			filter.length = int(bits >> orderBits)
+			filter.order = int(bits & (1<<orderBits - 1))
+			if filter.order > maxOrder {
+				return fmt.Errorf("%w: TNS order %d", errInvalid, filter.order)
+			}
+			if filter.order > 0 {
+				bits, err := r.readBits(2)
+				if err != nil {
+					return err
+				}
+				filter.direction = bits>>1 == 1
+				compress := bits & 1
+				coefBits := uint(coefRes + 3 - compress)
+				coefs := make([]float64, filter.order)
+				for j := range coefs {
+					coef, err := r.readBits(coefBits)
+					if err != nil {
+						return err
+					}
+					coefs[j] = tnsCoefficient(coef, coefBits, uint(coefRes+3))
+				}
+				filter.lpc = tnsLPC(coefs)
+			}
+			ch.tnsFilters[w] = append(ch.tnsFilters[w], filter)
+		}
+	}
+	return nil
+}
+
+// The reflection coefficient of a TNS coefficient of coefBits bits, at the
+// resolution of resBits bits.
+func tnsCoefficient(coef uint32, coefBits uint, resBits uint) float64 {
+	value := int(coef)
+	if coef>>(coefBits-1)&1 == 1 {
+		value -= 1 << coefBits
+	}
+	scale := (float64(int(1)<<(resBits-1)) - 0.5) / (math.Pi / 2)
+	if value < 0 {
+		scale = (float64(int(1)<<(resBits-1)) + 0.5) / (math.Pi / 2)
+	}
+	return math.Sin(float64(value) / scale)
+}
+
+// Convert the reflection coefficients to the coefficients of the filter.
+func tnsLPC(coefs []float64) [tnsMaxOrderLong + 1]float64 {
+	lpc := [tnsMaxOrderLong + 1]float64{1}
+	tmp := [tnsMaxOrderLong + 1]float64{}
+	for m := 1; m <= len(coefs); m++ {
+		for i := 1; i < m; i++ {
+			tmp[i] = lpc[i] + coefs[m-1]*lpc[m-i]
+		}
+		for i := 1; i < m; i++ {
+			lpc[i] = tmp[i]
+		}
+		lpc[m] = coefs[m-1]
+	}
+	return lpc
+}
+
+// Read the spectral_data into the quantized values.
+func (ch *channelStream) readSpectrum(r *bitReader) error {
+	ch.quantized = [FrameLength]int32{}
+	window := 0
+	for g, length := range ch.info.groups {
+		for sfb := 0; sfb < ch.info.maxSfb; sfb++ {
+			codebook := ch.codebooks[g][sfb]
+			if codebook == zeroCodebook || codebook >= noiseCodebook {
+				continue
+			}
+			book := spectralCodebooks[codebook]
+			start, end := ch.info.swbOffset[sfb], ch.info.swbOffset[sfb+1]
+			for w := window; w < window+length; w++ {
+				base := w * ch.info.windowLength
+				for k := base + start; k < base+end; k += book.dimension {
+					if err := book.decodeValues(r, ch.quantized[k:k+book.dimension]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		window += length
+	}
+	return nil
+}
+
+// Dequantize the spectrum and fill the noise bands.
+func (ch *channelStream) dequantize() error {
+	ch.spec = [FrameLength]float32{}
+	window := 0
+	for g, length := range ch.info.groups {
+		for sfb := 0; sfb < ch.info.maxSfb; sfb++ {
+			codebook := ch.codebooks[g][sfb]
+			start, end := ch.info.swbOffset[sfb], ch.info.swbOffset[sfb+1]
+			switch {
+			case codebook == zeroCodebook || codebook == intensityCodebook || codebook == intensityCodebook2:
+			case codebook == noiseCodebook:
+				for w := window; w < window+length; w++ {
+					base := w * ch.info.windowLength
+					ch.fillNoise(ch.spec[base+start:base+end], ch.scalefactors[g][sfb])
+				}
+			default:
+				gain := float32(math.Exp2(0.25 * float64(ch.scalefactors[g][sfb]-100)))
+				for w := window; w < window+length; w++ {
+					base := w * ch.info.windowLength
+					for k := base + start; k < base+end; k++ {
+						q := ch.quantized[k]
+						if q < 0 {
+							if -q >= int32(len(pow43)) {
+								return fmt.Errorf("%w: quantized value %d", errInvalid, q)
+							}
+							ch.spec[k] = -pow43[-q] * gain
+						} else {
+							if q >= int32(len(pow43)) {
+								return fmt.Errorf("%w: quantized value %d", errInvalid, q)
+							}
+							ch.spec[k] = pow43[q] * gain
+						}
+					}
+				}
+			}
+		}
+		window += length
+	}
+	return nil
+}
+
+// Fill a band with noise of the energy of perceptual noise substitution.
+func (ch *channelStream) fillNoise(band []float32, energy int) {
+	total := 0.0
+	for k := range band {
+		ch.random = ch.random*1664525 + 1013904223
+		band[k] = float32(int32(ch.random))
+		total += float64(band[k]) * float64(band[k])
+	}
+	if total == 0 {
+		return
+	}
+	if energy < -100 {
+		energy = -100
+	} else if energy > 155 {
+		energy = 155
+	}
+	scale := float32(math.Exp2(0.25*float64(energy)) / math.Sqrt(total))
+	for k := range band {
+		band[k] *= scale
+	}
+}
+
+// Apply the TNS filters to the spectrum.
+func (ch *channelStream) applyTNS() {
+	info := &ch.info
+	maxBands := tnsMaxBandsLong[ch.rateIndex]
+	if info.windowSequence == eightShortSequence {
+		maxBands = tnsMaxBandsShort[ch.rateIndex]
+	}
+	if maxBands > info.maxSfb {
+		maxBands = info.maxSfb
+	}
+	for w := 0; w < info.numWindows(); w++ {
+		spec := ch.spec[w*info.windowLength : (w+1)*info.windowLength]
+		bottom := info.numSwb()
+		for _, filter := range ch.tnsFilters[w] {
+			top := bottom
+			bottom = top - filter.length
+			if bottom < 0 {
+				bottom = 0
+			}
+			if filter.order == 0 {
+				continue
+			}
+			start := info.swbOffset[minInt(bottom, maxBands)]
+			end := info.swbOffset[minInt(top, maxBands)]
+			if end <= start {
+				continue
+			}
+			// All-pole filter upwards or downwards.
+			state := [tnsMaxOrderLong]float64{}
+			k, inc := start, 1
+			if filter.direction {
+				k, inc = end-1, -1
+			}
+			for n := start; n < end; n++ {
+				y := float64(spec[k])
+				for i := 0; i < filter.order; i++ {
+					y -= state[i] * filter.lpc[i+1]
+				}
+				copy(state[1:filter.order], state[:filter.order-1])
+				state[0] = y
+				spec[k] = float32(y)
+				k += inc
+			}
+		}
+	}
+}
+
+// Synthesize the decoded spectrum into samples.
+func (ch *channelStream) synthesize(out []float32) {
+	ch.applyTNS()
+	ch.filterbank.synthesize(ch.spec[:], ch.info.windowSequence, ch.info.windowShape, out)
+	for i := range out {
+		out[i] /= 32768
+	}
+}
+
+// Decode a channel_pair_element after the element instance tag.
+func decodeChannelPair(r *bitReader, left *channelStream, right *channelStream) error {
+	commonWindow, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+	var info *icsInfo
+	msMaskPresent := uint32(0)
+	msUsed := [8][64]bool{}
+	if commonWindow {
+		info = &icsInfo{}
+		if err = readICSInfo(r, left.rateIndex, info); err != nil {
+			return err
+		}
+		if msMaskPresent, err = r.readBits(2); err != nil {
+			return err
+		}
+		switch msMaskPresent {
+		case 1:
+			for g := range info.groups {
+				for sfb := 0; sfb < info.maxSfb; sfb++ {
+					if msUsed[g][sfb], err = r.readFlag(); err != nil {
+						return err
+					}
+				}
+			}
+		case 2:
+			for g := range info.groups {
+				for sfb := 0; sfb < info.maxSfb; sfb++ {
+					msUsed[g][sfb] = true
+				}
+			}
+		case 3:
+			return fmt.Errorf("%w: reserved ms_mask_present", errInvalid)
+		}
+	}
+	if err = left.decode(r, info); err != nil {
+		return err
+	}
+	if err = right.decode(r, info); err != nil {
+		return err
+	}
+	if !commonWindow {
+		return nil
+	}
+
+	window := 0
+	for g, length := range info.groups {
+		for sfb := 0; sfb < info.maxSfb; sfb++ {
+			start, end := info.swbOffset[sfb], info.swbOffset[sfb+1]
+			codebook := right.codebooks[g][sfb]
+			switch {
+			case codebook == intensityCodebook || codebook == intensityCodebook2:
+				scale := float32(math.Exp2(-0.25 * float64(right.scalefactors[g][sfb])))
+				if codebook == intensityCodebook2 {
+					scale = -scale
+				}
+				if msMaskPresent != 0 && msUsed[g][sfb] {
+					scale = -scale
+				}
+				for w := window; w < window+length; w++ {
+					base := w * info.windowLength
+					for k := base + start; k < base+end; k++ {
+						right.spec[k] = left.spec[k] * scale
+					}
+				}
+			case msUsed[g][sfb] && codebook < noiseCodebook && left.codebooks[g][sfb] < noiseCodebook:
+				for w := window; w < window+length; w++ {
+					base := w * info.windowLength
+					for k := base + start; k < base+end; k++ {
+						mid, side := left.spec[k], right.spec[k]
+						left.spec[k], right.spec[k] = mid+side, mid-side
+					}
+				}
+			}
+		}
+		window += length
+	}
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,175 @@
+package player
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+var ErrUnsupported = errors.New("暂不支持播放该格式的音频")
+
+// An audio to play, a local file or a stream.
+type Source struct {
+	// Local file path, streams the URL if empty.
+	Path string
+	URL  string
+	// File type like "mp3" or "m4a".
+	Type string
+	// Duration if known, used for streams.
+	Duration time.Duration
+	// File size if known, used to seek streams.
+	ByteSize int64
+}
+
+// Decoder decodes an audio into 16-bit little endian stereo PCM.
+type Decoder interface {
+	io.Reader
+	SampleRate() int
+	// Duration of the audio, 0 if unknown.
+	Duration() time.Duration
+	// Seek to a position from the start.
+	Seek(position time.Duration) error
+	Close() error
+}
+
+// CanPlay whether a file type like "mp3" can be decoded, mp3 and m4a are
+// supported.
+func CanPlay(fileType string) bool {
+	return strings.EqualFold(fileType, "mp3") || strings.EqualFold(fileType, "m4a")
+}
+
+// Open a decoder of the source.
+func openDecoder(source Source) (Decoder, error) {
+	if !CanPlay(source.Type) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, source.Type)
+	}
+	if strings.EqualFold(source.Type, "m4a") {
+		if source.Path != "" {
+			return openM4AFile(source.Path)
+		}
+		return openM4AStream(source)
+	}
+	if source.Path != "" {
+		return openMP3File(source.Path)
+	}
+	return openMP3Stream(source)
+}
+
+// mp3 file decoder, seeks exactly.
+type mp3FileDecoder struct {
+	file    *os.File
+	decoder *mp3.Decoder
+}
+
+func openMP3File(path string) (*mp3FileDecoder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := mp3.NewDecoder(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &mp3FileDecoder{file, decoder}, nil
+}
+
+func (d *mp3FileDecoder) Read(b []byte) (int, error) {
+	return d.decoder.Read(b)
+}
+
+func (d *mp3FileDecoder) SampleRate() int {
+	return d.decoder.SampleRate()
+}
+
+func (d *mp3FileDecoder) Duration() time.Duration {
+	if d.decoder.Length() < 0 {
+		return 0
+	}
+	return bytesToDuration(d.decoder.Length(), d.SampleRate())
+}
+
+func (d *mp3FileDecoder) Seek(position time.Duration) error {
+	_, err := d.decoder.Seek(durationToBytes(position, d.SampleRate()), io.SeekStart)
+	return err
+}
+
+func (d *mp3FileDecoder) Close() error {
+	return d.file.Close()
+}
+
+// mp3 stream decoder, seeks by requesting the estimated byte range.
+type mp3StreamDecoder struct {
+	source  Source
+	body    io.ReadCloser
+	decoder *mp3.Decoder
+}
+
+func openMP3Stream(source Source) (*mp3StreamDecoder, error) {
+	d := &mp3StreamDecoder{source: source}
+	if err := d.open(0); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Request the stream from the offset and decode it, the decoder syncs to
+// the next frame.
+func (d *mp3StreamDecoder) open(offset int64) error {
+	req, err := http.NewRequest(http.MethodGet, d.source.URL, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return fmt.Errorf("GET %s: %s", d.source.URL, resp.Status)
+	}
+	decoder, err := mp3.NewDecoder(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	if d.body != nil {
+		d.body.Close()
+	}
+	d.body, d.decoder = resp.Body, decoder
+	return nil
+}
+
+func (d *mp3StreamDecoder) Read(b []byte) (int, error) {
+	return d.decoder.Read(b)
+}
+
+func (d *mp3StreamDecoder) SampleRate() int {
+	return d.decoder.SampleRate()
+}
+
+func (d *mp3StreamDecoder) Duration() time.Duration {
+	return d.source.Duration
+}
+
+func (d *mp3StreamDecoder) Seek(position time.Duration) error {
+	if d.source.Duration <= 0 || d.source.ByteSize <= 0 {
+		return fmt.Errorf("%w: seek a stream of unknown size", ErrUnsupported)
+	}
+	// Assumes a constant bitrate.
+	offset := int64(float64(d.source.ByteSize) * float64(position) / float64(d.source.Duration))
+	return d.open(offset)
+}
+
+func (d *mp3StreamDecoder) Close() error {
+	return d.body.Close()
+}
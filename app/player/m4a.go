@@ -0,0 +1,583 @@
+package player
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"xmlymft-fyne-gui/app/player/aac"
+)
+
+var errInvalidM4A = errors.New("m4a: invalid file")
+
+// Largest moov box read, of hours of audio.
+const maxMoovSize = 64 << 20
+
+// The AAC track of an m4a file.
+type m4aTrack struct {
+	config aac.Config
+	// Offset and size of each frame.
+	offsets []int64
+	sizes   []uint32
+}
+
+// An mp4 box read into memory.
+type m4aBox struct {
+	typ  string
+	data []byte
+}
+
+// Parse a box header, left is the size from the header to the end of the
+// parent. Returns the type, the size and the header size of the box.
+func parseM4ABoxHeader(header []byte, left int64) (string, int64, int64, error) {
+	if len(header) < 8 {
+		return "", 0, 0, errInvalidM4A
+	}
+	typ := string(header[4:8])
+	size, headerSize := int64(binary.BigEndian.Uint32(header[0:4])), int64(8)
+	if size == 1 {
+		if len(header) < 16 {
+			return typ, 0, 0, errInvalidM4A
+		}
+		size, headerSize = int64(binary.BigEndian.Uint64(header[8:16])), 16
+	} else if size == 0 {
+		size = left
+	}
+	if size < headerSize || size > left {
+		return typ, 0, 0, fmt.Errorf("%w: box %q size %d", errInvalidM4A, typ, size)
+	}
+	return typ, size, headerSize, nil
+}
+
+// Parse the child boxes in data.
+func parseM4ABoxes(data []byte) ([]m4aBox, error) {
+	boxes := []m4aBox{}
+	for offset := 0; offset+8 <= len(data); {
+		typ, size, headerSize, err := parseM4ABoxHeader(data[offset:], int64(len(data)-offset))
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, m4aBox{typ, data[offset+int(headerSize) : offset+int(size)]})
+		offset += int(size)
+	}
+	return boxes, nil
+}
+
+// Find the box at the path of types in boxes, returns nil if not found.
+func findM4ABox(boxes []m4aBox, path ...string) []byte {
+	for _, box := range boxes {
+		if box.typ != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return box.data
+		}
+		children, err := parseM4ABoxes(box.data)
+		if err != nil {
+			return nil
+		}
+		return findM4ABox(children, path[1:]...)
+	}
+	return nil
+}
+
+// Read the AAC track of an m4a file of the size.
+func readM4ATrack(r io.ReaderAt, size int64) (*m4aTrack, error) {
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= size; {
+		n, err := r.ReadAt(header, offset)
+		if n < 8 {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		typ, boxSize, headerSize, err := parseM4ABoxHeader(header[:n], size-offset)
+		if err != nil {
+			return nil, err
+		}
+		if typ != "moov" {
+			offset += boxSize
+			continue
+		}
+		if boxSize > maxMoovSize {
+			return nil, fmt.Errorf("%w: moov box size %d", errInvalidM4A, boxSize)
+		}
+		moov := make([]byte, boxSize-headerSize)
+		if n, err := r.ReadAt(moov, offset+headerSize); n < len(moov) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return parseM4AMoov(moov)
+	}
+	return nil, fmt.Errorf("%w: no moov box", errInvalidM4A)
+}
+
+// Parse the first sound track in the moov box.
+func parseM4AMoov(moov []byte) (*m4aTrack, error) {
+	boxes, err := parseM4ABoxes(moov)
+	if err != nil {
+		return nil, err
+	}
+	for _, box := range boxes {
+		if box.typ != "trak" {
+			continue
+		}
+		trak, err := parseM4ABoxes(box.data)
+		if err != nil {
+			return nil, err
+		}
+		// The handler type follows the version, flags and pre_defined.
+		hdlr := findM4ABox(trak, "mdia", "hdlr")
+		if len(hdlr) < 12 || string(hdlr[8:12]) != "soun" {
+			continue
+		}
+		stbl := findM4ABox(trak, "mdia", "minf", "stbl")
+		if stbl == nil {
+			return nil, fmt.Errorf("%w: no stbl box", errInvalidM4A)
+		}
+		return parseM4ASampleTable(stbl)
+	}
+	return nil, fmt.Errorf("%w: no sound track", errInvalidM4A)
+}
+
+// Parse the sample description and the frame offsets in a stbl box.
+func parseM4ASampleTable(stbl []byte) (*m4aTrack, error) {
+	boxes, err := parseM4ABoxes(stbl)
+	if err != nil {
+		return nil, err
+	}
+	track := &m4aTrack{}
+
+	// The first sample entry after the version, flags and entry count.
+	stsd := findM4ABox(boxes, "stsd")
+	if len(stsd) < 8 {
+		return nil, fmt.Errorf("%w: no stsd box", errInvalidM4A)
+	}
+	entries, err := parseM4ABoxes(stsd[8:])
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: no sample entry", errInvalidM4A)
+	}
+	if entries[0].typ != "mp4a" {
+		return nil, fmt.Errorf("%w: codec %q", ErrUnsupported, entries[0].typ)
+	}
+	asc, err := parseM4ASampleEntry(entries[0].data)
+	if err != nil {
+		return nil, err
+	}
+	if track.config, err = aac.ParseConfig(asc); err != nil {
+		if errors.Is(err, aac.ErrUnsupported) {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+		}
+		return nil, err
+	}
+
+	// Frame sizes.
+	stsz := findM4ABox(boxes, "stsz")
+	if len(stsz) < 12 {
+		return nil, fmt.Errorf("%w: no stsz box", errInvalidM4A)
+	}
+	sampleSize := binary.BigEndian.Uint32(stsz[4:8])
+	count := int(binary.BigEndian.Uint32(stsz[8:12]))
+	if sampleSize == 0 && (count < 0 || len(stsz)-12 < count*4) {
+		return nil, fmt.Errorf("%w: stsz box", errInvalidM4A)
+	}
+	track.sizes = make([]uint32, 0, minInt(count, 1<<20))
+	for i := 0; i < count; i++ {
+		if sampleSize != 0 {
+			track.sizes = append(track.sizes, sampleSize)
+		} else {
+			track.sizes = append(track.sizes, binary.BigEndian.Uint32(stsz[12+i*4:]))
+		}
+	}
+
+	// Chunk offsets.
+	chunks := []int64{}
+	if stco := findM4ABox(boxes, "stco"); stco != nil {
+		if len(stco) < 8 || len(stco)-8 < int(binary.BigEndian.Uint32(stco[4:8]))*4 {
+			return nil, fmt.Errorf("%w: stco box", errInvalidM4A)
+		}
+		for i := 0; i < int(binary.BigEndian.Uint32(stco[4:8])); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(stco[8+i*4:])))
+		}
+	} else if co64 := findM4ABox(boxes, "co64"); co64 != nil {
+		if len(co64) < 8 || len(co64)-8 < int(binary.BigEndian.Uint32(co64[4:8]))*8 {
+			return nil, fmt.Errorf("%w: co64 box", errInvalidM4A)
+		}
+		for i := 0; i < int(binary.BigEndian.Uint32(co64[4:8])); i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(co64[8+i*8:])))
+		}
+	} else {
+		return nil, fmt.Errorf("%w: no stco box", errInvalidM4A)
+	}
+
+	// Frames of each chunk, entries of the first chunk and the frames per
+	// chunk until the next entry.
+	stsc := findM4ABox(boxes, "stsc")
+	if len(stsc) < 8 || len(stsc)-8 < int(binary.BigEndian.Uint32(stsc[4:8]))*12 {
+		return nil, fmt.Errorf("%w: stsc box", errInvalidM4A)
+	}
+	entryCount := int(binary.BigEndian.Uint32(stsc[4:8]))
+	track.offsets = make([]int64, 0, len(track.sizes))
+	for i := 0; i < entryCount && len(track.offsets) < len(track.sizes); i++ {
+		entry := stsc[8+i*12:]
+		first := int(binary.BigEndian.Uint32(entry[0:4]))
+		perChunk := int(binary.BigEndian.Uint32(entry[4:8]))
+		last := len(chunks)
+		if i+1 < entryCount {
+			last = int(binary.BigEndian.Uint32(stsc[8+(i+1)*12:])) - 1
+		}
+		if first < 1 || last > len(chunks) {
+			return nil, fmt.Errorf("%w: stsc box", errInvalidM4A)
+		}
+		for chunk := first; chunk <= last; chunk++ {
+			offset := chunks[chunk-1]
+			for j := 0; j < perChunk && len(track.offsets) < len(track.sizes); j++ {
+				track.offsets = append(track.offsets, offset)
+				offset += int64(track.sizes[len(track.offsets)-1])
+			}
+		}
+	}
+	// Frames not in any chunk.
+	track.sizes = track.sizes[:len(track.offsets)]
+	return track, nil
+}
+
+// Parse an mp4a sample entry, returns the AudioSpecificConfig.
+func parseM4ASampleEntry(entry []byte) ([]byte, error) {
+	// The fields of the sound sample description by its version, before the
+	// child boxes.
+	if len(entry) < 28 {
+		return nil, fmt.Errorf("%w: mp4a box", errInvalidM4A)
+	}
+	start := 28
+	switch binary.BigEndian.Uint16(entry[8:10]) {
+	case 1:
+		start += 16
+	case 2:
+		start += 36
+	}
+	if len(entry) < start {
+		return nil, fmt.Errorf("%w: mp4a box", errInvalidM4A)
+	}
+	boxes, err := parseM4ABoxes(entry[start:])
+	if err != nil {
+		return nil, err
+	}
+	esds := findM4ABox(boxes, "esds")
+	if esds == nil {
+		// QuickTime files put the esds in a wave box.
+		esds = findM4ABox(boxes, "wave", "esds")
+	}
+	if len(esds) < 4 {
+		return nil, fmt.Errorf("%w: no esds box", errInvalidM4A)
+	}
+	return parseM4AESDS(esds[4:])
+}
+
+// Parse the ES_Descriptor of an esds box, ISO/IEC 14496-1 7.2.6.5, returns
+// the decoder specific info.
+func parseM4AESDS(data []byte) ([]byte, error) {
+	tag, es, err := readM4ADescriptor(data)
+	if err != nil || tag != 0x03 || len(es) < 3 {
+		return nil, fmt.Errorf("%w: esds box", errInvalidM4A)
+	}
+	flags := es[2]
+	skip := 3
+	if flags&0x80 != 0 {
+		skip += 2
+	}
+	if flags&0x40 != 0 && len(es) > skip {
+		skip += 1 + int(es[skip])
+	}
+	if flags&0x20 != 0 {
+		skip += 2
+	}
+	if len(es) < skip {
+		return nil, fmt.Errorf("%w: esds box", errInvalidM4A)
+	}
+
+	tag, decoderConfig, err := readM4ADescriptor(es[skip:])
+	if err != nil || tag != 0x04 || len(decoderConfig) < 13 {
+		return nil, fmt.Errorf("%w: esds box", errInvalidM4A)
+	}
+	// MPEG-4 audio, or the AAC profiles of MPEG-2.
+	if objectType := decoderConfig[0]; objectType != 0x40 && (objectType < 0x66 || objectType > 0x68) {
+		return nil, fmt.Errorf("%w: object type 0x%02x", ErrUnsupported, objectType)
+	}
+	tag, info, err := readM4ADescriptor(decoderConfig[13:])
+	if err != nil || tag != 0x05 {
+		return nil, fmt.Errorf("%w: esds box", errInvalidM4A)
+	}
+	return info, nil
+}
+
+// Read a descriptor, returns the tag and the payload.
+func readM4ADescriptor(data []byte) (byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, errInvalidM4A
+	}
+	tag, size, i := data[0], 0, 1
+	// The size in up to 4 bytes of 7 bits.
+	for ; i < len(data) && i <= 4; i++ {
+		size = size<<7 | int(data[i]&0x7f)
+		if data[i]&0x80 == 0 {
+			break
+		}
+	}
+	i++
+	if i > len(data) || len(data)-i < size {
+		return 0, nil, errInvalidM4A
+	}
+	return tag, data[i : i+size], nil
+}
+
+// m4a decoder of the AAC frames read at their offsets, of a file or a
+// stream.
+type m4aDecoder struct {
+	reader  io.ReaderAt
+	closer  io.Closer
+	track   *m4aTrack
+	decoder *aac.Decoder
+	// Index of the next frame.
+	next  int
+	frame []byte
+	// Decoded PCM not read yet.
+	pcm    []byte
+	pcmBuf []byte
+	// PCM bytes to drop after seeking, to the position in a frame.
+	skip int
+}
+
+func newM4ADecoder(reader io.ReaderAt, size int64, closer io.Closer) (*m4aDecoder, error) {
+	track, err := readM4ATrack(reader, size)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := aac.NewDecoder(track.config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return &m4aDecoder{reader: reader, closer: closer, track: track, decoder: decoder}, nil
+}
+
+func openM4AFile(path string) (*m4aDecoder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	d, err := newM4ADecoder(file, info.Size(), file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func openM4AStream(source Source) (*m4aDecoder, error) {
+	reader, err := newHTTPReaderAt(source.URL, source.ByteSize)
+	if err != nil {
+		return nil, err
+	}
+	return newM4ADecoder(reader, reader.size, reader)
+}
+
+func (d *m4aDecoder) Read(b []byte) (int, error) {
+	for len(d.pcm) == 0 {
+		if err := d.decodeFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, d.pcm)
+	d.pcm = d.pcm[n:]
+	return n, nil
+}
+
+// Decode the next frame into the PCM.
+func (d *m4aDecoder) decodeFrame() error {
+	if d.next >= len(d.track.sizes) {
+		return io.EOF
+	}
+	size := int(d.track.sizes[d.next])
+	if cap(d.frame) < size {
+		d.frame = make([]byte, size)
+	}
+	frame := d.frame[:size]
+	if n, err := d.reader.ReadAt(frame, d.track.offsets[d.next]); n < size {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	d.next++
+	samples, err := d.decoder.Decode(frame)
+	if err != nil {
+		return err
+	}
+
+	pcm := d.pcmBuf[:0]
+	left, right := samples[0], samples[len(samples)-1]
+	for i := range left {
+		pcm = appendSample(pcm, left[i])
+		pcm = appendSample(pcm, right[i])
+	}
+	d.pcmBuf = pcm
+	skip := minInt(d.skip, len(pcm))
+	d.skip -= skip
+	d.pcm = pcm[skip:]
+	return nil
+}
+
+// Append a sample in [-1, 1] as 16-bit little endian.
+func appendSample(pcm []byte, sample float32) []byte {
+	v := math.Round(float64(sample) * 32768)
+	if v > math.MaxInt16 {
+		v = math.MaxInt16
+	} else if v < math.MinInt16 {
+		v = math.MinInt16
+	}
+	return append(pcm, byte(int16(v)), byte(int16(v)>>8))
+}
+
+func (d *m4aDecoder) SampleRate() int {
+	return d.track.config.SampleRate
+}
+
+func (d *m4aDecoder) Duration() time.Duration {
+	return time.Duration(len(d.track.sizes)) * aac.FrameLength * time.Second / time.Duration(d.SampleRate())
+}
+
+func (d *m4aDecoder) Seek(position time.Duration) error {
+	sample := int64(position) * int64(d.SampleRate()) / int64(time.Second)
+	frame := int(sample / aac.FrameLength)
+	if frame > len(d.track.sizes) {
+		frame = len(d.track.sizes)
+	}
+	d.skip = int(sample-int64(frame)*aac.FrameLength) * FrameSize
+	// Decode the frame before to overlap the first one.
+	d.decoder.Reset()
+	if frame > 0 {
+		frame--
+		d.skip += aac.FrameLength * FrameSize
+	}
+	d.next, d.pcm = frame, nil
+	return nil
+}
+
+func (d *m4aDecoder) Close() error {
+	return d.closer.Close()
+}
+
+// Block size of the range requests of a stream.
+const httpBlockSize = 256 << 10
+
+// Reads a stream by range requests, the last block requested is cached.
+type httpReaderAt struct {
+	url string
+	// Size of the stream.
+	size       int64
+	blockStart int64
+	block      []byte
+}
+
+// Request the first block of a stream, size is the size if known, or learned
+// from the response.
+func newHTTPReaderAt(url string, size int64) (*httpReaderAt, error) {
+	r := &httpReaderAt{url: url, size: size}
+	if err := r.fetch(0, httpBlockSize); err != nil {
+		return nil, err
+	}
+	if r.size <= 0 {
+		return nil, fmt.Errorf("%w: stream of unknown size", ErrUnsupported)
+	}
+	return r, nil
+}
+
+// Request the block of the length from the offset.
+func (r *httpReaderAt) fetch(offset int64, length int) error {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		var first, last, total int64
+		contentRange := resp.Header.Get("Content-Range")
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &first, &last, &total); err != nil || first != offset {
+			return fmt.Errorf("GET %s: unexpected content range %q", r.url, contentRange)
+		}
+		r.size = total
+	case http.StatusOK:
+		// Range ignored by the server, skip to the offset.
+		if resp.ContentLength > 0 {
+			r.size = resp.ContentLength
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("GET %s: %s", r.url, resp.Status)
+	}
+	block, err := io.ReadAll(io.LimitReader(resp.Body, int64(length)))
+	if err != nil {
+		return err
+	}
+	r.blockStart, r.block = offset, block
+	return nil
+}
+
+func (r *httpReaderAt) ReadAt(b []byte, offset int64) (int, error) {
+	read := 0
+	for read < len(b) {
+		at := offset + int64(read)
+		if at >= r.size {
+			return read, io.EOF
+		}
+		if at < r.blockStart || at >= r.blockStart+int64(len(r.block)) {
+			length := len(b) - read
+			if length < httpBlockSize {
+				length = httpBlockSize
+			}
+			if err := r.fetch(at, length); err != nil {
+				return read, err
+			}
+			if len(r.block) == 0 {
+				return read, io.ErrUnexpectedEOF
+			}
+		}
+		read += copy(b[read:], r.block[at-r.blockStart:])
+	}
+	return read, nil
+}
+
+func (r *httpReaderAt) Close() error {
+	return nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
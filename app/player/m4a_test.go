@@ -0,0 +1,209 @@
+package player
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// AudioSpecificConfig of mono AAC LC at 44100 Hz.
+var testASC = []byte{0x12, 0x08}
+
+// A silent mono AAC frame, a single channel element of a long window without
+// any band, and the end element.
+var testSilentFrame = []byte{0x00, 0xc8, 0x00, 0x07}
+
+func buildTestBox(typ string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	box := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(box, uint32(8+len(payload)))
+	copy(box[4:], typ)
+	return append(box, payload...)
+}
+
+// Payload of a full box with the version and flags 0 and 32-bit fields.
+func buildTestFullBox(typ string, fields ...uint32) []byte {
+	payload := make([]byte, 4+4*len(fields))
+	for i, field := range fields {
+		binary.BigEndian.PutUint32(payload[4+4*i:], field)
+	}
+	return buildTestBox(typ, payload)
+}
+
+// Build an m4a file of the frames, chunks of perChunk frames, the moov box
+// after the mdat box if moovLast.
+func buildTestM4A(asc []byte, frames [][]byte, perChunk int, moovLast bool) []byte {
+	// The descriptors are small, one byte sizes.
+	decoderInfo := append([]byte{0x05, byte(len(asc))}, asc...)
+	decoderConfig := append([]byte{0x04, byte(13 + len(decoderInfo)), 0x40, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, decoderInfo...)
+	es := append([]byte{0x03, byte(3 + len(decoderConfig)), 0, 1, 0}, decoderConfig...)
+	esds := buildTestBox("esds", make([]byte, 4), es)
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[6:], 1)
+	binary.BigEndian.PutUint16(entry[16:], 1)
+	binary.BigEndian.PutUint16(entry[18:], 16)
+	binary.BigEndian.PutUint32(entry[24:], 44100<<16)
+	stsd := buildTestBox("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, buildTestBox("mp4a", entry, esds))
+
+	sizes := []uint32{0, uint32(len(frames))}
+	for _, frame := range frames {
+		sizes = append(sizes, uint32(len(frame)))
+	}
+	stsz := buildTestFullBox("stsz", sizes...)
+	stsc := buildTestFullBox("stsc", 1, 1, uint32(perChunk), 1)
+	chunks := (len(frames) + perChunk - 1) / perChunk
+	buildMoov := func(mdatOffset int) []byte {
+		offsets := []uint32{uint32(chunks)}
+		offset := mdatOffset + 8
+		for i, frame := range frames {
+			if i%perChunk == 0 {
+				offsets = append(offsets, uint32(offset))
+			}
+			offset += len(frame)
+		}
+		hdlr := buildTestBox("hdlr", make([]byte, 8), []byte("soun"), make([]byte, 13))
+		stbl := buildTestBox("stbl", stsd, stsz, stsc, buildTestFullBox("stco", offsets...))
+		mdia := buildTestBox("mdia", hdlr, buildTestBox("minf", stbl))
+		return buildTestBox("moov", buildTestBox("trak", mdia))
+	}
+
+	ftyp := buildTestBox("ftyp", []byte("M4A "), make([]byte, 4))
+	if moovLast {
+		return bytes.Join([][]byte{ftyp, buildTestBox("mdat", frames...), buildMoov(len(ftyp))}, nil)
+	}
+	moovSize := len(buildMoov(0))
+	return bytes.Join([][]byte{ftyp, buildMoov(len(ftyp) + moovSize), buildTestBox("mdat", frames...)}, nil)
+}
+
+func testSilentFrames(count int) [][]byte {
+	frames := [][]byte{}
+	for i := 0; i < count; i++ {
+		frames = append(frames, testSilentFrame)
+	}
+	return frames
+}
+
+func writeTestM4A(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "track.m4a")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenM4AFile(t *testing.T) {
+	for _, moovLast := range []bool{false, true} {
+		path := writeTestM4A(t, buildTestM4A(testASC, testSilentFrames(10), 3, moovLast))
+		decoder, err := openDecoder(Source{Path: path, Type: "M4A"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rate := decoder.SampleRate(); rate != 44100 {
+			t.Errorf("sample rate = %d, want 44100", rate)
+		}
+		if want := 10 * 1024 * time.Second / 44100; decoder.Duration() != want {
+			t.Errorf("duration = %v, want %v", decoder.Duration(), want)
+		}
+		pcm, err := io.ReadAll(decoder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pcm) != 10*1024*FrameSize {
+			t.Errorf("decoded %d bytes, want %d", len(pcm), 10*1024*FrameSize)
+		}
+		if !bytes.Equal(pcm, make([]byte, len(pcm))) {
+			t.Error("silent frames decoded to noise")
+		}
+
+		// Seeks to the sample in the frame.
+		if err := decoder.Seek(decoder.Duration() / 2); err != nil {
+			t.Fatal(err)
+		}
+		rest, err := io.ReadAll(decoder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := len(pcm) - int(durationToBytes(decoder.Duration()/2, 44100)); len(rest) != want {
+			t.Errorf("decoded %d bytes after seeking, want %d", len(rest), want)
+		}
+		decoder.Close()
+	}
+}
+
+func TestOpenM4AStream(t *testing.T) {
+	data := buildTestM4A(testASC, testSilentFrames(500), 20, true)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeContent(w, r, "track.m4a", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	decoder, err := openDecoder(Source{URL: server.URL, Type: "m4a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	if want := 500 * 1024 * time.Second / 44100; decoder.Duration() != want {
+		t.Errorf("duration = %v, want %v", decoder.Duration(), want)
+	}
+	pcm, err := io.ReadAll(decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcm) != 500*1024*FrameSize {
+		t.Errorf("decoded %d bytes, want %d", len(pcm), 500*1024*FrameSize)
+	}
+	// The frames are read from the cached blocks.
+	if requests > 3 {
+		t.Errorf("%d requests, want at most 3", requests)
+	}
+}
+
+func TestOpenM4AUnsupported(t *testing.T) {
+	// AAC Main.
+	path := writeTestM4A(t, buildTestM4A([]byte{0x0a, 0x08}, testSilentFrames(1), 1, false))
+	if _, err := openDecoder(Source{Path: path, Type: "m4a"}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("open AAC Main: %v, want %v", err, ErrUnsupported)
+	}
+
+	data := buildTestM4A(testASC, testSilentFrames(1), 1, false)
+	path = writeTestM4A(t, data[:len(data)-20])
+	if _, err := openDecoder(Source{Path: path, Type: "m4a"}); err == nil {
+		t.Error("truncated file opened")
+	}
+}
+
+func TestReadM4ADescriptor(t *testing.T) {
+	for _, test := range []struct {
+		data []byte
+		tag  byte
+		size int
+		ok   bool
+	}{
+		{[]byte{0x05, 0x02, 1, 2}, 5, 2, true},
+		// Sizes padded to 4 bytes.
+		{[]byte{0x05, 0x80, 0x80, 0x80, 0x02, 1, 2}, 5, 2, true},
+		{[]byte{0x05, 0x81, 0x00}, 0, 0, false},
+		{[]byte{0x05, 0x03, 1, 2}, 0, 0, false},
+		{[]byte{0x05}, 0, 0, false},
+	} {
+		tag, payload, err := readM4ADescriptor(test.data)
+		name := fmt.Sprintf("% x", test.data)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%s: error %v", name, err)
+			continue
+		}
+		if tag != test.tag || len(payload) != test.size {
+			t.Errorf("%s: tag %d size %d, want %d %d", name, tag, len(payload), test.tag, test.size)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package player
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/data/validation"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Playback speeds to choose.
+var speedOptions = []float64{0.5, 0.75, 1, 1.25, 1.5, 1.75, 2, 2.5, 3}
+
+// Sleep timer options, the duration is 0 to stop at the end of the track and
+// negative to turn off.
+var sleepOptions = []struct {
+	text     string
+	duration time.Duration
+}{
+	{"关闭", -1},
+	{"15 分钟", time.Minute * 15},
+	{"30 分钟", time.Minute * 30},
+	{"45 分钟", time.Minute * 45},
+	{"60 分钟", time.Minute * 60},
+	{"90 分钟", time.Minute * 90},
+	{"本集结束", 0},
+}
+
+func formatSpeed(speed float64) string {
+	return strconv.FormatFloat(speed, 'f', -1, 64) + "x"
+}
+
+func newSecondsEntry(seconds int) *widget.Entry {
+	entry := widget.NewEntry()
+	entry.SetText(strconv.Itoa(seconds))
+	entry.Validator = validation.NewRegexp(`^\d+$`, "Must be a number")
+	return entry
+}
+
+// Edit the playback preferences of the album of the playing track.
+func (p *Panel) showAlbumPreferencesDialog() {
+	item, ok := p.Playing()
+	if !ok {
+		return
+	}
+	albumId, prefs := p.albumPreferences()
+
+	speeds := make([]string, len(speedOptions))
+	for i, speed := range speedOptions {
+		speeds[i] = formatSpeed(speed)
+	}
+	speedSelect := widget.NewSelect(speeds, nil)
+	speedSelect.SetSelected(formatSpeed(prefs.Speed))
+	skipIntroEntry := newSecondsEntry(prefs.SkipIntro)
+	skipOutroEntry := newSecondsEntry(prefs.SkipOutro)
+	items := []*widget.FormItem{
+		{Text: "倍速", Widget: speedSelect, HintText: "变速不变调"},
+		{Text: "跳过片头", Widget: skipIntroEntry, HintText: "秒"},
+		{Text: "跳过片尾", Widget: skipOutroEntry, HintText: "秒"},
+	}
+	title := item.Album.Title
+	dlg := dialog.NewForm(title, "保存", "取消", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		if i := speedSelect.SelectedIndex(); i >= 0 {
+			prefs.Speed = speedOptions[i]
+		}
+		prefs.SkipIntro, _ = strconv.Atoi(skipIntroEntry.Text)
+		prefs.SkipOutro, _ = strconv.Atoi(skipOutroEntry.Text)
+		if err := p.preferences.Set(albumId, prefs); err != nil {
+			dialog.ShowError(err, p.appwin)
+		}
+		p.player.SetSpeed(prefs.Speed)
+		p.Refresh()
+	}, p.appwin)
+	dlg.Resize(fyne.NewSize(p.appwin.Canvas().Size().Width, dlg.MinSize().Height))
+	dlg.Show()
+}
+
+// Choose when the sleep timer pauses playing.
+func (p *Panel) showSleepTimerDialog() {
+	options := make([]string, len(sleepOptions))
+	for i, option := range sleepOptions {
+		options[i] = option.text
+	}
+	radio := widget.NewRadioGroup(options, nil)
+	radio.SetSelected(options[0])
+	sleepAt, sleepAtEnd := p.sleepTimer()
+	if sleepAtEnd {
+		radio.SetSelected(options[len(options)-1])
+	}
+	title := "定时关闭"
+	if !sleepAt.IsZero() {
+		title = fmt.Sprintf("定时关闭 (剩余 %s)", time.Until(sleepAt).Round(time.Second))
+	}
+	dialog.ShowCustomConfirm(title, "确定", "取消", radio, func(ok bool) {
+		if !ok {
+			return
+		}
+		for _, option := range sleepOptions {
+			if option.text != radio.Selected {
+				continue
+			}
+			at := time.Time{}
+			if option.duration > 0 {
+				at = time.Now().Add(option.duration)
+			}
+			p.setSleepTimer(at, option.duration == 0)
+		}
+		p.Refresh()
+	}, p.appwin)
+}
@@ -0,0 +1,58 @@
+//go:build !ci
+// +build !ci
+
+package otosink
+
+import (
+	"github.com/hajimehoshi/oto"
+
+	"xmlymft-fyne-gui/app/player"
+)
+
+// Output buffer duration in milliseconds, short to pause and seek quickly.
+const bufferMillis = 200
+
+// Sink plays on the audio device with oto.
+type Sink struct {
+	context    *oto.Context
+	output     *oto.Player
+	sampleRate int
+}
+
+// Open the audio device for the sample rate, reopened if the sample rate
+// changed.
+func (s *Sink) Open(sampleRate int) error {
+	if s.context != nil && s.sampleRate == sampleRate {
+		return nil
+	}
+	s.Close()
+
+	bufferSize := sampleRate * player.FrameSize * bufferMillis / 1000
+	context, err := oto.NewContext(sampleRate, 2, 2, bufferSize)
+	if err != nil {
+		return err
+	}
+	s.context = context
+	s.output = context.NewPlayer()
+	s.sampleRate = sampleRate
+	return nil
+}
+
+func (s *Sink) Write(pcm []byte) (int, error) {
+	return s.output.Write(pcm)
+}
+
+func (s *Sink) Close() error {
+	if s.context == nil {
+		return nil
+	}
+	s.output.Close()
+	err := s.context.Close()
+	s.context, s.output = nil, nil
+	return err
+}
+
+// NewSink returns the sink of the audio device.
+func NewSink() (player.Sink, error) {
+	return new(Sink), nil
+}
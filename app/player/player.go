@@ -0,0 +1,318 @@
+package player
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Duration of the PCM written to the sink at once.
+const chunkDuration = time.Millisecond * 50
+
+type State int
+
+const (
+	Stopped State = iota
+	Playing
+	Paused
+)
+
+// Player decodes a source and writes the PCM to a sink.
+type Player struct {
+	sink Sink
+	// Serialize opening and writing the sink.
+	sinkLock sync.Mutex
+
+	lock sync.Mutex
+	cond *sync.Cond
+	// Increased when a source is loaded, stops the playing loop of the
+	// previous source.
+	generation int
+	source     Source
+	state      State
+	duration   time.Duration
+	// Position of the PCM written to the sink.
+	position time.Duration
+	// Position to seek to, negative if not seeking.
+	seekTo time.Duration
+	volume float64
+	// Playback speed in [MinSpeed, MaxSpeed].
+	speed float64
+
+	// Called when a source played to the end.
+	OnFinished func()
+	// Called when failed to decode.
+	OnError func(err error)
+}
+
+// Load a source and start playing from the start position, the playing
+// source is stopped. The decoder seeks to the start before any output.
+func (p *Player) Load(source Source, start time.Duration) error {
+	decoder, err := openDecoder(source)
+	if err != nil {
+		return err
+	}
+	p.load(source, decoder, start)
+	return nil
+}
+
+// Start playing the decoder of a source from the start position.
+func (p *Player) load(source Source, decoder Decoder, start time.Duration) {
+	p.lock.Lock()
+	p.generation++
+	p.source = source
+	p.state = Playing
+	p.duration = decoder.Duration()
+	if p.duration <= 0 {
+		p.duration = source.Duration
+	}
+	if p.duration > 0 && start > p.duration {
+		start = p.duration
+	}
+	p.position = 0
+	p.seekTo = -1
+	if start > 0 {
+		p.position = start
+		p.seekTo = start
+	}
+	generation := p.generation
+	p.cond.Broadcast()
+	p.lock.Unlock()
+
+	go p.play(generation, decoder)
+}
+
+// Play the loaded source.
+func (p *Player) Play() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.state == Paused {
+		p.state = Playing
+		p.cond.Broadcast()
+	}
+}
+
+// Pause the playing source.
+func (p *Player) Pause() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.state == Playing {
+		p.state = Paused
+	}
+}
+
+// Stop the playing source.
+func (p *Player) Stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.generation++
+	p.state = Stopped
+	p.position = 0
+	p.cond.Broadcast()
+}
+
+// Seek to a position of the loaded source.
+func (p *Player) Seek(position time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.state == Stopped {
+		return
+	}
+	if position < 0 {
+		position = 0
+	}
+	if p.duration > 0 && position > p.duration {
+		position = p.duration
+	}
+	p.seekTo = position
+	p.position = position
+	p.cond.Broadcast()
+}
+
+// SetVolume set the volume in [0, 1].
+func (p *Player) SetVolume(volume float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.volume = math.Max(0, math.Min(1, volume))
+}
+
+// SetSpeed set the playback speed in [MinSpeed, MaxSpeed], the pitch is
+// kept.
+func (p *Player) SetSpeed(speed float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.speed = math.Max(MinSpeed, math.Min(MaxSpeed, speed))
+}
+
+func (p *Player) Speed() float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.speed
+}
+
+func (p *Player) Volume() float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.volume
+}
+
+func (p *Player) State() State {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.state
+}
+
+// Source returns the loaded source.
+func (p *Player) Source() Source {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.source
+}
+
+// Position returns the playing position and the duration, the duration is 0
+// if unknown.
+func (p *Player) Position() (time.Duration, time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.position, p.duration
+}
+
+// Close stop playing and close the sink.
+func (p *Player) Close() error {
+	p.Stop()
+
+	p.sinkLock.Lock()
+	defer p.sinkLock.Unlock()
+	return p.sink.Close()
+}
+
+// Decode and write to the sink until the end or another source loaded.
+func (p *Player) play(generation int, decoder Decoder) {
+	defer decoder.Close()
+
+	p.sinkLock.Lock()
+	defer p.sinkLock.Unlock()
+
+	sampleRate := decoder.SampleRate()
+	if err := p.sink.Open(sampleRate); err != nil {
+		p.fail(generation, err)
+		return
+	}
+	buf := make([]byte, durationToBytes(chunkDuration, sampleRate))
+	stretch := newStretcher(sampleRate)
+	for {
+		p.lock.Lock()
+		for p.state == Paused && p.generation == generation {
+			p.cond.Wait()
+		}
+		if p.generation != generation {
+			p.lock.Unlock()
+			return
+		}
+		seekTo, volume, speed := p.seekTo, p.volume, p.speed
+		p.seekTo = -1
+		p.lock.Unlock()
+
+		if seekTo >= 0 {
+			if err := decoder.Seek(seekTo); err != nil {
+				p.fail(generation, err)
+				return
+			}
+			stretch.reset()
+		}
+		n, err := io.ReadFull(decoder, buf)
+		// Keep whole frames.
+		n -= n % FrameSize
+		pcm := buf[:n]
+		if speed != 1 {
+			pcm = stretch.process(pcm, speed)
+		} else {
+			stretch.reset()
+		}
+		if len(pcm) > 0 {
+			applyVolume(pcm, volume)
+			if _, err := p.sink.Write(pcm); err != nil {
+				p.fail(generation, err)
+				return
+			}
+		}
+
+		p.lock.Lock()
+		if p.generation != generation {
+			p.lock.Unlock()
+			return
+		}
+		// Seeking while decoding, the position is set by the seek. The
+		// position is of the decoded input, ahead of the stretched output.
+		if p.seekTo < 0 {
+			p.position += bytesToDuration(int64(n), sampleRate)
+		}
+		finished := err == io.EOF || err == io.ErrUnexpectedEOF
+		if finished {
+			p.state = Stopped
+		}
+		p.lock.Unlock()
+
+		if finished {
+			if p.OnFinished != nil {
+				go p.OnFinished()
+			}
+			return
+		}
+		if err != nil {
+			p.fail(generation, err)
+			return
+		}
+	}
+}
+
+// Stop playing the source of the generation for an error.
+func (p *Player) fail(generation int, err error) {
+	p.lock.Lock()
+	if p.generation != generation {
+		p.lock.Unlock()
+		return
+	}
+	p.state = Stopped
+	p.lock.Unlock()
+
+	if p.OnError != nil {
+		go p.OnError(err)
+	}
+}
+
+// Scale the 16-bit samples by the volume.
+func applyVolume(pcm []byte, volume float64) {
+	if volume >= 1 {
+		return
+	}
+	for i := 0; i+1 < len(pcm); i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcm[i:])))
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(sample*volume)))
+	}
+}
+
+// NewPlayer create a player writing to the sink.
+func NewPlayer(sink Sink) *Player {
+	p := &Player{
+		sink:   sink,
+		seekTo: -1,
+		volume: 1,
+		speed:  1,
+	}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
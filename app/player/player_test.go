@@ -0,0 +1,234 @@
+package player
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSampleRate = 8000
+
+// Decoder of silence with a duration, records the seeks and reads.
+type fakeDecoder struct {
+	lock   sync.Mutex
+	size   int64
+	offset int64
+	seeks  []time.Duration
+	closed bool
+	// Offsets of the reads.
+	readFrom []int64
+}
+
+func newFakeDecoder(duration time.Duration) *fakeDecoder {
+	return &fakeDecoder{size: durationToBytes(duration, testSampleRate)}
+}
+
+func (d *fakeDecoder) Read(b []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.readFrom = append(d.readFrom, d.offset)
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+	n := int64(len(b))
+	if n > d.size-d.offset {
+		n = d.size - d.offset
+	}
+	for i := range b[:n] {
+		b[i] = 0
+	}
+	d.offset += n
+	return int(n), nil
+}
+
+func (d *fakeDecoder) SampleRate() int {
+	return testSampleRate
+}
+
+func (d *fakeDecoder) Duration() time.Duration {
+	return bytesToDuration(d.size, testSampleRate)
+}
+
+func (d *fakeDecoder) Seek(position time.Duration) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.seeks = append(d.seeks, position)
+	d.offset = durationToBytes(position, testSampleRate)
+	return nil
+}
+
+func (d *fakeDecoder) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.closed = true
+	return nil
+}
+
+// Create a player writing to a null sink, returns a channel receiving when a
+// track finished.
+func newTestPlayer(realtime bool) (*Player, *NullSink, chan struct{}) {
+	sink := &NullSink{Realtime: realtime}
+	p := NewPlayer(sink)
+	finished := make(chan struct{}, 1)
+	p.OnFinished = func() {
+		finished <- struct{}{}
+	}
+	return p, sink, finished
+}
+
+func waitFinished(t *testing.T, finished chan struct{}) {
+	t.Helper()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("track not finished")
+	}
+}
+
+func TestPlayToEnd(t *testing.T) {
+	p, sink, finished := newTestPlayer(false)
+	decoder := newFakeDecoder(time.Second)
+	p.load(Source{Type: "mp3"}, decoder, 0)
+	waitFinished(t, finished)
+
+	if state := p.State(); state != Stopped {
+		t.Errorf("state = %v, want stopped", state)
+	}
+	if position, duration := p.Position(); position != time.Second || duration != time.Second {
+		t.Errorf("position = %v/%v, want 1s/1s", position, duration)
+	}
+	if written := sink.Written(); written != decoder.size {
+		t.Errorf("written %d bytes, want %d", written, decoder.size)
+	}
+	time.Sleep(10 * time.Millisecond)
+	decoder.lock.Lock()
+	defer decoder.lock.Unlock()
+	if !decoder.closed {
+		t.Error("decoder not closed")
+	}
+}
+
+func TestPauseAndPlay(t *testing.T) {
+	p, sink, finished := newTestPlayer(true)
+	p.load(Source{Type: "mp3"}, newFakeDecoder(400*time.Millisecond), 0)
+	time.Sleep(100 * time.Millisecond)
+	p.Pause()
+	if state := p.State(); state != Paused {
+		t.Fatalf("state = %v, want paused", state)
+	}
+	// The chunk being written when paused is finished.
+	time.Sleep(2 * chunkDuration)
+	written := sink.Written()
+	position, _ := p.Position()
+	time.Sleep(3 * chunkDuration)
+	if sink.Written() != written {
+		t.Errorf("written %d bytes while paused", sink.Written()-written)
+	}
+	if now, _ := p.Position(); now != position {
+		t.Errorf("position moved from %v to %v while paused", position, now)
+	}
+
+	p.Play()
+	if state := p.State(); state != Playing {
+		t.Fatalf("state = %v, want playing", state)
+	}
+	waitFinished(t, finished)
+	if position, _ := p.Position(); position != 400*time.Millisecond {
+		t.Errorf("position = %v, want 400ms", position)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	p, sink, finished := newTestPlayer(true)
+	decoder := newFakeDecoder(2 * time.Second)
+	p.load(Source{Type: "mp3"}, decoder, 0)
+	p.Pause()
+	time.Sleep(2 * chunkDuration)
+	before := sink.Written()
+
+	p.Seek(1500 * time.Millisecond)
+	if position, _ := p.Position(); position != 1500*time.Millisecond {
+		t.Errorf("position = %v after seek, want 1.5s", position)
+	}
+	p.Play()
+	waitFinished(t, finished)
+
+	decoder.lock.Lock()
+	seeks := decoder.seeks
+	decoder.lock.Unlock()
+	if len(seeks) != 1 || seeks[0] != 1500*time.Millisecond {
+		t.Errorf("decoder seeks = %v, want [1.5s]", seeks)
+	}
+	if written, want := sink.Written()-before, durationToBytes(500*time.Millisecond, testSampleRate); written != want {
+		t.Errorf("written %d bytes after seek, want %d", written, want)
+	}
+	if position, _ := p.Position(); position != 2*time.Second {
+		t.Errorf("position = %v, want 2s", position)
+	}
+
+	// Seeking out of the duration is clamped, a stopped player ignores seeks.
+	p.Seek(time.Hour)
+	if position, _ := p.Position(); position != 2*time.Second {
+		t.Errorf("position = %v after seek when stopped, want 2s", position)
+	}
+}
+
+func TestLoadFromStart(t *testing.T) {
+	p, sink, finished := newTestPlayer(false)
+	decoder := newFakeDecoder(time.Second)
+	p.load(Source{Type: "mp3"}, decoder, 600*time.Millisecond)
+	waitFinished(t, finished)
+
+	decoder.lock.Lock()
+	seeks, readFrom := decoder.seeks, decoder.readFrom
+	decoder.lock.Unlock()
+	if len(seeks) != 1 || seeks[0] != 600*time.Millisecond {
+		t.Errorf("decoder seeks = %v, want [600ms]", seeks)
+	}
+	// Nothing before the start is decoded or written.
+	if start := durationToBytes(600*time.Millisecond, testSampleRate); len(readFrom) == 0 || readFrom[0] != start {
+		t.Errorf("first read from %v, want %d", readFrom, start)
+	}
+	if written, want := sink.Written(), durationToBytes(400*time.Millisecond, testSampleRate); written != want {
+		t.Errorf("written %d bytes, want %d", written, want)
+	}
+}
+
+func TestStopAndLoadAnother(t *testing.T) {
+	p, _, finished := newTestPlayer(true)
+	first := newFakeDecoder(time.Second)
+	p.load(Source{Type: "mp3"}, first, 0)
+	time.Sleep(chunkDuration)
+	p.Stop()
+	if state := p.State(); state != Stopped {
+		t.Fatalf("state = %v, want stopped", state)
+	}
+
+	second := newFakeDecoder(200 * time.Millisecond)
+	p.load(Source{Type: "mp3"}, second, 0)
+	waitFinished(t, finished)
+	first.lock.Lock()
+	defer first.lock.Unlock()
+	if !first.closed {
+		t.Error("stopped decoder not closed")
+	}
+	select {
+	case <-finished:
+		t.Error("stopped track finished")
+	case <-time.After(2 * chunkDuration):
+	}
+}
+
+func TestOpenDecoderUnsupported(t *testing.T) {
+	if _, err := openDecoder(Source{Path: "track.flac", Type: "flac"}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("open flac: %v, want %v", err, ErrUnsupported)
+	}
+	if !CanPlay("MP3") || !CanPlay("m4a") || CanPlay("flac") {
+		t.Error("only mp3 and m4a are playable")
+	}
+}
@@ -0,0 +1,164 @@
+package player
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+const PositionsFilePath = "./positions.json"
+
+// A track is finished when played past the ratio of its duration.
+const FinishedRatio = 0.95
+
+// Playback position of a track.
+type TrackPosition struct {
+	Item Item `json:"item"`
+	// Position in milliseconds.
+	Position int64 `json:"position"`
+	// Duration in milliseconds, 0 if unknown.
+	Duration int64 `json:"duration"`
+	Finished bool  `json:"finished"`
+	// Unix time of the last update.
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+func (p TrackPosition) Time() time.Duration {
+	return time.Duration(p.Position) * time.Millisecond
+}
+
+// Positions keeps the playback positions of tracks and the last played track
+// of albums, saved in a JSON file.
+type Positions struct {
+	path string
+
+	lock sync.RWMutex
+	// trackId -> position.
+	tracks map[int]*TrackPosition
+	// albumId -> trackId of the last played track.
+	albums map[int]int
+
+	// Called when a position updated.
+	OnChanged func()
+}
+
+type positionsFile struct {
+	Tracks []*TrackPosition `json:"tracks"`
+	Albums map[int]int      `json:"albums"`
+}
+
+// Get the position of a track.
+func (p *Positions) Get(trackId int) (TrackPosition, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	position, ok := p.tracks[trackId]
+	if !ok {
+		return TrackPosition{}, false
+	}
+	return *position, true
+}
+
+// Last get the position of the last played track of an album.
+func (p *Positions) Last(albumId int) (TrackPosition, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	trackId, ok := p.albums[albumId]
+	if !ok {
+		return TrackPosition{}, false
+	}
+	position, ok := p.tracks[trackId]
+	if !ok {
+		return TrackPosition{}, false
+	}
+	return *position, true
+}
+
+// Update the position of a track, the track is finished if played past
+// FinishedRatio of the duration. A finished track stays finished.
+func (p *Positions) Update(item Item, position time.Duration, duration time.Duration) error {
+	p.lock.Lock()
+	finished := duration > 0 && float64(position) >= float64(duration)*FinishedRatio
+	if old, ok := p.tracks[item.Track.Id]; ok && old.Finished {
+		finished = true
+	}
+	p.tracks[item.Track.Id] = &TrackPosition{
+		Item:      item,
+		Position:  position.Milliseconds(),
+		Duration:  duration.Milliseconds(),
+		Finished:  finished,
+		UpdatedAt: time.Now().Unix(),
+	}
+	p.albums[item.Album.Id] = item.Track.Id
+	err := p.save()
+	p.lock.Unlock()
+
+	if p.OnChanged != nil {
+		p.OnChanged()
+	}
+	return err
+}
+
+// Finish mark a track finished.
+func (p *Positions) Finish(item Item, duration time.Duration) error {
+	return p.Update(item, duration, duration)
+}
+
+// Save the positions file, requires lock.
+func (p *Positions) save() error {
+	if p.path == "" {
+		return nil
+	}
+	file := positionsFile{
+		Tracks: make([]*TrackPosition, 0, len(p.tracks)),
+		Albums: p.albums,
+	}
+	for _, position := range p.tracks {
+		file.Tracks = append(file.Tracks, position)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	tmppath := p.path + ".tmp"
+	if err = os.WriteFile(tmppath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, p.path)
+}
+
+// LoadPositions load the positions file, empty positions are returned if the
+// file not exists, or failed to parse and moved aside.
+func LoadPositions(path string) (*Positions, error) {
+	p := &Positions{
+		path:   path,
+		tracks: map[int]*TrackPosition{},
+		albums: map[int]int{},
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || path == "" {
+		return p, nil
+	} else if err != nil {
+		p.path = ""
+		return p, err
+	}
+	file := positionsFile{}
+	if err = json.Unmarshal(data, &file); err != nil {
+		moved, err := utils.SetAsideCorrupt(path, err)
+		if !moved {
+			p.path = ""
+		}
+		return p, err
+	}
+	for _, position := range file.Tracks {
+		p.tracks[position.Item.Track.Id] = position
+	}
+	for albumId, trackId := range file.Albums {
+		p.albums[albumId] = trackId
+	}
+	return p, nil
+}
@@ -0,0 +1,111 @@
+package player
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+const PreferencesFilePath = "./album_preferences.json"
+
+// Playback preferences of an album.
+type AlbumPreferences struct {
+	// Playback speed, 1 for normal.
+	Speed float64 `json:"speed"`
+	// Seconds to skip at the start of each track.
+	SkipIntro int `json:"skipIntro"`
+	// Seconds to skip at the end of each track.
+	SkipOutro int `json:"skipOutro"`
+}
+
+func (p AlbumPreferences) Intro() time.Duration {
+	return time.Duration(p.SkipIntro) * time.Second
+}
+
+func (p AlbumPreferences) Outro() time.Duration {
+	return time.Duration(p.SkipOutro) * time.Second
+}
+
+func NewAlbumPreferences() AlbumPreferences {
+	return AlbumPreferences{Speed: 1}
+}
+
+// Preferences keeps the playback preferences of albums, saved in a JSON file.
+type Preferences struct {
+	path string
+
+	lock sync.RWMutex
+	// albumId -> preferences.
+	albums map[int]AlbumPreferences
+}
+
+// Get the preferences of an album, the default if not set.
+func (p *Preferences) Get(albumId int) AlbumPreferences {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	prefs, ok := p.albums[albumId]
+	if !ok {
+		return NewAlbumPreferences()
+	}
+	return prefs
+}
+
+// Set the preferences of an album and save the preferences file.
+func (p *Preferences) Set(albumId int, prefs AlbumPreferences) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.albums[albumId] = prefs
+	return p.save()
+}
+
+// Save the preferences file, requires lock.
+func (p *Preferences) save() error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(p.albums)
+	if err != nil {
+		return err
+	}
+	tmppath := p.path + ".tmp"
+	if err = os.WriteFile(tmppath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, p.path)
+}
+
+// LoadPreferences load the preferences file, empty preferences are returned
+// if the file not exists, or failed to parse and moved aside.
+func LoadPreferences(path string) (*Preferences, error) {
+	p := &Preferences{
+		path:   path,
+		albums: map[int]AlbumPreferences{},
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || path == "" {
+		return p, nil
+	} else if err != nil {
+		p.path = ""
+		return p, err
+	}
+	if err = json.Unmarshal(data, &p.albums); err != nil {
+		p.albums = map[int]AlbumPreferences{}
+		moved, err := utils.SetAsideCorrupt(path, err)
+		if !moved {
+			p.path = ""
+		}
+		return p, err
+	}
+	for albumId, prefs := range p.albums {
+		if prefs.Speed < MinSpeed || prefs.Speed > MaxSpeed {
+			prefs.Speed = 1
+			p.albums[albumId] = prefs
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,457 @@
+package player
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"sync"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+const QueueFilePath = "./queue.json"
+
+type RepeatMode int
+
+const (
+	RepeatOff RepeatMode = iota
+	// Repeat the whole queue.
+	RepeatAll
+	// Repeat the playing track.
+	RepeatOne
+)
+
+func (r RepeatMode) String() string {
+	switch r {
+	case RepeatAll:
+		return "列表循环"
+	case RepeatOne:
+		return "单曲循环"
+	}
+	return "顺序播放"
+}
+
+// Queue of the tracks to play, mixed from albums, saved in a JSON file.
+type Queue struct {
+	path string
+
+	lock  sync.RWMutex
+	items []Item
+	// Index of the current item, -1 if none.
+	index int
+	// Whether the playing item was removed, the item at index took its place
+	// and is played next. -1 index if the last item was removed.
+	removed bool
+	shuffle bool
+	repeat  RepeatMode
+	// Track ids played in this shuffle round.
+	played map[int]bool
+	// Track ids played before in shuffle, for previous.
+	history []int
+
+	// Called when the queue changed.
+	OnChanged func()
+}
+
+type queueFile struct {
+	Items   []Item     `json:"items"`
+	Index   int        `json:"index"`
+	Shuffle bool       `json:"shuffle"`
+	Repeat  RepeatMode `json:"repeat"`
+}
+
+// Items returns the items and the index of the current item, -1 if none.
+func (q *Queue) Items() ([]Item, int) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	items := make([]Item, len(q.items))
+	copy(items, q.items)
+	return items, q.index
+}
+
+// Current returns the current item.
+func (q *Queue) Current() (Item, bool) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	if q.index < 0 || q.index >= len(q.items) {
+		return Item{}, false
+	}
+	return q.items[q.index], true
+}
+
+// Replace the items and select the item at index.
+func (q *Queue) Replace(items []Item, index int) (Item, bool) {
+	q.lock.Lock()
+	q.items = make([]Item, len(items))
+	copy(q.items, items)
+	q.index = -1
+	q.removed = false
+	q.played = map[int]bool{}
+	q.history = nil
+	item, ok := q.selectIndex(index)
+	q.lock.Unlock()
+
+	q.changed()
+	return item, ok
+}
+
+// Select the item at index as the current item.
+func (q *Queue) Select(index int) (Item, bool) {
+	q.lock.Lock()
+	item, ok := q.selectIndex(index)
+	q.lock.Unlock()
+
+	q.changed()
+	return item, ok
+}
+
+// PlayNext put a track after the current item, moved if already queued.
+func (q *Queue) PlayNext(item Item) {
+	q.lock.Lock()
+	if i := q.find(item.Track.Id); i >= 0 {
+		if i == q.index {
+			q.lock.Unlock()
+			return
+		}
+		q.remove(i)
+	}
+	at := q.index + 1
+	if q.removed {
+		// Played before the item took the removed one's place.
+		at = q.index
+		if at < 0 {
+			at = len(q.items)
+		}
+		q.index = at
+	}
+	q.items = append(q.items, Item{})
+	copy(q.items[at+1:], q.items[at:])
+	q.items[at] = item
+	q.save()
+	q.lock.Unlock()
+
+	q.changed()
+}
+
+// Add a track to the end, skipped if already queued.
+func (q *Queue) Add(item Item) {
+	q.lock.Lock()
+	if q.find(item.Track.Id) < 0 {
+		q.items = append(q.items, item)
+		// The removed playing item was the last, the added one is next.
+		if q.removed && q.index < 0 {
+			q.index = len(q.items) - 1
+		}
+		q.save()
+	}
+	q.lock.Unlock()
+
+	q.changed()
+}
+
+// Remove the item at index.
+func (q *Queue) Remove(index int) {
+	q.lock.Lock()
+	if index >= 0 && index < len(q.items) {
+		q.remove(index)
+	}
+	q.lock.Unlock()
+
+	q.changed()
+}
+
+// Move the item at from to to.
+func (q *Queue) Move(from int, to int) {
+	q.lock.Lock()
+	if from < 0 || from >= len(q.items) || from == to {
+		q.lock.Unlock()
+		return
+	}
+	if to < 0 {
+		to = 0
+	}
+	if to >= len(q.items) {
+		to = len(q.items) - 1
+	}
+	item := q.items[from]
+	current := q.index
+	q.remove(from)
+	q.items = append(q.items, Item{})
+	copy(q.items[to+1:], q.items[to:])
+	q.items[to] = item
+	if from == current {
+		q.index = to
+		q.removed = false
+	} else if q.index >= to {
+		q.index++
+	}
+	q.save()
+	q.lock.Unlock()
+
+	q.changed()
+}
+
+// Clear the queue.
+func (q *Queue) Clear() {
+	q.Replace(nil, -1)
+}
+
+// Next select the next item. Without manual, the current item is repeated in
+// RepeatOne mode.
+func (q *Queue) Next(manual bool) (Item, bool) {
+	q.lock.Lock()
+	next := q.nextIndex(manual)
+	if next >= 0 && q.shuffle && q.index >= 0 && next != q.index && !q.removed {
+		q.history = append(q.history, q.items[q.index].Track.Id)
+	}
+	item, ok := q.selectIndex(next)
+	q.lock.Unlock()
+
+	q.changed()
+	return item, ok
+}
+
+// Previous select the previous item, the previously played one in shuffle.
+func (q *Queue) Previous() (Item, bool) {
+	q.lock.Lock()
+	previous := -1
+	for q.shuffle && previous < 0 && len(q.history) > 0 {
+		previous = q.find(q.history[len(q.history)-1])
+		q.history = q.history[:len(q.history)-1]
+	}
+	if previous < 0 {
+		previous = q.index - 1
+		if q.removed && q.index < 0 {
+			// The removed item was the last.
+			previous = len(q.items) - 1
+		}
+		if previous < 0 && q.repeat == RepeatAll {
+			previous = len(q.items) - 1
+		}
+	}
+	item, ok := q.selectIndex(previous)
+	q.lock.Unlock()
+
+	q.changed()
+	return item, ok
+}
+
+// HasNext whether there is a next item to play manually.
+func (q *Queue) HasNext() bool {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return q.hasNext()
+}
+
+// HasPrevious whether there is a previous item.
+func (q *Queue) HasPrevious() bool {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return q.index > 0 || ((q.repeat == RepeatAll || (q.removed && q.index < 0)) && len(q.items) > 0) ||
+		(q.shuffle && len(q.history) > 0)
+}
+
+func (q *Queue) Shuffle() bool {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return q.shuffle
+}
+
+func (q *Queue) SetShuffle(shuffle bool) {
+	q.lock.Lock()
+	q.shuffle = shuffle
+	q.played = map[int]bool{}
+	q.history = nil
+	if q.index >= 0 && q.index < len(q.items) {
+		q.played[q.items[q.index].Track.Id] = true
+	}
+	q.save()
+	q.lock.Unlock()
+
+	q.changed()
+}
+
+func (q *Queue) Repeat() RepeatMode {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return q.repeat
+}
+
+func (q *Queue) SetRepeat(repeat RepeatMode) {
+	q.lock.Lock()
+	q.repeat = repeat
+	q.save()
+	q.lock.Unlock()
+
+	q.changed()
+}
+
+// Index of the next item, -1 if none, requires write lock as a new shuffle
+// round may start.
+func (q *Queue) nextIndex(manual bool) int {
+	if len(q.items) == 0 {
+		return -1
+	}
+	if q.removed && !q.shuffle {
+		if q.index < 0 && q.repeat != RepeatOff {
+			return 0
+		}
+		return q.index
+	}
+	if q.repeat == RepeatOne && !manual && q.index >= 0 && !q.removed {
+		return q.index
+	}
+	if q.shuffle {
+		candidates := q.unplayed()
+		if len(candidates) == 0 && q.repeat != RepeatOff {
+			// Start a new round.
+			q.played = map[int]bool{}
+			candidates = q.unplayed()
+		}
+		if len(candidates) == 0 {
+			return -1
+		}
+		return candidates[rand.Intn(len(candidates))]
+	}
+	if q.index+1 < len(q.items) {
+		return q.index + 1
+	}
+	if q.repeat != RepeatOff {
+		return 0
+	}
+	return -1
+}
+
+// Whether there is a next item to play manually, the same as nextIndex
+// without changing the queue, requires read lock.
+func (q *Queue) hasNext() bool {
+	if len(q.items) == 0 {
+		return false
+	}
+	if q.removed && !q.shuffle {
+		return q.index >= 0 || q.repeat != RepeatOff
+	}
+	if q.repeat != RepeatOff {
+		return true
+	}
+	if q.shuffle {
+		return len(q.unplayed()) > 0
+	}
+	return q.index+1 < len(q.items)
+}
+
+// Indexes of the items not played in the shuffle round except the current
+// one, requires lock.
+func (q *Queue) unplayed() []int {
+	indexes := []int{}
+	for i, item := range q.items {
+		if (i != q.index || q.removed) && !q.played[item.Track.Id] {
+			indexes = append(indexes, i)
+		}
+	}
+	// The only item is repeated.
+	if len(indexes) == 0 && len(q.items) == 1 && len(q.played) == 0 {
+		indexes = append(indexes, 0)
+	}
+	return indexes
+}
+
+// Select the item at index and save, requires lock.
+func (q *Queue) selectIndex(index int) (Item, bool) {
+	if index < 0 || index >= len(q.items) {
+		q.save()
+		return Item{}, false
+	}
+	q.index = index
+	q.removed = false
+	q.played[q.items[index].Track.Id] = true
+	q.save()
+	return q.items[index], true
+}
+
+// Index of a track, -1 if not queued, requires lock.
+func (q *Queue) find(trackId int) int {
+	for i, item := range q.items {
+		if item.Track.Id == trackId {
+			return i
+		}
+	}
+	return -1
+}
+
+// Remove the item at index and save, requires lock. The index keeps pointing
+// at the current item, or the item taking the place of the removed current
+// one, which is played next.
+func (q *Queue) remove(index int) {
+	q.items = append(q.items[:index], q.items[index+1:]...)
+	if index < q.index {
+		q.index--
+	} else if index == q.index {
+		q.removed = true
+		if q.index >= len(q.items) {
+			q.index = -1
+		}
+	}
+	q.save()
+}
+
+func (q *Queue) changed() {
+	if q.OnChanged != nil {
+		q.OnChanged()
+	}
+}
+
+// Save the queue file, requires lock.
+func (q *Queue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(queueFile{q.items, q.index, q.shuffle, q.repeat})
+	if err != nil {
+		return err
+	}
+	tmppath := q.path + ".tmp"
+	if err = os.WriteFile(tmppath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, q.path)
+}
+
+// LoadQueue load the queue file, an empty queue is returned if the file not
+// exists, or failed to parse and moved aside.
+func LoadQueue(path string) (*Queue, error) {
+	q := &Queue{
+		path:   path,
+		index:  -1,
+		played: map[int]bool{},
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || path == "" {
+		return q, nil
+	} else if err != nil {
+		q.path = ""
+		return q, err
+	}
+	file := queueFile{Index: -1}
+	if err = json.Unmarshal(data, &file); err != nil {
+		moved, err := utils.SetAsideCorrupt(path, err)
+		if !moved {
+			q.path = ""
+		}
+		return q, err
+	}
+	q.items = file.Items
+	q.shuffle = file.Shuffle
+	q.repeat = file.Repeat
+	if file.Index >= 0 && file.Index < len(q.items) {
+		q.index = file.Index
+		q.played[q.items[q.index].Track.Id] = true
+	}
+	return q, nil
+}
@@ -0,0 +1,105 @@
+package player
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Play queue view, lists the tracks to play, reorders and removes them.
+type QueueView struct {
+	queue *Queue
+	panel *Panel
+
+	contents   fyne.CanvasObject
+	summary    *widget.Label
+	shuffleBtn *widget.Button
+	repeatBtn  *widget.Button
+	clearBtn   *widget.Button
+	itemList   *widget.List
+
+	items []Item
+	index int
+}
+
+// Get the contents to show.
+func (v *QueueView) Contents() fyne.CanvasObject {
+	return v.contents
+}
+
+// Refresh show the queue again.
+func (v *QueueView) Refresh() {
+	v.items, v.index = v.queue.Items()
+	v.summary.SetText(fmt.Sprintf("共 %d 集", len(v.items)))
+	if v.queue.Shuffle() {
+		v.shuffleBtn.Importance = widget.HighImportance
+	} else {
+		v.shuffleBtn.Importance = widget.LowImportance
+	}
+	v.shuffleBtn.Refresh()
+	v.repeatBtn.SetText(v.queue.Repeat().String())
+	if len(v.items) > 0 {
+		v.clearBtn.Enable()
+	} else {
+		v.clearBtn.Disable()
+	}
+	v.itemList.Refresh()
+}
+
+func (v *QueueView) move(index int, rows int) {
+	v.queue.Move(index, index+rows)
+}
+
+func (v *QueueView) remove(index int) {
+	v.queue.Remove(index)
+}
+
+// NewQueueView create the play queue view of the panel, onBack is called when
+// the back button tapped.
+func NewQueueView(queue *Queue, panel *Panel, onBack func()) *QueueView {
+	view := new(QueueView)
+	view.queue = queue
+	view.panel = panel
+
+	view.summary = widget.NewLabel("")
+	view.shuffleBtn = widget.NewButton("随机播放", func() {
+		queue.SetShuffle(!queue.Shuffle())
+	})
+	view.repeatBtn = widget.NewButton("", func() {
+		queue.SetRepeat((queue.Repeat() + 1) % (RepeatOne + 1))
+	})
+	view.repeatBtn.Importance = widget.LowImportance
+	view.clearBtn = widget.NewButton("清空", queue.Clear)
+	view.clearBtn.Importance = widget.LowImportance
+	view.itemList = widget.NewList(
+		func() int {
+			return len(view.items)
+		},
+		func() fyne.CanvasObject {
+			return NewQueueViewItem(view.move, view.remove)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			if i < len(view.items) {
+				o.(*QueueViewItem).Update(i, view.items[i], i == view.index)
+			}
+		},
+	)
+	view.itemList.OnSelected = func(id widget.ListItemID) {
+		view.itemList.Unselect(id)
+		panel.PlayIndex(id)
+	}
+	backBtn := widget.NewButtonWithIcon("", theme.NavigateBackIcon(), onBack)
+	backBtn.Importance = widget.LowImportance
+	header := container.NewHBox(
+		backBtn, view.summary, layout.NewSpacer(), view.shuffleBtn, view.repeatBtn, view.clearBtn,
+	)
+	view.contents = container.NewBorder(header, nil, nil, nil, view.itemList)
+
+	view.Refresh()
+
+	return view
+}
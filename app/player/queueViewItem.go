@@ -0,0 +1,102 @@
+package player
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Handle to drag a queue row up or down.
+type DragHandle struct {
+	widget.Icon
+
+	// Vertical distance dragged.
+	dragged float32
+
+	// Called with the vertical distance when the drag ended.
+	OnDragEnd func(dy float32)
+}
+
+func (d *DragHandle) Dragged(ev *fyne.DragEvent) {
+	d.dragged += ev.Dragged.DY
+}
+
+func (d *DragHandle) DragEnd() {
+	dy := d.dragged
+	d.dragged = 0
+	if d.OnDragEnd != nil {
+		d.OnDragEnd(dy)
+	}
+}
+
+func NewDragHandle() *DragHandle {
+	handle := new(DragHandle)
+	handle.SetResource(theme.MenuIcon())
+	handle.ExtendBaseWidget(handle)
+	return handle
+}
+
+// Play queue list row.
+type QueueViewItem struct {
+	widget.BaseWidget
+
+	index int
+
+	handle    *DragHandle
+	indicator *widget.Icon
+	title     *widget.Label
+	removeBtn *widget.Button
+}
+
+func (q *QueueViewItem) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(
+		nil, nil, container.NewHBox(q.handle, q.indicator), q.removeBtn,
+		q.title,
+	))
+}
+
+// Update the item with a queue item at index, current is whether it is the
+// current item of the queue.
+func (q *QueueViewItem) Update(index int, item Item, current bool) {
+	q.index = index
+	q.title.SetText(item.Album.Title + " - " + item.Track.Name)
+	q.title.TextStyle = fyne.TextStyle{Bold: current}
+	q.title.Refresh()
+	if current {
+		q.indicator.SetResource(theme.MediaPlayIcon())
+	} else {
+		q.indicator.SetResource(nil)
+	}
+}
+
+// Create a play queue row, onMove is called with the index of the item and the
+// rows dragged, onRemove is called with the index of the item when the remove
+// button tapped.
+func NewQueueViewItem(onMove func(index int, rows int), onRemove func(index int)) *QueueViewItem {
+	item := &QueueViewItem{
+		handle:    NewDragHandle(),
+		indicator: widget.NewIcon(nil),
+		title:     widget.NewLabel(""),
+	}
+	item.handle.OnDragEnd = func(dy float32) {
+		height := item.Size().Height
+		if height <= 0 {
+			return
+		}
+		// Round to the nearest row.
+		rows := int(dy/height + 0.5)
+		if dy < 0 {
+			rows = int(dy/height - 0.5)
+		}
+		if rows != 0 {
+			onMove(item.index, rows)
+		}
+	}
+	item.removeBtn = widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		onRemove(item.index)
+	})
+	item.removeBtn.Importance = widget.LowImportance
+	item.ExtendBaseWidget(item)
+	return item
+}
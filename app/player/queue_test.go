@@ -0,0 +1,262 @@
+package player
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/utils"
+)
+
+// Items of tracks with the ids.
+func testItems(ids ...int) []Item {
+	items := make([]Item, len(ids))
+	for i, id := range ids {
+		items[i] = Item{Track: common.TrackInfo{Id: id}, Position: i + 1}
+	}
+	return items
+}
+
+func newTestQueue(t *testing.T, index int, ids ...int) *Queue {
+	t.Helper()
+	q, err := LoadQueue("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Replace(testItems(ids...), index)
+	return q
+}
+
+func currentId(q *Queue) int {
+	item, ok := q.Current()
+	if !ok {
+		return 0
+	}
+	return item.Track.Id
+}
+
+func nextId(q *Queue, manual bool) int {
+	item, ok := q.Next(manual)
+	if !ok {
+		return 0
+	}
+	return item.Track.Id
+}
+
+func TestQueueNext(t *testing.T) {
+	q := newTestQueue(t, 0, 1, 2, 3)
+	for _, want := range []int{2, 3, 0} {
+		if got := nextId(q, true); got != want {
+			t.Fatalf("next = %d, want %d", got, want)
+		}
+	}
+
+	q = newTestQueue(t, 2, 1, 2, 3)
+	q.SetRepeat(RepeatAll)
+	if got := nextId(q, true); got != 1 {
+		t.Errorf("next with repeat all = %d, want 1", got)
+	}
+	q.SetRepeat(RepeatOne)
+	if got := nextId(q, false); got != 1 {
+		t.Errorf("next with repeat one = %d, want 1", got)
+	}
+	if got := nextId(q, true); got != 2 {
+		t.Errorf("manual next with repeat one = %d, want 2", got)
+	}
+}
+
+func TestQueueRemoveCurrent(t *testing.T) {
+	q := newTestQueue(t, 1, 1, 2, 3)
+	q.Remove(1)
+	if got := currentId(q); got != 3 {
+		t.Errorf("current after removed = %d, want 3", got)
+	}
+	if !q.HasNext() || !q.HasPrevious() {
+		t.Error("no next or previous after removed")
+	}
+	// The item taking the place is played next, not skipped.
+	if got := nextId(q, false); got != 3 {
+		t.Errorf("next after removed = %d, want 3", got)
+	}
+	if got := nextId(q, false); got != 0 {
+		t.Errorf("next at the end = %d, want none", got)
+	}
+
+	q = newTestQueue(t, 0, 1, 2, 3)
+	q.Remove(0)
+	if got := currentId(q); got != 2 {
+		t.Errorf("current after the first removed = %d, want 2", got)
+	}
+	if got := nextId(q, true); got != 2 {
+		t.Errorf("next after the first removed = %d, want 2", got)
+	}
+
+	q = newTestQueue(t, 1, 1, 2, 3)
+	q.Remove(1)
+	if item, ok := q.Previous(); !ok || item.Track.Id != 1 {
+		t.Errorf("previous after removed = %v, want 1", item.Track.Id)
+	}
+}
+
+func TestQueueRemoveLastCurrent(t *testing.T) {
+	q := newTestQueue(t, 2, 1, 2, 3)
+	q.Remove(2)
+	if _, ok := q.Current(); ok {
+		t.Error("current after the last removed")
+	}
+	if q.HasNext() {
+		t.Error("next after the last removed")
+	}
+	if !q.HasPrevious() {
+		t.Error("no previous after the last removed")
+	}
+	q.Add(testItems(4)[0])
+	if got := nextId(q, false); got != 4 {
+		t.Errorf("next after added = %d, want 4", got)
+	}
+
+	q = newTestQueue(t, 2, 1, 2, 3)
+	q.Remove(2)
+	q.PlayNext(testItems(5)[0])
+	if got := nextId(q, false); got != 5 {
+		t.Errorf("next after play next = %d, want 5", got)
+	}
+
+	q = newTestQueue(t, 2, 1, 2, 3)
+	q.Remove(2)
+	if item, ok := q.Previous(); !ok || item.Track.Id != 2 {
+		t.Errorf("previous after the last removed = %v, want 2", item.Track.Id)
+	}
+}
+
+func TestQueueRemoveOthers(t *testing.T) {
+	q := newTestQueue(t, 2, 1, 2, 3, 4)
+	q.Remove(0)
+	if got := currentId(q); got != 3 {
+		t.Errorf("current after an earlier item removed = %d, want 3", got)
+	}
+	q.Remove(2)
+	if got := currentId(q); got != 3 {
+		t.Errorf("current after a later item removed = %d, want 3", got)
+	}
+	if q.HasNext() {
+		t.Error("next after the later item removed")
+	}
+}
+
+func TestQueueMoveAndPlayNext(t *testing.T) {
+	q := newTestQueue(t, 1, 1, 2, 3, 4)
+	q.Move(1, 3)
+	if items, index := q.Items(); index != 3 || items[3].Track.Id != 2 {
+		t.Errorf("moved current at %d, want 3", index)
+	}
+	q.Move(0, 3)
+	if items, index := q.Items(); index != 2 || items[2].Track.Id != 2 {
+		t.Errorf("current at %d after another moved, want 2", index)
+	}
+
+	q = newTestQueue(t, 0, 1, 2, 3)
+	q.PlayNext(testItems(3)[0])
+	if got := nextId(q, true); got != 3 {
+		t.Errorf("next after play next = %d, want 3", got)
+	}
+}
+
+func TestQueueShuffle(t *testing.T) {
+	q := newTestQueue(t, 0, 1, 2, 3, 4, 5)
+	q.SetShuffle(true)
+	seen := map[int]bool{currentId(q): true}
+	for i := 0; i < 4; i++ {
+		id := nextId(q, true)
+		if id == 0 || seen[id] {
+			t.Fatalf("shuffle next = %d, seen %v", id, seen)
+		}
+		seen[id] = true
+	}
+	if q.HasNext() {
+		t.Error("next after all played without repeat")
+	}
+	if got := nextId(q, true); got != 0 {
+		t.Errorf("shuffle next after all played = %d, want none", got)
+	}
+
+	// A new round starts with repeat, HasNext doesn't start it.
+	q.SetRepeat(RepeatAll)
+	played := len(q.played)
+	if !q.HasNext() {
+		t.Error("no next with repeat all")
+	}
+	if len(q.played) != played {
+		t.Error("HasNext changed the shuffle round")
+	}
+	if got := nextId(q, true); got == 0 {
+		t.Error("no shuffle next with repeat all")
+	}
+}
+
+func TestQueueConcurrentHasNext(t *testing.T) {
+	q := newTestQueue(t, 0, 1, 2, 3)
+	q.SetShuffle(true)
+	q.SetRepeat(RepeatAll)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				q.HasNext()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				q.Next(true)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestQueueSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := LoadQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Replace(testItems(1, 2, 3), 1)
+	q.SetRepeat(RepeatAll)
+
+	loaded, err := LoadQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, index := loaded.Items(); len(items) != 3 || index != 1 || loaded.Repeat() != RepeatAll {
+		t.Errorf("loaded %d items at %d repeat %v", len(items), index, loaded.Repeat())
+	}
+}
+
+func TestLoadQueueCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	if err := os.WriteFile(path, []byte(`{"items": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q, err := LoadQueue(path)
+	if err == nil {
+		t.Fatal("corrupt queue loaded without error")
+	}
+	if data, err := os.ReadFile(path + utils.CorruptSuffix); err != nil || string(data) != `{"items": [` {
+		t.Fatalf("corrupt queue not moved aside: %q, %v", data, err)
+	}
+	// The empty queue saves in place of the moved file.
+	q.Add(testItems(1)[0])
+	loaded, err := LoadQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := loaded.Items(); len(items) != 1 {
+		t.Errorf("saved %d items, want 1", len(items))
+	}
+}
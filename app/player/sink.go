@@ -0,0 +1,74 @@
+package player
+
+import (
+	"sync"
+	"time"
+)
+
+// Bytes of a sample frame, 16-bit little endian stereo.
+const FrameSize = 4
+
+// Sink plays 16-bit little endian stereo PCM.
+type Sink interface {
+	// Open prepare the output for the sample rate, called before writing a
+	// track.
+	Open(sampleRate int) error
+	// Write queue the samples, blocks while the output buffer is full.
+	Write(pcm []byte) (int, error)
+	Close() error
+}
+
+// NullSink discards the samples, used without an audio device.
+type NullSink struct {
+	// Whether to block writes for the duration of the samples as a real
+	// device does.
+	Realtime bool
+
+	lock       sync.Mutex
+	sampleRate int
+	// Bytes written since opened.
+	written int64
+}
+
+func (s *NullSink) Open(sampleRate int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sampleRate = sampleRate
+	s.written = 0
+	return nil
+}
+
+func (s *NullSink) Write(pcm []byte) (int, error) {
+	s.lock.Lock()
+	s.written += int64(len(pcm))
+	sampleRate := s.sampleRate
+	s.lock.Unlock()
+
+	if s.Realtime && sampleRate > 0 {
+		time.Sleep(bytesToDuration(int64(len(pcm)), sampleRate))
+	}
+	return len(pcm), nil
+}
+
+// Written returns the bytes written since opened.
+func (s *NullSink) Written() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.written
+}
+
+func (s *NullSink) Close() error {
+	return nil
+}
+
+// Duration of PCM bytes.
+func bytesToDuration(n int64, sampleRate int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(sampleRate*FrameSize)
+}
+
+// PCM bytes of a duration, aligned to frames.
+func durationToBytes(d time.Duration, sampleRate int) int64 {
+	return int64(d) * int64(sampleRate) / int64(time.Second) * FrameSize
+}
@@ -0,0 +1,137 @@
+package player
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const (
+	MinSpeed = 0.5
+	MaxSpeed = 3.0
+)
+
+// Stretcher changes the speed of stereo PCM keeping the pitch, with WSOLA
+// (waveform similarity overlap-add): segments of the input are picked around
+// the nominal positions at the speed, where the waveform best continues the
+// previous segment, and overlap-added with a Hann window.
+type stretcher struct {
+	// Segment length, overlap (the output hop) and search range in frames.
+	segment int
+	overlap int
+	search  int
+	window  []float32
+
+	// Buffered input samples, interleaved stereo.
+	input []float32
+	// Nominal input position of the next segment in frames.
+	next float64
+	// Input position of the previous segment, -1 if none.
+	prev int
+	// Windowed second half of the previous segment to overlap.
+	tail []float32
+}
+
+func newStretcher(sampleRate int) *stretcher {
+	// 40ms segments, searched in 10ms.
+	segment := sampleRate * 40 / 1000 &^ 1
+	s := &stretcher{
+		segment: segment,
+		overlap: segment / 2,
+		search:  sampleRate * 10 / 1000,
+		window:  make([]float32, segment),
+		tail:    make([]float32, segment),
+	}
+	for i := range s.window {
+		s.window[i] = float32(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(segment)))
+	}
+	s.reset()
+	return s
+}
+
+// Drop the buffered input, called after seeking.
+func (s *stretcher) reset() {
+	s.input = s.input[:0]
+	s.next = 0
+	s.prev = -1
+	for i := range s.tail {
+		s.tail[i] = 0
+	}
+}
+
+// Mono sample of a frame in the input.
+func (s *stretcher) mono(frame int) float32 {
+	return s.input[frame*2] + s.input[frame*2+1]
+}
+
+// Find the segment position in [lo, hi] whose beginning is most similar to
+// the input at natural, the continuation of the previous segment.
+func (s *stretcher) bestPosition(natural int, lo int, hi int) int {
+	best, bestScore := lo, math.Inf(-1)
+	// Coarse steps are good enough for speech and keep it cheap.
+	for pos := lo; pos <= hi; pos += 2 {
+		corr, energy := 0.0, 1e-9
+		for i := 0; i < s.overlap; i += 4 {
+			a, b := float64(s.mono(natural+i)), float64(s.mono(pos+i))
+			corr += a * b
+			energy += b * b
+		}
+		if score := corr / math.Sqrt(energy); score > bestScore {
+			best, bestScore = pos, score
+		}
+	}
+	return best
+}
+
+// Process the PCM at the speed, returns the stretched PCM. The output lags
+// behind the input by a segment.
+func (s *stretcher) process(pcm []byte, speed float64) []byte {
+	for i := 0; i+1 < len(pcm); i += 2 {
+		s.input = append(s.input, float32(int16(binary.LittleEndian.Uint16(pcm[i:]))))
+	}
+	frames := len(s.input) / 2
+
+	out := []float32{}
+	for {
+		nominal := int(s.next)
+		pos := nominal
+		if s.prev >= 0 {
+			lo, hi := nominal-s.search, nominal+s.search
+			if lo < 0 {
+				lo = 0
+			}
+			if hi+s.segment > frames {
+				break
+			}
+			pos = s.bestPosition(s.prev+s.overlap, lo, hi)
+		} else if nominal+s.segment > frames {
+			break
+		}
+		for i := 0; i < s.overlap*2; i++ {
+			out = append(out, s.tail[i]+s.input[pos*2+i]*s.window[i/2])
+		}
+		for i := 0; i < s.overlap*2; i++ {
+			s.tail[i] = s.input[(pos+s.overlap)*2+i] * s.window[s.overlap+i/2]
+		}
+		s.prev = pos
+		s.next += float64(s.overlap) * speed
+	}
+
+	// Drop the input not needed by the next segment.
+	drop := s.prev + s.overlap
+	if next := int(s.next) - s.search; next < drop {
+		drop = next
+	}
+	if s.prev >= 0 && drop > 0 {
+		n := copy(s.input, s.input[drop*2:])
+		s.input = s.input[:n]
+		s.prev -= drop
+		s.next -= float64(drop)
+	}
+
+	result := make([]byte, len(out)*2)
+	for i, sample := range out {
+		sample = float32(math.Max(math.MinInt16, math.Min(math.MaxInt16, float64(sample))))
+		binary.LittleEndian.PutUint16(result[i*2:], uint16(int16(sample)))
+	}
+	return result
+}
@@ -0,0 +1,521 @@
+package player
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/mytheme"
+	"xmlymft-fyne-gui/utils"
+)
+
+// Interval to update the position shown while playing.
+const positionUpdateInterval = time.Millisecond * 500
+
+// Interval to save the position while playing.
+const positionSaveInterval = time.Second * 5
+
+// A track to play.
+type Item struct {
+	Album common.AlbumInfo `json:"album"`
+	Track common.TrackInfo `json:"track"`
+	// Position in the album play list, starts from 1.
+	Position int `json:"position"`
+}
+
+// Custom slider with a fixed width.
+type SliderWithFixedWidth struct {
+	widget.Slider
+
+	FixedWidth float32
+}
+
+func (s *SliderWithFixedWidth) MinSize() fyne.Size {
+	s.ExtendBaseWidget(s)
+	return fyne.NewSize(s.FixedWidth, s.Slider.MinSize().Height)
+}
+
+// Player panel with the playing controls, shown at the bottom of the window.
+type Panel struct {
+	appwin      fyne.Window
+	player      *Player
+	queue       *Queue
+	positions   *Positions
+	preferences *Preferences
+	resolve     func(item Item) (Source, error)
+
+	contents     fyne.CanvasObject
+	title        *widget.Label
+	playBtn      *widget.Button
+	prevBtn      *widget.Button
+	nextBtn      *widget.Button
+	seekSlider   *widget.Slider
+	timeLabel    *widget.Label
+	volumeIcon   *widget.Icon
+	volumeSlider *SliderWithFixedWidth
+	speedBtn     *widget.Button
+	sleepBtn     *widget.Button
+	queueBtn     *widget.Button
+
+	// Serialize refreshing the controls.
+	refreshLock sync.Mutex
+
+	// The panel is used from the UI, the player, the ticker and the MPRIS
+	// goroutines.
+	lock sync.Mutex
+	// The loaded track, nil if none.
+	playing *Item
+	// Increased when a track loaded, a resolved or finished track of an
+	// older load is ignored.
+	loads int
+	// The load already finished, a track may be finished by the outro skip
+	// and by the player at once.
+	finished int
+	// Last time the position saved.
+	savedAt time.Time
+	// Time to pause by the sleep timer, zero if not set.
+	sleepAt time.Time
+	// Whether to stop at the end of the playing track by the sleep timer.
+	sleepAtEnd bool
+
+	// Called when the queue button tapped.
+	OnOpenQueue func()
+	// Called when the panel refreshed, the state or position may changed.
+	OnChanged func()
+}
+
+// Get the contents to show.
+func (p *Panel) Contents() fyne.CanvasObject {
+	return p.contents
+}
+
+// PlayList replace the queue with the items and play the item at index.
+func (p *Panel) PlayList(items []Item, index int) {
+	if item, ok := p.queue.Replace(items, index); ok {
+		p.play(item)
+	}
+}
+
+// PlayIndex play the item at index of the queue.
+func (p *Panel) PlayIndex(index int) {
+	if item, ok := p.queue.Select(index); ok {
+		p.play(item)
+	}
+}
+
+// PlayNext put a track after the playing one in the queue, played now if
+// nothing loaded.
+func (p *Panel) PlayNext(item Item) {
+	p.queue.PlayNext(item)
+	p.playIfIdle()
+}
+
+// AddToQueue add a track to the end of the queue, played now if nothing
+// loaded.
+func (p *Panel) AddToQueue(item Item) {
+	p.queue.Add(item)
+	p.playIfIdle()
+}
+
+// Play the next item of the queue if nothing loaded.
+func (p *Panel) playIfIdle() {
+	if _, loaded := p.Playing(); !loaded {
+		p.Next()
+	} else {
+		p.Refresh()
+	}
+}
+
+// Next play the next track of the queue.
+func (p *Panel) Next() {
+	if item, ok := p.queue.Next(true); ok {
+		p.play(item)
+	}
+}
+
+// Previous play the previous track of the queue.
+func (p *Panel) Previous() {
+	if item, ok := p.queue.Previous(); ok {
+		p.play(item)
+	}
+}
+
+// Toggle pause or play.
+func (p *Panel) Toggle() {
+	switch p.player.State() {
+	case Playing:
+		p.player.Pause()
+		p.savePosition()
+	case Paused:
+		p.player.Play()
+	case Stopped:
+		if item, ok := p.Playing(); ok {
+			p.play(item)
+		} else if item, ok := p.queue.Current(); ok {
+			p.play(item)
+		}
+	}
+	p.Refresh()
+}
+
+// Play resume or play the loaded track.
+func (p *Panel) Play() {
+	if p.player.State() != Playing {
+		p.Toggle()
+	}
+}
+
+// Pause the playing track.
+func (p *Panel) Pause() {
+	if p.player.State() == Playing {
+		p.Toggle()
+	}
+}
+
+// Stop save the position and stop playing, Play starts the track again.
+func (p *Panel) Stop() {
+	p.savePosition()
+	p.player.Stop()
+	p.Refresh()
+}
+
+// Seek to the position of the loaded track.
+func (p *Panel) Seek(position time.Duration) {
+	if p.player.State() == Stopped {
+		return
+	}
+	if position < 0 {
+		position = 0
+	}
+	p.player.Seek(position)
+	p.Refresh()
+}
+
+// SetVolume set the volume in [0, 1].
+func (p *Panel) SetVolume(volume float64) {
+	p.volumeSlider.SetValue(math.Max(0, math.Min(1, volume)))
+}
+
+// SetSpeed set the playback speed of the playing track, not saved for the
+// album.
+func (p *Panel) SetSpeed(speed float64) {
+	p.player.SetSpeed(speed)
+	p.Refresh()
+}
+
+// Playing returns the loaded track, false if none.
+func (p *Panel) Playing() (Item, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.playing == nil {
+		return Item{}, false
+	}
+	return *p.playing, true
+}
+
+// Player returns the player of the panel.
+func (p *Panel) Player() *Player {
+	return p.player
+}
+
+// HasNext whether there is a next track to play.
+func (p *Panel) HasNext() bool {
+	return p.queue.HasNext()
+}
+
+// HasPrevious whether there is a previous track to play.
+func (p *Panel) HasPrevious() bool {
+	return p.queue.HasPrevious()
+}
+
+// Save the position of the playing track.
+func (p *Panel) savePosition() {
+	item, ok := p.Playing()
+	if !ok || p.player.State() == Stopped {
+		return
+	}
+	p.lock.Lock()
+	p.savedAt = time.Now()
+	p.lock.Unlock()
+	position, duration := p.player.Position()
+	if err := p.positions.Update(item, position, duration); err != nil {
+		log.Printf("save playback position: %s", err)
+	}
+}
+
+// Mark the playing track finished and play the next, unless the sleep timer
+// stops at the end of the track.
+func (p *Panel) finish() {
+	p.lock.Lock()
+	if p.playing == nil || p.finished == p.loads {
+		p.lock.Unlock()
+		return
+	}
+	p.finished = p.loads
+	item := *p.playing
+	stop := p.sleepAtEnd
+	p.sleepAtEnd = false
+	p.lock.Unlock()
+
+	_, duration := p.player.Position()
+	// Stop the skipped outro.
+	p.player.Stop()
+	if err := p.positions.Finish(item, duration); err != nil {
+		log.Printf("save playback position: %s", err)
+	}
+	if !stop {
+		if next, ok := p.queue.Next(false); ok {
+			p.play(next)
+		}
+	}
+	p.Refresh()
+}
+
+// Preferences of the album of the playing track.
+func (p *Panel) albumPreferences() (int, AlbumPreferences) {
+	item, ok := p.Playing()
+	if !ok {
+		return 0, NewAlbumPreferences()
+	}
+	return item.Album.Id, p.preferences.Get(item.Album.Id)
+}
+
+// Get the sleep timer, the time to pause, zero if not set, and whether to
+// stop at the end of the playing track.
+func (p *Panel) sleepTimer() (time.Time, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.sleepAt, p.sleepAtEnd
+}
+
+// Set the sleep timer.
+func (p *Panel) setSleepTimer(at time.Time, atEnd bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.sleepAt, p.sleepAtEnd = at, atEnd
+}
+
+// Check the sleep timer and the outro to skip while playing.
+func (p *Panel) checkPlaying() {
+	p.lock.Lock()
+	sleep := !p.sleepAt.IsZero() && time.Now().After(p.sleepAt)
+	if sleep {
+		p.sleepAt = time.Time{}
+	}
+	p.lock.Unlock()
+	if sleep {
+		p.player.Pause()
+		p.savePosition()
+		return
+	}
+	_, prefs := p.albumPreferences()
+	position, duration := p.player.Position()
+	if prefs.SkipOutro > 0 && duration > prefs.Outro() && position >= duration-prefs.Outro() {
+		p.finish()
+	}
+}
+
+// Show the item loaded.
+func (p *Panel) showItem(item Item) {
+	p.title.SetText(item.Album.Title + " - " + item.Track.Name)
+	p.contents.Show()
+}
+
+// Play an item, its address is resolved now as the address may expire.
+func (p *Panel) play(item Item) {
+	p.savePosition()
+	p.lock.Lock()
+	p.playing = &item
+	p.loads++
+	load := p.loads
+	p.lock.Unlock()
+	p.showItem(item)
+	p.Refresh()
+
+	prefs := p.preferences.Get(item.Album.Id)
+	p.player.SetSpeed(prefs.Speed)
+	// Resume an unfinished track, after the intro to skip.
+	start := prefs.Intro()
+	if position, ok := p.positions.Get(item.Track.Id); ok && !position.Finished && position.Time() > start {
+		start = position.Time()
+	}
+	go func() {
+		source, err := p.resolve(item)
+		p.lock.Lock()
+		// Another track played while resolving.
+		stale := p.loads != load
+		p.lock.Unlock()
+		if stale {
+			return
+		}
+		if err == nil {
+			err = p.player.Load(source, start)
+		}
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("%s: %w", item.Track.Name, err), p.appwin)
+		}
+		p.Refresh()
+	}()
+}
+
+// Close save the playback position and close the player.
+func (p *Panel) Close() error {
+	p.savePosition()
+	return p.player.Close()
+}
+
+// Refresh show the player state and position.
+func (p *Panel) Refresh() {
+	p.refreshLock.Lock()
+	state := p.player.State()
+	if state == Playing {
+		p.playBtn.SetIcon(theme.MediaPauseIcon())
+	} else {
+		p.playBtn.SetIcon(theme.MediaPlayIcon())
+	}
+	if p.queue.HasPrevious() {
+		p.prevBtn.Enable()
+	} else {
+		p.prevBtn.Disable()
+	}
+	if p.queue.HasNext() {
+		p.nextBtn.Enable()
+	} else {
+		p.nextBtn.Disable()
+	}
+
+	p.speedBtn.SetText(formatSpeed(p.player.Speed()))
+	sleepAt, sleepAtEnd := p.sleepTimer()
+	if !sleepAt.IsZero() {
+		p.sleepBtn.SetText(utils.FormatDuration(time.Until(sleepAt)))
+	} else if sleepAtEnd {
+		p.sleepBtn.SetText("本集")
+	} else {
+		p.sleepBtn.SetText("")
+	}
+
+	position, duration := p.player.Position()
+	p.timeLabel.SetText(utils.FormatDuration(position) + "/" + utils.FormatDuration(duration))
+	// Set without calling OnChanged, which seeks when dragged.
+	p.seekSlider.Max = duration.Seconds()
+	p.seekSlider.Value = position.Seconds()
+	p.seekSlider.Refresh()
+	p.refreshLock.Unlock()
+
+	if p.OnChanged != nil {
+		p.OnChanged()
+	}
+}
+
+// NewPanel create a player panel playing the queue, positions keeps the
+// playback positions, preferences keeps the album playback preferences,
+// resolve returns the source of a track to play.
+func NewPanel(
+	window fyne.Window, player *Player, queue *Queue, positions *Positions, preferences *Preferences,
+	resolve func(item Item) (Source, error),
+) *Panel {
+	panel := new(Panel)
+	panel.appwin = window
+	panel.player = player
+	panel.queue = queue
+	panel.positions = positions
+	panel.preferences = preferences
+	panel.resolve = resolve
+
+	panel.title = widget.NewLabel("")
+	panel.title.Wrapping = fyne.TextTruncate
+	panel.playBtn = widget.NewButtonWithIcon("", theme.MediaPlayIcon(), panel.Toggle)
+	panel.playBtn.Importance = widget.LowImportance
+	panel.prevBtn = widget.NewButtonWithIcon("", theme.MediaSkipPreviousIcon(), panel.Previous)
+	panel.prevBtn.Importance = widget.LowImportance
+	panel.nextBtn = widget.NewButtonWithIcon("", theme.MediaSkipNextIcon(), panel.Next)
+	panel.nextBtn.Importance = widget.LowImportance
+	panel.seekSlider = widget.NewSlider(0, 1)
+	panel.seekSlider.Step = 1
+	panel.seekSlider.OnChanged = func(value float64) {
+		player.Seek(time.Duration(value * float64(time.Second)))
+	}
+	panel.timeLabel = widget.NewLabel("")
+	panel.volumeIcon = widget.NewIcon(theme.VolumeUpIcon())
+	panel.volumeSlider = &SliderWithFixedWidth{FixedWidth: 80.0 * mytheme.Factor}
+	panel.volumeSlider.Min = 0
+	panel.volumeSlider.Max = 1
+	panel.volumeSlider.Step = 0.05
+	panel.volumeSlider.Value = player.Volume()
+	panel.volumeSlider.OnChanged = func(value float64) {
+		player.SetVolume(value)
+		if value == 0 {
+			panel.volumeIcon.SetResource(theme.VolumeMuteIcon())
+		} else {
+			panel.volumeIcon.SetResource(theme.VolumeUpIcon())
+		}
+	}
+
+	panel.speedBtn = widget.NewButton("1x", panel.showAlbumPreferencesDialog)
+	panel.speedBtn.Importance = widget.LowImportance
+	panel.sleepBtn = widget.NewButtonWithIcon("", theme.HistoryIcon(), panel.showSleepTimerDialog)
+	panel.sleepBtn.Importance = widget.LowImportance
+	panel.queueBtn = widget.NewButtonWithIcon("", theme.ListIcon(), func() {
+		if panel.OnOpenQueue != nil {
+			panel.OnOpenQueue()
+		}
+	})
+	panel.queueBtn.Importance = widget.LowImportance
+
+	panel.contents = container.NewVBox(
+		widget.NewSeparator(),
+		container.NewBorder(
+			nil, nil, nil,
+			container.NewHBox(panel.speedBtn, panel.sleepBtn, panel.volumeIcon, panel.volumeSlider),
+			panel.title,
+		),
+		container.NewBorder(
+			nil, nil,
+			container.NewHBox(panel.prevBtn, panel.playBtn, panel.nextBtn),
+			container.NewHBox(panel.timeLabel, panel.queueBtn),
+			panel.seekSlider,
+		),
+	)
+	// Shown when a track played, or the restored queue has a current track.
+	if item, ok := queue.Current(); ok {
+		panel.playing = &item
+		panel.showItem(item)
+		panel.Refresh()
+	} else {
+		panel.contents.Hide()
+	}
+
+	player.OnFinished = panel.finish
+	player.OnError = func(err error) {
+		dialog.ShowError(err, window)
+		panel.Refresh()
+	}
+	go func() {
+		for range time.Tick(positionUpdateInterval) {
+			if panel.contents.Visible() && player.State() == Playing {
+				panel.checkPlaying()
+				panel.Refresh()
+				panel.lock.Lock()
+				save := time.Since(panel.savedAt) >= positionSaveInterval
+				panel.lock.Unlock()
+				if save {
+					panel.savePosition()
+				}
+			}
+		}
+	}()
+
+	return panel
+}
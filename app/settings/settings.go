@@ -0,0 +1,121 @@
+package settings
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const SettingsFilePath = "./settings.json"
+
+// Default file naming template.
+const DefaultNameTemplate = "{album}/{track}.{ext}"
+
+const DefaultRetryAttempts = 3
+const DefaultRetryBackoff = 1000
+const DefaultRetryJitter = 0.2
+
+const DefaultMaxDownloads = 3
+const DefaultMaxDownloadsPerHost = 2
+
+const DefaultFeedPort = 8090
+
+const DefaultMinFreeSpace = 500
+
+// GUI settings, saved beside the server configuration.
+type Settings struct {
+	// Download root directory, the working directory if empty.
+	DownloadDir string `json:"downloadDir"`
+	// Downloaded file path template relative to the download root.
+	NameTemplate string `json:"nameTemplate"`
+
+	// Download attempts of a track, including the first one.
+	RetryAttempts int `json:"retryAttempts"`
+	// Delay before the first retry in milliseconds, doubled for each retry.
+	RetryBackoff int `json:"retryBackoff"`
+	// Random factor in [0, 1] applied to the retry delay.
+	RetryJitter float64 `json:"retryJitter"`
+
+	// Max number of tracks downloading at the same time.
+	MaxDownloads int `json:"maxDownloads"`
+	// Max number of downloads from the same host, unlimited if 0.
+	MaxDownloadsPerHost int `json:"maxDownloadsPerHost"`
+	// Total download speed limit in KB/s, unlimited if 0.
+	RateLimit int `json:"rateLimit"`
+	// Min free space in MB of the download volume, downloads are paused
+	// below it, unchecked if 0.
+	MinFreeSpace int `json:"minFreeSpace"`
+
+	// Whether to write a playlist of all downloaded tracks in the download
+	// root, beside the album playlists.
+	GlobalPlaylist bool `json:"globalPlaylist"`
+
+	// Whether to save the streamed tracks into the download directory while
+	// playing.
+	CachePlayback bool `json:"cachePlayback"`
+
+	// Whether to serve the downloaded albums as podcast feeds on the LAN.
+	FeedServer bool `json:"feedServer"`
+	// Port of the podcast feed server.
+	FeedPort int `json:"feedPort"`
+}
+
+func (p *Settings) Save() error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(SettingsFilePath, data, 0644)
+}
+
+// Get the download root directory.
+func (p *Settings) DownloadRoot() (string, error) {
+	if p.DownloadDir != "" {
+		return p.DownloadDir, nil
+	}
+	return os.Getwd()
+}
+
+func NewSettings() *Settings {
+	return &Settings{
+		NameTemplate:  DefaultNameTemplate,
+		RetryAttempts: DefaultRetryAttempts,
+		RetryBackoff:  DefaultRetryBackoff,
+		RetryJitter:   DefaultRetryJitter,
+
+		MaxDownloads:        DefaultMaxDownloads,
+		MaxDownloadsPerHost: DefaultMaxDownloadsPerHost,
+		MinFreeSpace:        DefaultMinFreeSpace,
+
+		CachePlayback: true,
+
+		FeedPort: DefaultFeedPort,
+	}
+}
+
+// GetSettings load the settings file, missing fields are set to default.
+func GetSettings() (*Settings, error) {
+	settings := NewSettings()
+
+	data, err := os.ReadFile(SettingsFilePath)
+	if os.IsNotExist(err) {
+		return settings, settings.Save()
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, settings); err != nil {
+		return nil, err
+	}
+	if settings.NameTemplate == "" {
+		settings.NameTemplate = DefaultNameTemplate
+	}
+	if settings.RetryAttempts < 1 {
+		settings.RetryAttempts = 1
+	}
+	if settings.MaxDownloads < 1 {
+		settings.MaxDownloads = 1
+	}
+	if settings.FeedPort <= 0 || settings.FeedPort > 65535 {
+		settings.FeedPort = DefaultFeedPort
+	}
+	return settings, nil
+}
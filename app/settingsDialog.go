@@ -0,0 +1,135 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/validation"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"xmlymft-fyne-gui/app/download"
+	"xmlymft-fyne-gui/app/settings"
+)
+
+// Create an entry of a non-negative number.
+func newNumberEntry(value float64) *widget.Entry {
+	entry := widget.NewEntry()
+	entry.SetText(strconv.FormatFloat(value, 'f', -1, 64))
+	entry.Validator = validation.NewRegexp(`^\d+(\.\d+)?$`, "Must be a number")
+	return entry
+}
+
+// Parse the text of a number entry, 0 if invalid.
+func parseNumber(text string) float64 {
+	value, _ := strconv.ParseFloat(text, 64)
+	return value
+}
+
+// Show the settings dialog, onSaved is called with the saved settings.
+func showSettingsDialog(
+	window fyne.Window,
+	current settings.Settings,
+	onSaved func(saved settings.Settings),
+) {
+	downloadDirEntry := widget.NewEntry()
+	downloadDirEntry.SetText(current.DownloadDir)
+	downloadDirEntry.SetPlaceHolder("当前目录")
+	downloadDirEntry.Validator = func(s string) error {
+		if s == "" {
+			return nil
+		}
+		info, err := os.Stat(s)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", s)
+		}
+		return nil
+	}
+	browseBtn := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
+			if err == nil && uri != nil {
+				downloadDirEntry.SetText(uri.Path())
+			}
+		}, window)
+	})
+
+	nameTemplateEntry := widget.NewEntry()
+	nameTemplateEntry.SetText(current.NameTemplate)
+	nameTemplateEntry.Validator = download.ValidateTemplate
+
+	retryAttemptsEntry := newNumberEntry(float64(current.RetryAttempts))
+	retryBackoffEntry := newNumberEntry(float64(current.RetryBackoff) / 1000)
+	retryJitterEntry := newNumberEntry(current.RetryJitter * 100)
+	maxDownloadsEntry := newNumberEntry(float64(current.MaxDownloads))
+	maxDownloadsPerHostEntry := newNumberEntry(float64(current.MaxDownloadsPerHost))
+	rateLimitEntry := newNumberEntry(float64(current.RateLimit))
+	minFreeSpaceEntry := newNumberEntry(float64(current.MinFreeSpace))
+	globalPlaylistCheck := widget.NewCheck("下载目录中生成全部音频的播放列表", nil)
+	globalPlaylistCheck.SetChecked(current.GlobalPlaylist)
+	cachePlaybackCheck := widget.NewCheck("在线播放时保存到下载目录", nil)
+	cachePlaybackCheck.SetChecked(current.CachePlayback)
+	feedServerCheck := widget.NewCheck("启用", nil)
+	feedServerCheck.SetChecked(current.FeedServer)
+	feedPortEntry := newNumberEntry(float64(current.FeedPort))
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("下载目录", container.NewBorder(nil, nil, nil, browseBtn, downloadDirEntry)),
+		{
+			Text:     "文件命名",
+			Widget:   nameTemplateEntry,
+			HintText: "{album} {albumId} {track} {trackId} {index:04} {order} {ext}",
+		},
+		{Text: "下载次数", Widget: retryAttemptsEntry, HintText: "失败后自动重试, 包括第一次下载"},
+		{Text: "重试间隔", Widget: retryBackoffEntry, HintText: "秒, 每次重试加倍"},
+		{Text: "随机间隔", Widget: retryJitterEntry, HintText: "%, 重试间隔的随机浮动"},
+		{Text: "同时下载", Widget: maxDownloadsEntry},
+		{Text: "单站点下载", Widget: maxDownloadsPerHostEntry, HintText: "同一服务器同时下载数, 0 为不限制"},
+		{Text: "限速", Widget: rateLimitEntry, HintText: "KB/s, 0 为不限速"},
+		{Text: "保留空间", Widget: minFreeSpaceEntry, HintText: "MB, 磁盘剩余空间低于此值时暂停下载, 0 为不检查"},
+		{Text: "播放列表", Widget: globalPlaylistCheck, HintText: "每个专辑目录总是生成播放列表"},
+		{Text: "边听边存", Widget: cachePlaybackCheck, HintText: "播放完整的音频可离线收听"},
+		{
+			Text:     "播客订阅",
+			Widget:   container.NewBorder(nil, nil, feedServerCheck, nil, feedPortEntry),
+			HintText: "端口, 局域网设备用播客应用订阅 http://本机IP:端口/",
+		},
+	}
+	dlg := dialog.NewForm("设置", "保存", "取消", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		saved := current
+		saved.DownloadDir = downloadDirEntry.Text
+		saved.NameTemplate = nameTemplateEntry.Text
+		saved.RetryAttempts = int(math.Max(1, parseNumber(retryAttemptsEntry.Text)))
+		saved.RetryBackoff = int(parseNumber(retryBackoffEntry.Text) * 1000)
+		saved.RetryJitter = math.Min(1, parseNumber(retryJitterEntry.Text)/100)
+		saved.MaxDownloads = int(math.Max(1, parseNumber(maxDownloadsEntry.Text)))
+		saved.MaxDownloadsPerHost = int(parseNumber(maxDownloadsPerHostEntry.Text))
+		saved.RateLimit = int(parseNumber(rateLimitEntry.Text))
+		saved.MinFreeSpace = int(parseNumber(minFreeSpaceEntry.Text))
+		saved.GlobalPlaylist = globalPlaylistCheck.Checked
+		saved.CachePlayback = cachePlaybackCheck.Checked
+		saved.FeedServer = feedServerCheck.Checked
+		if port := int(parseNumber(feedPortEntry.Text)); port > 0 && port <= 65535 {
+			saved.FeedPort = port
+		}
+		if err := saved.Save(); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if onSaved != nil {
+			onSaved(saved)
+		}
+	}, window)
+	dlg.Resize(fyne.NewSize(window.Canvas().Size().Width, dlg.MinSize().Height))
+	dlg.Show()
+}
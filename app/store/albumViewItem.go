@@ -1,15 +1,98 @@
 package store
 
 import (
+	"fmt"
+
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/mytheme"
+	"xmlymft-fyne-gui/app/player"
 )
 
+// Album list row, shows the album title and album actions.
 type AlbumViewItem struct {
 	widget.BaseWidget
 
-	cover fyne.Resource
-	title string
-	// TODO: collect to favorite icon.
-	collectIcon widget.Icon
+	album        common.AlbumInfo
+	cover        fyne.Resource
+	title        *widget.Label
+	continueBtn  *widget.Button
+	favoriteBtn  *widget.Button
+	subscribeBtn *widget.Button
+	downloadBtn  *widget.Button
+}
+
+func (a *AlbumViewItem) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(
+		nil, nil, nil, container.NewHBox(a.continueBtn, a.favoriteBtn, a.subscribeBtn, a.downloadBtn),
+		a.title,
+	))
+}
+
+// Update the item with an album, whether the album is a favorite, whether the
+// album is subscribed and the playback position of the last played track of
+// the album, nil if never played.
+func (a *AlbumViewItem) Update(album common.AlbumInfo, favorite bool, subscribed bool, last *player.TrackPosition) {
+	a.album = album
+	a.title.SetText(album.Title)
+	if last != nil {
+		a.continueBtn.SetText(fmt.Sprintf("继续第 %d 集", last.Item.Position))
+		a.continueBtn.Show()
+	} else {
+		a.continueBtn.Hide()
+	}
+	if favorite {
+		a.favoriteBtn.SetIcon(mytheme.StarIcon())
+	} else {
+		a.favoriteBtn.SetIcon(mytheme.StarBorderIcon())
+	}
+	if subscribed {
+		a.subscribeBtn.SetIcon(theme.CheckButtonCheckedIcon())
+	} else {
+		a.subscribeBtn.SetIcon(theme.CheckButtonIcon())
+	}
+}
+
+// Create an album list row, onContinue, onFavorite, onSubscribe and onDownload
+// are called when the continue listening, favorite, subscribe and download
+// button tapped.
+func NewAlbumViewItem(
+	onContinue func(album common.AlbumInfo),
+	onFavorite func(album common.AlbumInfo),
+	onSubscribe func(album common.AlbumInfo),
+	onDownload func(album common.AlbumInfo),
+) *AlbumViewItem {
+	item := &AlbumViewItem{
+		title: widget.NewLabel(""),
+	}
+	item.continueBtn = widget.NewButtonWithIcon("", theme.MediaPlayIcon(), func() {
+		if onContinue != nil {
+			onContinue(item.album)
+		}
+	})
+	item.continueBtn.Importance = widget.LowImportance
+	item.favoriteBtn = widget.NewButtonWithIcon("", mytheme.StarBorderIcon(), func() {
+		if onFavorite != nil {
+			onFavorite(item.album)
+		}
+	})
+	item.favoriteBtn.Importance = widget.LowImportance
+	item.subscribeBtn = widget.NewButtonWithIcon("", theme.CheckButtonIcon(), func() {
+		if onSubscribe != nil {
+			onSubscribe(item.album)
+		}
+	})
+	item.subscribeBtn.Importance = widget.LowImportance
+	item.downloadBtn = widget.NewButtonWithIcon("", theme.DownloadIcon(), func() {
+		if onDownload != nil {
+			onDownload(item.album)
+		}
+	})
+	item.downloadBtn.Importance = widget.LowImportance
+	item.ExtendBaseWidget(item)
+	return item
 }
@@ -3,11 +3,7 @@ package store
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strconv"
 	"sync"
 
@@ -16,10 +12,15 @@ import (
 	"fyne.io/fyne/v2/data/validation"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"github.com/funte/xmlymft/common"
 
+	"xmlymft-fyne-gui/app/download"
+	"xmlymft-fyne-gui/app/favorite"
 	"xmlymft-fyne-gui/app/mytheme"
+	"xmlymft-fyne-gui/app/player"
+	"xmlymft-fyne-gui/app/subscription"
 	"xmlymft-fyne-gui/utils"
 )
 
@@ -56,8 +57,16 @@ type Store struct {
 	pageJump  *EntryWithFixedWidth
 	pageDown  *widget.Button
 	pageEnd   *widget.Button
+	// Download the whole album in track view.
+	downloadAlbumBtn *widget.Button
+	// Aggregate download progress.
+	downloadProgress *widget.ProgressBar
 
-	serverURL string
+	serverURL     string
+	downloader    *download.Manager
+	subscriptions *subscription.Watcher
+	positions     *player.Positions
+	favorites     *favorite.Favorites
 
 	lock             sync.RWMutex
 	currentPageNum   uint
@@ -65,13 +74,19 @@ type Store struct {
 	// Current albums to show.
 	currentKeyword string
 	currentAlbums  *[]common.AlbumInfo
-	// Current album and its track list to show.
-	currentAlbumIndex uint
-	currentTracks     *[]common.TrackInfo
+	// Current album and its track list to show, the album may be not in the
+	// current albums.
+	currentAlbum  common.AlbumInfo
+	currentTracks *[]common.TrackInfo
 	// Album search result cache: keyword -> page -> SearchAlbumResult.
 	albumsCache map[string]map[uint]common.SearchAlbumResult
 	// Album track list cache: albumId -> page -> QueryPlayListResult.
 	tracksCache map[int]map[uint]common.QueryPlayListResult
+
+	// Called to play the track at index of the tracks of the current page.
+	OnPlay func(album common.AlbumInfo, tracks []download.AlbumTrack, index int)
+	// Called to queue a track, next is whether to play it after the playing one.
+	OnQueue func(album common.AlbumInfo, track download.AlbumTrack, next bool)
 }
 
 // Search search albums by a keyword and page number.
@@ -79,6 +94,21 @@ func (s *Store) Search(keyword string, page uint) error {
 	return s.showAlbumView(keyword, page)
 }
 
+// ShowAlbum show the play list of an album, e.g. a favorite album not in the
+// search result, the search result is kept.
+func (s *Store) ShowAlbum(album common.AlbumInfo) error {
+	return s.showTrackView(album, 1)
+}
+
+// Refresh the album or track list shown.
+func (s *Store) Refresh() {
+	if s.isShowAlbums() {
+		s.albumViewList.Refresh()
+	} else if s.isShowPlayList() {
+		s.trackViewList.Refresh()
+	}
+}
+
 // Get the contents to show.
 func (s *Store) Contents() fyne.CanvasObject {
 	s.albumViewList.Hide()
@@ -87,48 +117,6 @@ func (s *Store) Contents() fyne.CanvasObject {
 	return s.contents
 }
 
-func (s *Store) downloadTrack(index uint) error {
-	currentAlbumInfo := (*s.currentAlbums)[s.currentAlbumIndex]
-	subcache := s.tracksCache[currentAlbumInfo.Id]
-	currentTrackInfo := subcache[s.currentPageNum].Tracks[index]
-	trackId := strconv.Itoa(currentTrackInfo.Id)
-
-	// Query the track download address.
-	url := fmt.Sprintf("%s/track?id=%s", s.serverURL, trackId)
-	// trackAddressResp, err := utils.HTTPGet[utils.QueryTrackAddressResponse](url)
-	trackAddressResp, err := utils.HTTPGetQueryTrackAddressResponse(url)
-	if err != nil {
-		return err
-	}
-	if trackAddressResp.Error != "" {
-		return errors.New(trackAddressResp.Error)
-	}
-	queryTrackAddressResult := trackAddressResp.Data
-
-	wd, err := os.Getwd()
-	if err != nil {
-		return err
-	}
-	albumpath := filepath.Clean(filepath.Join(wd, currentAlbumInfo.Title))
-	os.Mkdir(albumpath, 0644)
-	trackname := currentTrackInfo.Name + "." + queryTrackAddressResult.Type
-	trackpath := filepath.Clean(filepath.Join(albumpath, trackname))
-	// If track file exists.
-	if _, err = os.Stat(trackpath); err == nil {
-		return nil
-	}
-	// Download and write.
-	downloadResp, err := http.Get(queryTrackAddressResult.Address)
-	if err != nil {
-		return err
-	}
-	data, err := ioutil.ReadAll(downloadResp.Body)
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(trackpath, data, 0644)
-}
-
 func (s *Store) isShowAlbums() bool {
 	return !s.albumViewList.Hidden
 }
@@ -142,7 +130,7 @@ func (s *Store) jumpFirstPage() {
 	if s.isShowAlbums() {
 		err = s.showAlbumView(s.currentKeyword, 1)
 	} else if s.isShowPlayList() {
-		err = s.showTrackView(s.currentAlbumIndex, 1)
+		err = s.showTrackView(s.currentAlbum, 1)
 	}
 	if err != nil {
 		dialog.ShowError(err, s.appwin)
@@ -154,7 +142,7 @@ func (s *Store) jumpPreviewPage() {
 	if s.isShowAlbums() {
 		err = s.showAlbumView(s.currentKeyword, s.currentPageNum-1)
 	} else if s.isShowPlayList() {
-		err = s.showTrackView(s.currentAlbumIndex, s.currentPageNum-1)
+		err = s.showTrackView(s.currentAlbum, s.currentPageNum-1)
 	}
 	if err != nil {
 		dialog.ShowError(err, s.appwin)
@@ -166,7 +154,7 @@ func (s *Store) jumpPage(page uint) {
 	if s.isShowAlbums() {
 		err = s.showAlbumView(s.currentKeyword, page)
 	} else if s.isShowPlayList() {
-		err = s.showTrackView(s.currentAlbumIndex, page)
+		err = s.showTrackView(s.currentAlbum, page)
 	}
 	if err != nil {
 		dialog.ShowError(err, s.appwin)
@@ -178,7 +166,7 @@ func (s *Store) jumpNextPage() {
 	if s.isShowAlbums() {
 		err = s.showAlbumView(s.currentKeyword, s.currentPageNum+1)
 	} else if s.isShowPlayList() {
-		err = s.showTrackView(s.currentAlbumIndex, s.currentPageNum+1)
+		err = s.showTrackView(s.currentAlbum, s.currentPageNum+1)
 	}
 	if err != nil {
 		dialog.ShowError(err, s.appwin)
@@ -190,7 +178,7 @@ func (s *Store) jumpEndpage() {
 	if s.isShowAlbums() {
 		err = s.showAlbumView(s.currentKeyword, s.currentTotalPage)
 	} else if s.isShowPlayList() {
-		err = s.showTrackView(s.currentAlbumIndex, s.currentTotalPage)
+		err = s.showTrackView(s.currentAlbum, s.currentTotalPage)
 	}
 	if err != nil {
 		dialog.ShowError(err, s.appwin)
@@ -247,60 +235,272 @@ func (s *Store) showAlbumView(keyword string, page uint) error {
 	return nil
 }
 
-func (s *Store) showTrackView(albumIndex uint, page uint) error {
+func (s *Store) showTrackView(album common.AlbumInfo, page uint) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	s.currentAlbumIndex = albumIndex
+	s.currentAlbum = album
 	s.currentTracks = nil
 
 	// Query play list.
-	currentAlbumInfo := (*s.currentAlbums)[albumIndex]
-	subcache, cached := s.tracksCache[currentAlbumInfo.Id]
+	queryPlayListResult, err := s.queryPlayList(album, page)
+	if err != nil {
+		return err
+	}
+
+	// Hide album view.
+	s.albumViewList.Hide()
+	// Show play list view.
+	s.currentTracks = &queryPlayListResult.Tracks
+	s.trackViewList.Refresh()
+	s.trackViewList.Show()
+
+	// Update navigator.
+	s.currentPageNum = uint(queryPlayListResult.PageNum)
+	s.currentTotalPage = uint(album.TracksCount) / DefaultPlayListPageSize
+	if uint(album.TracksCount)%DefaultPlayListPageSize != 0 {
+		s.currentTotalPage += 1
+	}
+	s.updateNavigator()
+
+	return nil
+}
+
+// Query a page of the album play list, requires lock.
+func (s *Store) queryPlayList(album common.AlbumInfo, page uint) (common.QueryPlayListResult, error) {
+	subcache, cached := s.tracksCache[album.Id]
 	if !cached {
 		subcache = map[uint]common.QueryPlayListResult{}
-		s.tracksCache[currentAlbumInfo.Id] = subcache
+		s.tracksCache[album.Id] = subcache
 	}
 	queryPlayListResult, cached := subcache[page]
 	if !cached {
 		params := url.Values{}
-		params.Add("id", strconv.Itoa(currentAlbumInfo.Id))
+		params.Add("id", strconv.Itoa(album.Id))
 		params.Add("pageNum", strconv.Itoa(int(page)))
 		params.Add("pageSize", strconv.Itoa(int(DefaultPlayListPageSize)))
 		url := fmt.Sprintf("%s/play?%s", s.serverURL, params.Encode())
 		// resp, err := utils.HTTPGet[utils.QueryPlayListResponse](url)
 		resp, err := utils.HTTPGetQueryPlayListResponse(url)
 		if err != nil {
-			return err
+			return queryPlayListResult, err
 		}
 		if resp.Error != "" {
-			return errors.New(resp.Error)
+			return queryPlayListResult, errors.New(resp.Error)
 		}
 		queryPlayListResult = resp.Data
 		subcache[page] = queryPlayListResult
 	}
+	return queryPlayListResult, nil
+}
 
-	// Hide album view.
-	s.albumViewList.Hide()
-	// Show play list view.
-	s.currentTracks = &queryPlayListResult.Tracks
-	s.trackViewList.Refresh()
-	s.trackViewList.Show()
+// Query the tracks of an album whose position in the play list is in
+// [from, to], positions start from 1.
+func (s *Store) queryAlbumTracks(album common.AlbumInfo, from uint, to uint) ([]download.AlbumTrack, error) {
+	tracks := []download.AlbumTrack{}
+	if from < 1 {
+		from = 1
+	}
+	if to < from {
+		return tracks, nil
+	}
+	firstPage := (from-1)/DefaultPlayListPageSize + 1
+	lastPage := (to-1)/DefaultPlayListPageSize + 1
+	for page := firstPage; page <= lastPage; page++ {
+		s.lock.Lock()
+		result, err := s.queryPlayList(album, page)
+		s.lock.Unlock()
+		if err != nil {
+			return tracks, err
+		}
+		if len(result.Tracks) == 0 {
+			break
+		}
+		for i, track := range result.Tracks {
+			position := (page-1)*DefaultPlayListPageSize + uint(i) + 1
+			if position >= from && position <= to {
+				tracks = append(tracks, download.AlbumTrack{Track: track, Position: int(position)})
+			}
+		}
+	}
+	return tracks, nil
+}
 
-	// Update navigator.
-	s.currentPageNum = uint(queryPlayListResult.PageNum)
-	s.currentTotalPage = uint(currentAlbumInfo.TracksCount) / DefaultPlayListPageSize
-	if uint(currentAlbumInfo.TracksCount)%DefaultPlayListPageSize != 0 {
-		s.currentTotalPage += 1
+// Download the tracks of an album, tracks already on disk are skipped.
+func (s *Store) downloadAlbum(album common.AlbumInfo, tracks []download.AlbumTrack) {
+	queued, skipped := s.downloader.EnqueueAll(album, tracks)
+	message := fmt.Sprintf("已添加 %d 个下载, 跳过 %d 个已下载", queued, skipped)
+	dialog.ShowInformation(album.Title, message, s.appwin)
+}
+
+// Ask the track range then download the album, warns if the estimated size
+// not fit in the free space.
+func (s *Store) showDownloadAlbumDialog(album common.AlbumInfo) {
+	fromEntry := widget.NewEntry()
+	fromEntry.SetText("1")
+	fromEntry.Validator = validation.NewRegexp(`^\d+$`, "Must be a number")
+	toEntry := widget.NewEntry()
+	toEntry.SetText(strconv.Itoa(album.TracksCount))
+	toEntry.Validator = validation.NewRegexp(`^\d+$`, "Must be a number")
+	items := []*widget.FormItem{
+		widget.NewFormItem("从第", fromEntry),
+		widget.NewFormItem("到第", toEntry),
 	}
-	s.updateNavigator()
+	title := fmt.Sprintf("下载专辑 (共 %d 集)", album.TracksCount)
+	dialog.ShowForm(title, "下载", "取消", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		from, _ := strconv.Atoi(fromEntry.Text)
+		to, _ := strconv.Atoi(toEntry.Text)
+		go func() {
+			tracks, err := s.queryAlbumTracks(album, uint(from), uint(to))
+			if err != nil {
+				dialog.ShowError(err, s.appwin)
+				return
+			}
+			_, err = s.downloader.CheckSpace(s.downloader.EstimateSize(tracks))
+			if errors.Is(err, download.ErrLowSpace) {
+				message := fmt.Sprintf("%s, 仍然下载?", err)
+				dialog.ShowConfirm("磁盘空间不足", message, func(ok bool) {
+					if ok {
+						s.downloadAlbum(album, tracks)
+					}
+				}, s.appwin)
+				return
+			}
+			// Download anyway if failed to get the free space.
+			s.downloadAlbum(album, tracks)
+		}()
+	}, s.appwin)
+}
 
-	return nil
+// Play a track of the current page.
+func (s *Store) playTrack(index int) {
+	if s.OnPlay == nil {
+		return
+	}
+	s.lock.RLock()
+	album := s.currentAlbum
+	tracks := make([]download.AlbumTrack, len(*s.currentTracks))
+	for i, track := range *s.currentTracks {
+		position := (s.currentPageNum-1)*DefaultPlayListPageSize + uint(i) + 1
+		tracks[i] = download.AlbumTrack{Track: track, Position: int(position)}
+	}
+	s.lock.RUnlock()
+	s.OnPlay(album, tracks, index)
+}
+
+// Queue a track of the current page.
+func (s *Store) queueTrack(index int, next bool) {
+	if s.OnQueue == nil {
+		return
+	}
+	s.lock.RLock()
+	if s.currentTracks == nil || index >= len(*s.currentTracks) {
+		s.lock.RUnlock()
+		return
+	}
+	album := s.currentAlbum
+	position := (s.currentPageNum-1)*DefaultPlayListPageSize + uint(index) + 1
+	track := download.AlbumTrack{Track: (*s.currentTracks)[index], Position: int(position)}
+	s.lock.RUnlock()
+	s.OnQueue(album, track, next)
+}
+
+// Continue listening the last played track of an album.
+func (s *Store) continueAlbum(album common.AlbumInfo) {
+	last, ok := s.positions.Last(album.Id)
+	if !ok {
+		return
+	}
+	page := uint(last.Item.Position-1)/DefaultPlayListPageSize + 1
+	if err := s.showTrackView(album, page); err != nil {
+		dialog.ShowError(err, s.appwin)
+		return
+	}
+	s.lock.RLock()
+	trackIndex := -1
+	for i, track := range *s.currentTracks {
+		if track.Id == last.Item.Track.Id {
+			trackIndex = i
+		}
+	}
+	s.lock.RUnlock()
+	if trackIndex >= 0 {
+		s.playTrack(trackIndex)
+	}
+}
+
+// Add an album to favorites or remove it.
+func (s *Store) toggleFavoriteAlbum(album common.AlbumInfo) {
+	var err error
+	if s.favorites.HasAlbum(album.Id) {
+		err = s.favorites.RemoveAlbum(album.Id)
+	} else {
+		err = s.favorites.AddAlbum(album)
+	}
+	if err != nil {
+		dialog.ShowError(err, s.appwin)
+	}
+}
+
+// Add a track of the current page to favorites or remove it.
+func (s *Store) toggleFavoriteTrack(index int) {
+	s.lock.RLock()
+	if s.currentTracks == nil || index >= len(*s.currentTracks) {
+		s.lock.RUnlock()
+		return
+	}
+	album := s.currentAlbum
+	track := (*s.currentTracks)[index]
+	position := (s.currentPageNum-1)*DefaultPlayListPageSize + uint(index) + 1
+	s.lock.RUnlock()
+
+	var err error
+	if s.favorites.HasTrack(track.Id) {
+		err = s.favorites.RemoveTrack(track.Id)
+	} else {
+		err = s.favorites.AddTrack(album, track, int(position))
+	}
+	if err != nil {
+		dialog.ShowError(err, s.appwin)
+	}
+}
+
+// Subscribe or unsubscribe an album.
+func (s *Store) toggleSubscription(album common.AlbumInfo) {
+	if s.subscriptions.IsSubscribed(album.Id) {
+		message := fmt.Sprintf("取消订阅 %s?", album.Title)
+		dialog.ShowConfirm("取消订阅", message, func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := s.subscriptions.Unsubscribe(album.Id); err != nil {
+				dialog.ShowError(err, s.appwin)
+			}
+		}, s.appwin)
+		return
+	}
+	go func() {
+		if err := s.subscriptions.Subscribe(album); err != nil {
+			dialog.ShowError(err, s.appwin)
+			return
+		}
+		message := "已订阅, 新发布的节目将自动下载"
+		dialog.ShowInformation(album.Title, message, s.appwin)
+	}()
 }
 
 func (s *Store) updateNavigator() {
 	jumpPageText := DefaultPageJumpText
 
+	if s.isShowPlayList() {
+		s.downloadAlbumBtn.Show()
+	} else {
+		s.downloadAlbumBtn.Hide()
+	}
+
 	s.pageFirst.Disable()
 	s.pageUp.Disable()
 	s.pageJump.Disable()
@@ -337,10 +537,40 @@ func (s *Store) updateNavigator() {
 	s.pageJump.SetPlaceHolder(jumpPageText)
 }
 
-func NewStore(window fyne.Window, serverURL string) *Store {
+// Show the download progress of tracks and the aggregate progress.
+func (s *Store) updateDownloadProgress() {
+	if s.isShowPlayList() {
+		s.trackViewList.Refresh()
+	}
+
+	active, received, total := s.downloader.Progress()
+	if active == 0 {
+		s.downloadProgress.Hide()
+		return
+	}
+	progress := 0.0
+	if total > 0 {
+		progress = float64(received) / float64(total)
+	}
+	s.downloadProgress.TextFormatter = func() string {
+		return fmt.Sprintf("%d 个下载中 %.0f%%", active, progress*100)
+	}
+	s.downloadProgress.SetValue(progress)
+	s.downloadProgress.Show()
+}
+
+func NewStore(
+	window fyne.Window, serverURL string,
+	downloader *download.Manager, subscriptions *subscription.Watcher,
+	positions *player.Positions, favorites *favorite.Favorites,
+) *Store {
 	store := new(Store)
 	store.appwin = window
 	store.serverURL = serverURL
+	store.downloader = downloader
+	store.subscriptions = subscriptions
+	store.positions = positions
+	store.favorites = favorites
 
 	// Create album list.
 	store.albumViewList = widget.NewList(
@@ -351,15 +581,29 @@ func NewStore(window fyne.Window, serverURL string) *Store {
 			return len(*store.currentAlbums)
 		},
 		func() fyne.CanvasObject {
-			return widget.NewLabel("")
+			return NewAlbumViewItem(
+				store.continueAlbum, store.toggleFavoriteAlbum, store.toggleSubscription, store.showDownloadAlbumDialog,
+			)
 		},
 		func(i widget.ListItemID, o fyne.CanvasObject) {
 			if store.currentAlbums != nil {
-				o.(*widget.Label).SetText((*store.currentAlbums)[i].Title)
+				album := (*store.currentAlbums)[i]
+				var last *player.TrackPosition
+				if position, ok := positions.Last(album.Id); ok {
+					last = &position
+				}
+				o.(*AlbumViewItem).Update(
+					album, favorites.HasAlbum(album.Id), store.subscriptions.IsSubscribed(album.Id), last,
+				)
 			}
 		},
 	)
-	store.albumViewList.OnSelected = func(id int) { store.showTrackView(uint(id), 1) }
+	store.albumViewList.OnSelected = func(id int) {
+		store.lock.RLock()
+		album := (*store.currentAlbums)[id]
+		store.lock.RUnlock()
+		store.showTrackView(album, 1)
+	}
 	// Create track list.
 	store.trackViewList = widget.NewList(
 		func() int {
@@ -369,21 +613,32 @@ func NewStore(window fyne.Window, serverURL string) *Store {
 			return len(*store.currentTracks)
 		},
 		func() fyne.CanvasObject {
-			return widget.NewLabel("")
+			return NewTrackViewItem(store.playTrack, store.toggleFavoriteTrack, store.queueTrack)
 		},
 		func(i widget.ListItemID, o fyne.CanvasObject) {
 			if store.currentTracks != nil {
-				o.(*widget.Label).SetText((*store.currentTracks)[i].Name)
+				track := (*store.currentTracks)[i]
+				var position *player.TrackPosition
+				if trackPosition, ok := positions.Get(track.Id); ok {
+					position = &trackPosition
+				}
+				var job *download.Job
+				if trackJob, ok := store.downloader.Job(track.Id); ok {
+					job = &trackJob
+				}
+				o.(*TrackViewItem).Update(i, track, favorites.HasTrack(track.Id), position, job)
 			}
 		},
 	)
 	store.trackViewList.OnSelected = func(id int) {
-		go func() {
-			err := store.downloadTrack(uint(id))
-			if err != nil {
-				dialog.ShowError(err, store.appwin)
-			}
-		}()
+		store.lock.RLock()
+		album := store.currentAlbum
+		track := (*store.currentTracks)[id]
+		position := (store.currentPageNum-1)*DefaultPlayListPageSize + uint(id) + 1
+		store.lock.RUnlock()
+		store.downloader.Enqueue(album, track, int(position))
+		// Allow clicking the same track again.
+		store.trackViewList.Unselect(id)
 	}
 	store.view = container.NewMax(store.albumViewList, store.trackViewList)
 
@@ -407,9 +662,22 @@ func NewStore(window fyne.Window, serverURL string) *Store {
 	store.pageDown.Importance = widget.LowImportance
 	store.pageEnd = widget.NewButton("尾页", func() { store.jumpEndpage() })
 	store.pageEnd.Importance = widget.LowImportance
-	store.navigator = container.NewHBox(
-		layout.NewSpacer(),
-		store.pageFirst, store.pageUp, store.pageJump, store.pageDown, store.pageEnd,
+	store.downloadProgress = widget.NewProgressBar()
+	store.downloadProgress.Hide()
+	store.downloadAlbumBtn = widget.NewButtonWithIcon("全部", theme.DownloadIcon(), func() {
+		store.lock.RLock()
+		album := store.currentAlbum
+		store.lock.RUnlock()
+		store.showDownloadAlbumDialog(album)
+	})
+	store.downloadAlbumBtn.Importance = widget.LowImportance
+	store.navigator = container.NewBorder(
+		nil, nil, store.downloadAlbumBtn,
+		container.NewHBox(
+			layout.NewSpacer(),
+			store.pageFirst, store.pageUp, store.pageJump, store.pageDown, store.pageEnd,
+		),
+		store.downloadProgress,
 	)
 
 	store.contents = container.NewBorder(nil, store.navigator, nil, nil, store.view)
@@ -417,5 +685,9 @@ func NewStore(window fyne.Window, serverURL string) *Store {
 	store.albumsCache = map[string]map[uint]common.SearchAlbumResult{}
 	store.tracksCache = map[int]map[uint]common.QueryPlayListResult{}
 
+	downloader.AddListener(store.updateDownloadProgress)
+	subscriptions.OnChanged = store.albumViewList.Refresh
+	positions.OnChanged = store.Refresh
+
 	return store
 }
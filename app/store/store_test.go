@@ -0,0 +1,103 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"fyne.io/fyne/v2/test"
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/download"
+	"xmlymft-fyne-gui/app/favorite"
+	"xmlymft-fyne-gui/app/player"
+	"xmlymft-fyne-gui/app/settings"
+	"xmlymft-fyne-gui/app/subscription"
+)
+
+// Start a server searching two albums, every album has a track of its id.
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": common.SearchAlbumResult{
+				PageNum:   1,
+				TotalPage: 1,
+				Albums:    []common.AlbumInfo{{Id: 1, Title: "三体"}, {Id: 2, Title: "球状闪电"}},
+			},
+		})
+	})
+	mux.HandleFunc("/play", func(w http.ResponseWriter, r *http.Request) {
+		albumId, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": common.QueryPlayListResult{
+				PageNum: 1,
+				Tracks:  []common.TrackInfo{{Id: albumId * 100, Name: "第1集"}},
+			},
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	test.NewApp()
+	serverURL := newTestServer(t)
+	records, _ := download.LoadRecords("")
+	cfg := *settings.NewSettings()
+	cfg.DownloadDir = t.TempDir()
+	downloader := download.NewManager(serverURL, cfg, records)
+	subscriptions, _ := subscription.NewWatcher(serverURL, "", downloader)
+	positions, _ := player.LoadPositions("")
+	favorites, _ := favorite.LoadFavorites("")
+	s := NewStore(test.NewWindow(nil), serverURL, downloader, subscriptions, positions, favorites)
+	s.Contents()
+	return s
+}
+
+func TestShowAlbumKeepsSearchResult(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Search("刘慈欣", 1); err != nil {
+		t.Fatal(err)
+	}
+	// A favorite album not in the search result.
+	favorite := common.AlbumInfo{Id: 3, Title: "流浪地球", TracksCount: 1}
+	if err := s.ShowAlbum(favorite); err != nil {
+		t.Fatal(err)
+	}
+	if !s.isShowPlayList() {
+		t.Fatal("track view not shown")
+	}
+
+	var played common.AlbumInfo
+	s.OnPlay = func(album common.AlbumInfo, tracks []download.AlbumTrack, index int) {
+		played = album
+	}
+	s.playTrack(0)
+	if played.Id != favorite.Id {
+		t.Errorf("played album %d, want %d", played.Id, favorite.Id)
+	}
+
+	s.lock.RLock()
+	albums := *s.currentAlbums
+	keyword := s.currentKeyword
+	s.lock.RUnlock()
+	if len(albums) != 2 || albums[0].Id != 1 || keyword != "刘慈欣" {
+		t.Errorf("search result %v of %q, want the 2 albums searched", albums, keyword)
+	}
+
+	// Back to the search result and open an album of it.
+	if err := s.Search(keyword, 1); err != nil {
+		t.Fatal(err)
+	}
+	s.albumViewList.OnSelected(1)
+	s.playTrack(0)
+	if played.Id != 2 {
+		t.Errorf("played album %d, want 2", played.Id)
+	}
+}
@@ -1,14 +1,145 @@
 package store
 
 import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/download"
+	"xmlymft-fyne-gui/app/mytheme"
+	"xmlymft-fyne-gui/app/player"
+	"xmlymft-fyne-gui/utils"
 )
 
+// Custom progress bar with a fixed width.
+type ProgressBarWithFixedWidth struct {
+	widget.ProgressBar
+
+	FixedWidth float32
+}
+
+func (p *ProgressBarWithFixedWidth) MinSize() fyne.Size {
+	p.ExtendBaseWidget(p)
+	return fyne.NewSize(p.FixedWidth, p.ProgressBar.MinSize().Height)
+}
+
+// Track list row, shows the track name and its download progress.
 type TrackViewItem struct {
 	widget.BaseWidget
 
-	title string
-	// TODO: play selected track icon.
-	playIcon     widget.Icon
-	downloadIcon widget.Icon
+	index int
+
+	title        *widget.Label
+	playBtn      *widget.Button
+	favoriteBtn  *widget.Button
+	moreBtn      *widget.Button
+	downloadIcon *widget.Icon
+	listened     *widget.Label
+	status       *widget.Label
+	progress     *ProgressBarWithFixedWidth
+}
+
+func (t *TrackViewItem) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(
+		nil, nil, container.NewHBox(t.playBtn, t.downloadIcon), container.NewHBox(t.listened, t.status, t.progress, t.favoriteBtn, t.moreBtn),
+		t.title,
+	))
+}
+
+// Update the item with a track at index of the list, whether it is a
+// favorite, its playback position and its download job, position is nil if
+// never played, job is nil if the track is not downloaded.
+func (t *TrackViewItem) Update(
+	index int, track common.TrackInfo, favorite bool, position *player.TrackPosition, job *download.Job,
+) {
+	t.index = index
+	t.title.SetText(track.Name)
+	if favorite {
+		t.favoriteBtn.SetIcon(mytheme.StarIcon())
+	} else {
+		t.favoriteBtn.SetIcon(mytheme.StarBorderIcon())
+	}
+	if position == nil {
+		t.listened.Hide()
+	} else if position.Finished {
+		t.listened.SetText("已听完")
+		t.listened.Show()
+	} else {
+		t.listened.SetText("听到 " + utils.FormatDuration(position.Time()))
+		t.listened.Show()
+	}
+	if job == nil {
+		t.downloadIcon.SetResource(nil)
+		t.status.Hide()
+		t.progress.Hide()
+		return
+	}
+
+	switch job.State {
+	case download.JobDone:
+		t.downloadIcon.SetResource(theme.ConfirmIcon())
+	case download.JobFailed:
+		t.downloadIcon.SetResource(theme.ErrorIcon())
+	default:
+		t.downloadIcon.SetResource(theme.DownloadIcon())
+	}
+	t.status.SetText(job.StatusText())
+	t.status.Show()
+	if job.State == download.JobRunning {
+		t.progress.SetValue(job.Progress())
+		t.progress.Show()
+	} else {
+		t.progress.Hide()
+	}
+}
+
+// Show the menu to queue the track.
+func (t *TrackViewItem) showMenu(onQueue func(index int, next bool)) {
+	if onQueue == nil {
+		return
+	}
+	index := t.index
+	menu := fyne.NewMenu("",
+		fyne.NewMenuItem("下一首播放", func() { onQueue(index, true) }),
+		fyne.NewMenuItem("加入播放队列", func() { onQueue(index, false) }),
+	)
+	driver := fyne.CurrentApp().Driver()
+	position := driver.AbsolutePositionForObject(t.moreBtn).Add(fyne.NewPos(0, t.moreBtn.Size().Height))
+	widget.ShowPopUpMenuAtPosition(menu, driver.CanvasForObject(t.moreBtn), position)
+}
+
+// Create a track list row, onPlay and onFavorite are called with the index of
+// the track when the play and favorite button tapped, onQueue is called with
+// the index of the track and whether to play it next when queued from the
+// menu.
+func NewTrackViewItem(
+	onPlay func(index int), onFavorite func(index int), onQueue func(index int, next bool),
+) *TrackViewItem {
+	item := &TrackViewItem{
+		title:        widget.NewLabel(""),
+		downloadIcon: widget.NewIcon(nil),
+		listened:     widget.NewLabel(""),
+		status:       widget.NewLabel(""),
+		progress:     &ProgressBarWithFixedWidth{FixedWidth: 64.0 * mytheme.Factor},
+	}
+	item.playBtn = widget.NewButtonWithIcon("", theme.MediaPlayIcon(), func() {
+		if onPlay != nil {
+			onPlay(item.index)
+		}
+	})
+	item.playBtn.Importance = widget.LowImportance
+	item.favoriteBtn = widget.NewButtonWithIcon("", mytheme.StarBorderIcon(), func() {
+		if onFavorite != nil {
+			onFavorite(item.index)
+		}
+	})
+	item.favoriteBtn.Importance = widget.LowImportance
+	item.moreBtn = widget.NewButtonWithIcon("", theme.MoreVerticalIcon(), func() {
+		item.showMenu(onQueue)
+	})
+	item.moreBtn.Importance = widget.LowImportance
+	item.ExtendBaseWidget(item)
+	return item
 }
@@ -0,0 +1,343 @@
+package subscription
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"github.com/funte/xmlymft/common"
+
+	"xmlymft-fyne-gui/app/download"
+	"xmlymft-fyne-gui/utils"
+)
+
+const SubscriptionsFilePath = "./subscriptions.json"
+
+// Interval between checks of the subscribed albums.
+const CheckInterval = time.Minute * 30
+
+// Delay of the first check, waiting the server to start.
+const firstCheckDelay = time.Second * 10
+
+// Page size to query the album play list.
+const playListPageSize = 30
+
+// Max pages to query an album, in case the server keeps returning pages.
+const maxPlayListPages = 1000
+
+// A subscribed album.
+type Subscription struct {
+	Album common.AlbumInfo `json:"album"`
+	// Track ids seen in the album play list.
+	Seen []int `json:"seen"`
+	// Unix time of the last check.
+	CheckedAt int64 `json:"checkedAt"`
+}
+
+// Watcher keeps the subscribed albums, checks them periodically and
+// downloads the newly published tracks.
+type Watcher struct {
+	serverURL  string
+	path       string
+	downloader *download.Manager
+
+	lock sync.RWMutex
+	// albumId -> subscription.
+	subscriptions map[int]*Subscription
+	// Whether a check is running.
+	checking bool
+
+	done chan struct{}
+	// Called when subscriptions changed.
+	OnChanged func()
+}
+
+// IsSubscribed whether the album is subscribed.
+func (w *Watcher) IsSubscribed(albumId int) bool {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	_, ok := w.subscriptions[albumId]
+	return ok
+}
+
+// All returns the subscriptions sorted by album title.
+func (w *Watcher) All() []Subscription {
+	w.lock.RLock()
+	subscriptions := make([]Subscription, 0, len(w.subscriptions))
+	for _, subscription := range w.subscriptions {
+		subscriptions = append(subscriptions, *subscription)
+	}
+	w.lock.RUnlock()
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Album.Title < subscriptions[j].Album.Title
+	})
+	return subscriptions
+}
+
+// Subscribe an album, the tracks already published are marked as seen so only
+// the tracks published later are downloaded.
+func (w *Watcher) Subscribe(album common.AlbumInfo) error {
+	tracks, err := w.queryTracks(album)
+	if err != nil {
+		return err
+	}
+	subscription := &Subscription{
+		Album:     album,
+		Seen:      make([]int, len(tracks)),
+		CheckedAt: time.Now().Unix(),
+	}
+	for i, track := range tracks {
+		subscription.Seen[i] = track.Track.Id
+	}
+
+	w.lock.Lock()
+	w.subscriptions[album.Id] = subscription
+	err = w.save()
+	w.lock.Unlock()
+
+	w.changed()
+	return err
+}
+
+// UpdateAlbum replace the album info of a subscription, the seen tracks are
+// kept. Ignored if the album is not subscribed.
+func (w *Watcher) UpdateAlbum(album common.AlbumInfo) error {
+	w.lock.Lock()
+	subscription, ok := w.subscriptions[album.Id]
+	if !ok {
+		w.lock.Unlock()
+		return nil
+	}
+	updated := *subscription
+	updated.Album = album
+	w.subscriptions[album.Id] = &updated
+	err := w.save()
+	w.lock.Unlock()
+
+	w.changed()
+	return err
+}
+
+// Unsubscribe an album.
+func (w *Watcher) Unsubscribe(albumId int) error {
+	w.lock.Lock()
+	delete(w.subscriptions, albumId)
+	err := w.save()
+	w.lock.Unlock()
+
+	w.changed()
+	return err
+}
+
+// Check query the subscribed albums and download the new tracks, returns the
+// number of new tracks of each album title.
+func (w *Watcher) Check() (map[string]int, error) {
+	w.lock.Lock()
+	if w.checking {
+		w.lock.Unlock()
+		return nil, nil
+	}
+	w.checking = true
+	w.lock.Unlock()
+	defer func() {
+		w.lock.Lock()
+		w.checking = false
+		w.lock.Unlock()
+	}()
+
+	news := map[string]int{}
+	errs := []string{}
+	for _, subscription := range w.All() {
+		n, err := w.checkAlbum(subscription)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", subscription.Album.Title, err))
+			continue
+		}
+		if n > 0 {
+			news[subscription.Album.Title] = n
+		}
+	}
+	if len(errs) > 0 {
+		return news, errors.New(strings.Join(errs, "\n"))
+	}
+	return news, nil
+}
+
+// Check an album and download the new tracks, returns the number of new
+// tracks.
+func (w *Watcher) checkAlbum(subscription Subscription) (int, error) {
+	tracks, err := w.queryTracks(subscription.Album)
+	if err != nil {
+		return 0, err
+	}
+
+	w.lock.Lock()
+	// Unsubscribed while querying, nothing is downloaded.
+	current, ok := w.subscriptions[subscription.Album.Id]
+	if !ok {
+		w.lock.Unlock()
+		return 0, nil
+	}
+	// The subscription may be updated while querying, the news are merged
+	// into the current one.
+	seen := map[int]bool{}
+	for _, trackId := range current.Seen {
+		seen[trackId] = true
+	}
+	news := []download.AlbumTrack{}
+	for _, track := range tracks {
+		if !seen[track.Track.Id] {
+			news = append(news, track)
+			current.Seen = append(current.Seen, track.Track.Id)
+		}
+	}
+	current.CheckedAt = time.Now().Unix()
+	album := current.Album
+	err = w.save()
+	w.lock.Unlock()
+
+	// Enqueued without lock, the listeners notified may call the watcher.
+	// Tracks already downloaded or in the queue are skipped.
+	if len(news) > 0 {
+		w.downloader.EnqueueAll(album, news)
+	}
+	return len(news), err
+}
+
+// Query the whole play list of an album.
+func (w *Watcher) queryTracks(album common.AlbumInfo) ([]download.AlbumTrack, error) {
+	tracks := []download.AlbumTrack{}
+	for page := 1; page <= maxPlayListPages; page++ {
+		params := url.Values{}
+		params.Add("id", strconv.Itoa(album.Id))
+		params.Add("pageNum", strconv.Itoa(page))
+		params.Add("pageSize", strconv.Itoa(playListPageSize))
+		url := fmt.Sprintf("%s/play?%s", w.serverURL, params.Encode())
+		resp, err := utils.HTTPGetQueryPlayListResponse(url)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+		for _, track := range resp.Data.Tracks {
+			tracks = append(tracks, download.AlbumTrack{Track: track, Position: len(tracks) + 1})
+		}
+		if len(resp.Data.Tracks) < playListPageSize {
+			break
+		}
+	}
+	return tracks, nil
+}
+
+// Check the subscriptions and send a notification of the new tracks.
+func (w *Watcher) checkAndNotify() {
+	news, err := w.Check()
+	if err != nil {
+		log.Printf("check subscriptions: %s", err)
+	}
+	if len(news) == 0 {
+		return
+	}
+	titles := make([]string, 0, len(news))
+	for title := range news {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	lines := make([]string, len(titles))
+	for i, title := range titles {
+		lines[i] = fmt.Sprintf("%s: %d 集新节目", title, news[title])
+	}
+	fyne.CurrentApp().SendNotification(fyne.NewNotification("订阅更新", strings.Join(lines, "\n")))
+}
+
+// Start checking the subscriptions periodically until Stop called.
+func (w *Watcher) Start() {
+	go func() {
+		timer := time.NewTimer(firstCheckDelay)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				w.checkAndNotify()
+				timer.Reset(CheckInterval)
+			case <-w.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop checking the subscriptions.
+func (w *Watcher) Stop() {
+	close(w.done)
+}
+
+func (w *Watcher) changed() {
+	if w.OnChanged != nil {
+		w.OnChanged()
+	}
+}
+
+// Save the subscriptions file, requires lock.
+func (w *Watcher) save() error {
+	if w.path == "" {
+		return nil
+	}
+	subscriptions := make([]*Subscription, 0, len(w.subscriptions))
+	for _, subscription := range w.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	data, err := json.Marshal(subscriptions)
+	if err != nil {
+		return err
+	}
+	tmppath := w.path + ".tmp"
+	if err = os.WriteFile(tmppath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmppath, w.path)
+}
+
+// NewWatcher load the subscriptions file, no subscription if the file not
+// exists. A file failed to parse is moved aside, not saved to if failed to
+// read or move.
+func NewWatcher(serverURL string, path string, downloader *download.Manager) (*Watcher, error) {
+	w := &Watcher{
+		serverURL:     serverURL,
+		path:          path,
+		downloader:    downloader,
+		subscriptions: map[int]*Subscription{},
+		done:          make(chan struct{}),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return w, nil
+	} else if err != nil {
+		w.path = ""
+		return w, err
+	}
+	subscriptions := []*Subscription{}
+	if err = json.Unmarshal(data, &subscriptions); err != nil {
+		moved, err := utils.SetAsideCorrupt(path, err)
+		if !moved {
+			w.path = ""
+		}
+		return w, err
+	}
+	for _, subscription := range subscriptions {
+		w.subscriptions[subscription.Album.Id] = subscription
+	}
+	return w, nil
+}
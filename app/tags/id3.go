@@ -0,0 +1,118 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Size of the ID3v2 tag at the beginning of an mp3 file, including the
+// header and footer, 0 if no tag.
+func id3Size(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 10)
+	if _, err = io.ReadFull(file, header); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+	size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+	size += 10
+	// Footer present.
+	if header[3] == 4 && header[5]&0x10 != 0 {
+		size += 10
+	}
+	return size, nil
+}
+
+// Encode a string as a UTF-16 text with BOM and terminator.
+func id3Text(s string) []byte {
+	buf := bytes.NewBuffer([]byte{1, 0xff, 0xfe})
+	for _, u := range utf16.Encode([]rune(s)) {
+		buf.WriteByte(byte(u))
+		buf.WriteByte(byte(u >> 8))
+	}
+	buf.Write([]byte{0, 0})
+	return buf.Bytes()
+}
+
+// Append an ID3v2.3 frame.
+func appendID3Frame(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	// Flags.
+	buf.Write([]byte{0, 0})
+	buf.Write(data)
+}
+
+// Build an ID3v2.3 tag.
+func buildID3(meta Metadata) []byte {
+	frames := new(bytes.Buffer)
+	texts := []struct {
+		id    string
+		value string
+	}{
+		{"TIT2", meta.Title},
+		{"TALB", meta.Album},
+		{"TPE1", meta.Artist},
+		{"TPE2", meta.AlbumArtist},
+		{"TCON", meta.Genre},
+	}
+	for _, text := range texts {
+		if text.value != "" {
+			appendID3Frame(frames, text.id, id3Text(text.value))
+		}
+	}
+	if meta.Track > 0 {
+		track := strconv.Itoa(meta.Track)
+		if meta.TrackTotal > 0 {
+			track += "/" + strconv.Itoa(meta.TrackTotal)
+		}
+		appendID3Frame(frames, "TRCK", id3Text(track))
+	}
+	if meta.Year > 0 {
+		appendID3Frame(frames, "TYER", id3Text(strconv.Itoa(meta.Year)))
+	}
+	if len(meta.Cover) > 0 {
+		picture := new(bytes.Buffer)
+		// ISO-8859-1 encoding, MIME type.
+		picture.WriteByte(0)
+		picture.WriteString(meta.CoverMIME())
+		picture.WriteByte(0)
+		// Front cover, empty description.
+		picture.Write([]byte{3, 0})
+		picture.Write(meta.Cover)
+		appendID3Frame(frames, "APIC", picture.Bytes())
+	}
+
+	size := frames.Len()
+	tag := bytes.NewBuffer([]byte{'I', 'D', '3', 3, 0, 0})
+	// Synchsafe size.
+	tag.Write([]byte{
+		byte(size>>21) & 0x7f, byte(size>>14) & 0x7f,
+		byte(size>>7) & 0x7f, byte(size) & 0x7f,
+	})
+	tag.Write(frames.Bytes())
+	return tag.Bytes()
+}
+
+// Write an ID3v2.3 tag to an mp3 file, replacing the existing ID3v2 tag.
+func writeID3(path string, meta Metadata) error {
+	oldSize, err := id3Size(path)
+	if err != nil {
+		return err
+	}
+	return rewrite(path, part{data: buildID3(meta)}, part{offset: oldSize, length: -1})
+}
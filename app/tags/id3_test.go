@@ -0,0 +1,131 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+// Decode the ID3v2.3 frames of a tag: id -> data.
+func parseID3Frames(t *testing.T, tag []byte) map[string][]byte {
+	t.Helper()
+	frames := map[string][]byte{}
+	for offset := 10; offset+10 <= len(tag); {
+		id := string(tag[offset : offset+4])
+		size := int(binary.BigEndian.Uint32(tag[offset+4 : offset+8]))
+		if offset+10+size > len(tag) {
+			t.Fatalf("frame %s of %d bytes overflows the tag", id, size)
+		}
+		frames[id] = tag[offset+10 : offset+10+size]
+		offset += 10 + size
+	}
+	return frames
+}
+
+// Decode a UTF-16 text with BOM and terminator.
+func decodeID3Text(t *testing.T, data []byte) string {
+	t.Helper()
+	if len(data) < 5 || data[0] != 1 || data[1] != 0xff || data[2] != 0xfe {
+		t.Fatalf("text %x not UTF-16 with BOM", data)
+	}
+	data = bytes.TrimSuffix(data[3:], []byte{0, 0})
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+	}
+	return string(utf16.Decode(units))
+}
+
+func TestWriteID3(t *testing.T) {
+	audio := append([]byte{0xff, 0xfb, 0x90, 0x64}, bytes.Repeat([]byte{0x55}, 1000)...)
+	path := filepath.Join(t.TempDir(), "track.mp3")
+	if err := os.WriteFile(path, audio, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cover := append([]byte("\x89PNG\r\n\x1a\n"), 1, 2, 3)
+	meta := Metadata{
+		Title:       "第1集 𝄞",
+		Album:       "三体",
+		Artist:      "刘慈欣",
+		AlbumArtist: "刘慈欣",
+		Genre:       "有声书",
+		Track:       3,
+		TrackTotal:  30,
+		Year:        2008,
+		Cover:       cover,
+	}
+
+	// Written twice, the first tag is replaced.
+	for i := 0; i < 2; i++ {
+		if err := Write(path, "mp3", meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := id3Size(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(buildID3(meta))) {
+		t.Fatalf("tag size = %d, want %d", size, len(buildID3(meta)))
+	}
+	if !bytes.Equal(data[size:], audio) {
+		t.Error("audio data changed")
+	}
+	if err := Validate(path, "mp3"); err != nil {
+		t.Error(err)
+	}
+
+	frames := parseID3Frames(t, data[:size])
+	texts := map[string]string{
+		"TIT2": meta.Title,
+		"TALB": meta.Album,
+		"TPE1": meta.Artist,
+		"TPE2": meta.AlbumArtist,
+		"TCON": meta.Genre,
+		"TRCK": "3/30",
+		"TYER": "2008",
+	}
+	for id, want := range texts {
+		if got := decodeID3Text(t, frames[id]); got != want {
+			t.Errorf("%s = %q, want %q", id, got, want)
+		}
+	}
+	wantPicture := append([]byte("\x00image/png\x00\x03\x00"), cover...)
+	if !bytes.Equal(frames["APIC"], wantPicture) {
+		t.Errorf("APIC = %q, want %q", frames["APIC"], wantPicture)
+	}
+	if len(frames) != len(texts)+1 {
+		t.Errorf("%d frames, want %d", len(frames), len(texts)+1)
+	}
+}
+
+func TestID3Size(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int64
+	}{
+		{"no tag", []byte{0xff, 0xfb, 0x90, 0x64, 0, 0, 0, 0, 0, 0, 0, 0}, 0},
+		{"short file", []byte("ID3"), 0},
+		{"v2.3", []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0x01, 0x7f}, 10 + 0xff},
+		{"synchsafe", []byte{'I', 'D', '3', 3, 0, 0, 0x01, 0x02, 0x03, 0x04}, 10 + (1<<21 | 2<<14 | 3<<7 | 4)},
+		{"v2.4 footer", []byte{'I', 'D', '3', 4, 0, 0x10, 0, 0, 0, 100}, 120},
+		{"v2.3 flag ignored", []byte{'I', 'D', '3', 3, 0, 0x10, 0, 0, 0, 100}, 110},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "track.mp3")
+		if err := os.WriteFile(path, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if size, err := id3Size(path); err != nil || size != tt.want {
+			t.Errorf("%s: size = %d, %v, want %d", tt.name, size, err, tt.want)
+		}
+	}
+}
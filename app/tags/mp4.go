@@ -0,0 +1,296 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+var errNoMoov = errors.New("mp4: no moov box")
+
+// An mp4 box, offset and size include the header.
+type mp4Box struct {
+	typ        string
+	offset     int64
+	size       int64
+	headerSize int64
+}
+
+// Parse a box header at offset, size is the size of the parent.
+func parseMP4BoxHeader(header []byte, offset int64, size int64) (mp4Box, error) {
+	box := mp4Box{
+		typ:        string(header[4:8]),
+		offset:     offset,
+		size:       int64(binary.BigEndian.Uint32(header[0:4])),
+		headerSize: 8,
+	}
+	if box.size == 1 {
+		if len(header) < 16 {
+			return box, fmt.Errorf("mp4: truncated box %q", box.typ)
+		}
+		box.size = int64(binary.BigEndian.Uint64(header[8:16]))
+		box.headerSize = 16
+	} else if box.size == 0 {
+		box.size = size - offset
+	}
+	if box.size < box.headerSize || offset+box.size > size {
+		return box, fmt.Errorf("mp4: invalid box %q size %d", box.typ, box.size)
+	}
+	return box, nil
+}
+
+// Read the top level boxes of a file.
+func readMP4Boxes(file *os.File) ([]mp4Box, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	boxes := []mp4Box{}
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= size; {
+		n, err := file.ReadAt(header, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		box, err := parseMP4BoxHeader(header[:n], offset, size)
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, box)
+		offset += box.size
+	}
+	return boxes, nil
+}
+
+// Parse the child boxes in data.
+func parseMP4Boxes(data []byte) ([]mp4Box, error) {
+	boxes := []mp4Box{}
+	size := int64(len(data))
+	for offset := int64(0); offset+8 <= size; {
+		box, err := parseMP4BoxHeader(data[offset:], offset, size)
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, box)
+		offset += box.size
+	}
+	return boxes, nil
+}
+
+// Build a box.
+func buildMP4Box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	binary.Write(buf, binary.BigEndian, uint32(size))
+	buf.WriteString(typ)
+	for _, payload := range payloads {
+		buf.Write(payload)
+	}
+	return buf.Bytes()
+}
+
+func uint32Bytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
+
+// Build an iTunes metadata item with a data box.
+func buildMP4Item(typ string, dataType uint32, payload []byte) []byte {
+	return buildMP4Box(typ, buildMP4Box("data", uint32Bytes(dataType), uint32Bytes(0), payload))
+}
+
+// Build the "meta" box with iTunes metadata.
+func buildMP4Meta(meta Metadata) []byte {
+	const (
+		dataTypeImplicit = 0
+		dataTypeUTF8     = 1
+		dataTypeJPEG     = 13
+		dataTypePNG      = 14
+	)
+
+	items := [][]byte{}
+	texts := []struct {
+		typ   string
+		value string
+	}{
+		{"\xa9nam", meta.Title},
+		{"\xa9alb", meta.Album},
+		{"\xa9ART", meta.Artist},
+		{"aART", meta.AlbumArtist},
+		{"\xa9gen", meta.Genre},
+	}
+	for _, text := range texts {
+		if text.value != "" {
+			items = append(items, buildMP4Item(text.typ, dataTypeUTF8, []byte(text.value)))
+		}
+	}
+	if meta.Track > 0 {
+		trkn := make([]byte, 8)
+		binary.BigEndian.PutUint16(trkn[2:4], uint16(meta.Track))
+		binary.BigEndian.PutUint16(trkn[4:6], uint16(meta.TrackTotal))
+		items = append(items, buildMP4Item("trkn", dataTypeImplicit, trkn))
+	}
+	if meta.Year > 0 {
+		items = append(items, buildMP4Item("\xa9day", dataTypeUTF8, []byte(strconv.Itoa(meta.Year))))
+	}
+	if len(meta.Cover) > 0 {
+		dataType := uint32(dataTypeJPEG)
+		if meta.CoverMIME() == "image/png" {
+			dataType = dataTypePNG
+		}
+		items = append(items, buildMP4Item("covr", dataType, meta.Cover))
+	}
+
+	hdlr := buildMP4Box("hdlr",
+		// Version, flags and pre defined.
+		make([]byte, 8),
+		[]byte("mdir"), []byte("appl"), make([]byte, 8),
+		// Empty name.
+		[]byte{0},
+	)
+	return buildMP4Box("meta", make([]byte, 4), hdlr, buildMP4Box("ilst", items...))
+}
+
+// Add delta to all chunk offsets in the "stco" and "co64" boxes inside data.
+func shiftMP4ChunkOffsets(data []byte, delta int64) error {
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		return err
+	}
+	for _, box := range boxes {
+		content := data[box.offset+box.headerSize : box.offset+box.size]
+		switch box.typ {
+		case "trak", "mdia", "minf", "stbl":
+			if err = shiftMP4ChunkOffsets(content, delta); err != nil {
+				return err
+			}
+		case "stco", "co64":
+			if len(content) < 8 {
+				return fmt.Errorf("mp4: truncated box %q", box.typ)
+			}
+			count := int(binary.BigEndian.Uint32(content[4:8]))
+			entries := content[8:]
+			width := 4
+			if box.typ == "co64" {
+				width = 8
+			}
+			if len(entries) < count*width {
+				return fmt.Errorf("mp4: truncated box %q", box.typ)
+			}
+			for i := 0; i < count; i++ {
+				entry := entries[i*width : (i+1)*width]
+				if width == 4 {
+					offset := int64(binary.BigEndian.Uint32(entry)) + delta
+					if offset < 0 || offset > 0xffffffff {
+						return errors.New("mp4: chunk offset overflow")
+					}
+					binary.BigEndian.PutUint32(entry, uint32(offset))
+				} else {
+					binary.BigEndian.PutUint64(entry, uint64(int64(binary.BigEndian.Uint64(entry))+delta))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Write iTunes metadata to an mp4 file, replacing the existing "meta" box in
+// "moov/udta". If the "moov" box is before the media data, the chunk offsets
+// are shifted by the size change.
+func writeMP4(path string, meta Metadata) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	boxes, err := readMP4Boxes(file)
+	if err != nil {
+		return err
+	}
+	var moov *mp4Box
+	mdatAfterMoov := false
+	for i := range boxes {
+		if boxes[i].typ == "moov" {
+			moov = &boxes[i]
+		} else if boxes[i].typ == "mdat" && moov != nil {
+			mdatAfterMoov = true
+		}
+	}
+	if moov == nil {
+		return errNoMoov
+	}
+	moovData := make([]byte, moov.size)
+	if _, err = file.ReadAt(moovData, moov.offset); err != nil {
+		return err
+	}
+	file.Close()
+	moovContent := moovData[moov.headerSize:]
+
+	children, err := parseMP4Boxes(moovContent)
+	if err != nil {
+		return err
+	}
+	// Build the new "udta" box.
+	newMeta := buildMP4Meta(meta)
+	var udta *mp4Box
+	for i := range children {
+		if children[i].typ == "udta" {
+			udta = &children[i]
+		}
+	}
+	var newUdta []byte
+	if udta == nil {
+		newUdta = buildMP4Box("udta", newMeta)
+	} else {
+		udtaContent := moovContent[udta.offset+udta.headerSize : udta.offset+udta.size]
+		udtaChildren, err := parseMP4Boxes(udtaContent)
+		if err != nil {
+			return err
+		}
+		payloads := [][]byte{}
+		for _, child := range udtaChildren {
+			if child.typ != "meta" {
+				payloads = append(payloads, udtaContent[child.offset:child.offset+child.size])
+			}
+		}
+		payloads = append(payloads, newMeta)
+		newUdta = buildMP4Box("udta", payloads...)
+	}
+
+	// Build the new "moov" box.
+	payloads := [][]byte{}
+	for _, child := range children {
+		if child.typ != "udta" {
+			payloads = append(payloads, moovContent[child.offset:child.offset+child.size])
+		}
+	}
+	payloads = append(payloads, newUdta)
+	if mdatAfterMoov {
+		newMoovSize := int64(8)
+		for _, payload := range payloads {
+			newMoovSize += int64(len(payload))
+		}
+		// The payloads share memory with moovContent, shifted in place.
+		if err = shiftMP4ChunkOffsets(moovContent, newMoovSize-moov.size); err != nil {
+			return err
+		}
+	}
+	newMoov := buildMP4Box("moov", payloads...)
+
+	return rewrite(path,
+		part{offset: 0, length: moov.offset},
+		part{data: newMoov},
+		part{offset: moov.offset + moov.size, length: -1},
+	)
+}
@@ -0,0 +1,274 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Build a chunk offset box, "stco" or "co64".
+func buildTestChunkOffsets(typ string, offsets []int64) []byte {
+	buf := new(bytes.Buffer)
+	// Version and flags, entry count.
+	buf.Write(make([]byte, 4))
+	binary.Write(buf, binary.BigEndian, uint32(len(offsets)))
+	for _, offset := range offsets {
+		if typ == "co64" {
+			binary.Write(buf, binary.BigEndian, uint64(offset))
+		} else {
+			binary.Write(buf, binary.BigEndian, uint32(offset))
+		}
+	}
+	return buildMP4Box(typ, buf.Bytes())
+}
+
+func buildTestTrak(typ string, offsets []int64) []byte {
+	stbl := buildMP4Box("stbl", buildMP4Box("stsd", make([]byte, 8)), buildTestChunkOffsets(typ, offsets))
+	return buildMP4Box("trak", buildMP4Box("tkhd", make([]byte, 84)),
+		buildMP4Box("mdia", buildMP4Box("minf", stbl)))
+}
+
+// Build an mp4 file with two tracks, the chunks of the first are referenced by
+// a "stco" box and the chunk of the second by a "co64" box.
+func buildTestMP4(chunks [][]byte, moovFirst bool, udta []byte) []byte {
+	ftyp := buildMP4Box("ftyp", []byte("M4A "), make([]byte, 4), []byte("M4A isom"))
+	mdat := buildMP4Box("mdat", chunks...)
+	moov := func(offsets []int64) []byte {
+		payloads := [][]byte{
+			buildMP4Box("mvhd", make([]byte, 100)),
+			buildTestTrak("stco", offsets[:len(offsets)-1]),
+			buildTestTrak("co64", offsets[len(offsets)-1:]),
+		}
+		if udta != nil {
+			payloads = append(payloads, udta)
+		}
+		return buildMP4Box("moov", payloads...)
+	}
+	// The box sizes don't depend on the offsets.
+	offsets := make([]int64, len(chunks))
+	mdatOffset := int64(len(ftyp))
+	if moovFirst {
+		mdatOffset += int64(len(moov(offsets)))
+	}
+	offset := mdatOffset + 8
+	for i, chunk := range chunks {
+		offsets[i] = offset
+		offset += int64(len(chunk))
+	}
+	if moovFirst {
+		return bytes.Join([][]byte{ftyp, moov(offsets), mdat}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, mdat, moov(offsets)}, nil)
+}
+
+// Content of the box at the path of box types, nil if not found.
+func findMP4Box(t *testing.T, data []byte, path ...string) []byte {
+	t.Helper()
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, box := range boxes {
+		if box.typ != path[0] {
+			continue
+		}
+		content := data[box.offset+box.headerSize : box.offset+box.size]
+		if len(path) == 1 {
+			return content
+		}
+		if box.typ == "meta" {
+			// Version and flags before the children.
+			content = content[4:]
+		}
+		return findMP4Box(t, content, path[1:]...)
+	}
+	return nil
+}
+
+// Count the boxes of a type in data.
+func countMP4Boxes(t *testing.T, data []byte, typ string) int {
+	t.Helper()
+	boxes, err := parseMP4Boxes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, box := range boxes {
+		if box.typ == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// Read the chunk offsets of a "stco" or "co64" box content.
+func readChunkOffsets(typ string, content []byte) []int64 {
+	count := int(binary.BigEndian.Uint32(content[4:8]))
+	offsets := []int64{}
+	for i := 0; i < count; i++ {
+		if typ == "co64" {
+			offsets = append(offsets, int64(binary.BigEndian.Uint64(content[8+i*8:])))
+		} else {
+			offsets = append(offsets, int64(binary.BigEndian.Uint32(content[8+i*4:])))
+		}
+	}
+	return offsets
+}
+
+func TestWriteMP4(t *testing.T) {
+	chunks := [][]byte{
+		bytes.Repeat([]byte{1}, 100), bytes.Repeat([]byte{2}, 200), bytes.Repeat([]byte{3}, 300),
+	}
+	meta := Metadata{
+		Title:      "第1集",
+		Album:      "三体",
+		Artist:     "刘慈欣",
+		Track:      3,
+		TrackTotal: 30,
+		Year:       2008,
+		// Large enough to grow the moov box by several kilobytes.
+		Cover: append([]byte{0xff, 0xd8, 0xff, 0xe0}, make([]byte, 4096)...),
+	}
+	oldMeta := buildMP4Box("meta", make([]byte, 4), buildMP4Box("ilst",
+		buildMP4Item("\xa9nam", 1, []byte("旧标题"))))
+	tests := []struct {
+		name      string
+		moovFirst bool
+		udta      []byte
+	}{
+		{"moov first", true, nil},
+		{"moov last", false, nil},
+		{"existing udta", true, buildMP4Box("udta", buildMP4Box("name", []byte("keep")), oldMeta)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "track.m4a")
+			original := buildTestMP4(chunks, tt.moovFirst, tt.udta)
+			if err := os.WriteFile(path, original, 0644); err != nil {
+				t.Fatal(err)
+			}
+			// Written twice, the first metadata is replaced.
+			for i := 0; i < 2; i++ {
+				if err := Write(path, "m4a", meta); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) <= len(original) {
+				t.Fatalf("file not grown: %d bytes", len(data))
+			}
+			if err := Validate(path, "m4a"); err != nil {
+				t.Error(err)
+			}
+
+			// The chunk offsets point to the same chunks.
+			i := 0
+			moov := findMP4Box(t, data, "moov")
+			boxes, _ := parseMP4Boxes(moov)
+			for _, box := range boxes {
+				if box.typ != "trak" {
+					continue
+				}
+				trak := moov[box.offset : box.offset+box.size]
+				for _, typ := range []string{"stco", "co64"} {
+					content := findMP4Box(t, trak, "trak", "mdia", "minf", "stbl", typ)
+					if content == nil {
+						continue
+					}
+					for _, offset := range readChunkOffsets(typ, content) {
+						if end := offset + int64(len(chunks[i])); end > int64(len(data)) ||
+							!bytes.Equal(data[offset:end], chunks[i]) {
+							t.Errorf("%s chunk %d at %d doesn't match", typ, i, offset)
+						}
+						i++
+					}
+				}
+			}
+			if i != len(chunks) {
+				t.Errorf("%d chunk offsets, want %d", i, len(chunks))
+			}
+
+			udta := findMP4Box(t, data, "moov", "udta")
+			if n := countMP4Boxes(t, udta, "meta"); n != 1 {
+				t.Errorf("%d meta boxes, want 1", n)
+			}
+			if tt.udta != nil && !bytes.Equal(findMP4Box(t, udta, "name"), []byte("keep")) {
+				t.Error("other udta box not kept")
+			}
+			ilst := findMP4Box(t, data, "moov", "udta", "meta", "ilst")
+			items := map[string][]byte{
+				"\xa9nam": []byte(meta.Title),
+				"\xa9alb": []byte(meta.Album),
+				"\xa9ART": []byte(meta.Artist),
+				"\xa9day": []byte("2008"),
+				"trkn":    {0, 0, 0, 3, 0, 30, 0, 0},
+				"covr":    meta.Cover,
+			}
+			for typ, want := range items {
+				// Data type and locale before the value.
+				if value := findMP4Box(t, ilst, typ, "data"); len(value) < 8 || !bytes.Equal(value[8:], want) {
+					t.Errorf("item %q = %q, want %q", typ, value, want)
+				}
+			}
+			if value := findMP4Box(t, ilst, "covr", "data"); binary.BigEndian.Uint32(value) != 13 {
+				t.Errorf("cover data type %d, want JPEG", binary.BigEndian.Uint32(value))
+			}
+		})
+	}
+}
+
+func TestWriteMP4NoMoov(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "track.m4a")
+	data := bytes.Join([][]byte{
+		buildMP4Box("ftyp", []byte("M4A "), make([]byte, 4)), buildMP4Box("mdat", make([]byte, 10)),
+	}, nil)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(path, "m4a", Metadata{Title: "标题"}); err != errNoMoov {
+		t.Errorf("err = %v, want %v", err, errNoMoov)
+	}
+}
+
+func TestShiftMP4ChunkOffsets(t *testing.T) {
+	stbl := func(typ string, offsets ...int64) []byte {
+		return buildMP4Box("stbl", buildTestChunkOffsets(typ, offsets))
+	}
+	tests := []struct {
+		name  string
+		data  []byte
+		delta int64
+		want  []int64
+		err   bool
+	}{
+		{"stco", stbl("stco", 100, 200), 50, []int64{150, 250}, false},
+		{"stco shrink", stbl("stco", 100, 200), -50, []int64{50, 150}, false},
+		{"co64", stbl("co64", 1<<33), 1 << 20, []int64{1<<33 + 1<<20}, false},
+		{"stco overflow", stbl("stco", 0xfffffff0), 0x100, nil, true},
+		{"truncated", buildMP4Box("stbl", buildMP4Box("stco", []byte{0, 0, 0, 0, 0, 0, 0, 5})), 1, nil, true},
+	}
+	for _, tt := range tests {
+		err := shiftMP4ChunkOffsets(tt.data, tt.delta)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		boxes, _ := parseMP4Boxes(tt.data[8:])
+		got := readChunkOffsets(boxes[0].typ, tt.data[8+8:])
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: offsets = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package tags
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Track metadata to write into an audio file.
+type Metadata struct {
+	Title       string
+	Album       string
+	Artist      string
+	AlbumArtist string
+	Genre       string
+	// Track number and total, 0 if unknown.
+	Track      int
+	TrackTotal int
+	// Release year, 0 if unknown.
+	Year int
+	// Cover image data, JPEG or PNG.
+	Cover []byte
+}
+
+// Guess the cover image MIME type, "image/jpeg" by default.
+func (m *Metadata) CoverMIME() string {
+	if len(m.Cover) >= 8 && string(m.Cover[1:4]) == "PNG" {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// Write the metadata into an audio file, replacing existing tags.
+// fileType is the file type returned by the server, "mp3" or "m4a".
+func Write(path string, fileType string, meta Metadata) error {
+	switch strings.ToLower(fileType) {
+	case "mp3":
+		return writeID3(path, meta)
+	case "m4a", "mp4", "m4b":
+		return writeMP4(path, meta)
+	}
+	return fmt.Errorf("unsupported file type %q", fileType)
+}
+
+// Part of a rewritten file, the data if not nil, or else the original file
+// data of length bytes from offset, length is -1 to the end of file.
+type part struct {
+	data   []byte
+	offset int64
+	length int64
+}
+
+// Rewrite a file with parts, the new file is written beside and renamed over
+// the original.
+func rewrite(path string, parts ...part) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tag*")
+	if err != nil {
+		return err
+	}
+	tmppath := dst.Name()
+	defer os.Remove(tmppath)
+
+	for _, p := range parts {
+		if p.data != nil {
+			_, err = dst.Write(p.data)
+		} else if _, err = src.Seek(p.offset, io.SeekStart); err == nil {
+			var reader io.Reader = src
+			if p.length >= 0 {
+				reader = io.LimitReader(src, p.length)
+			}
+			_, err = io.Copy(dst, reader)
+		}
+		if err != nil {
+			dst.Close()
+			return err
+		}
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Rename(tmppath, path)
+}
@@ -0,0 +1,76 @@
+package tags
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrInvalidAudio = errors.New("invalid audio file")
+
+// Validate check the headers of an audio file.
+// An mp3 file must start with an ID3v2 tag or an MPEG frame header, an mp4
+// file must have valid top level boxes including "ftyp", "moov" and "mdat",
+// which catches most truncated files.
+func Validate(path string, fileType string) error {
+	switch strings.ToLower(fileType) {
+	case "mp3":
+		return validateMP3(path)
+	case "m4a", "mp4", "m4b":
+		return validateMP4(path)
+	}
+	return fmt.Errorf("unsupported file type %q", fileType)
+}
+
+func validateMP3(path string) error {
+	offset, err := id3Size(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := make([]byte, 2)
+	if _, err = file.ReadAt(header, offset); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("%w: no audio data", ErrInvalidAudio)
+		}
+		return err
+	}
+	// MPEG frame sync.
+	if header[0] != 0xff || header[1]&0xe0 != 0xe0 {
+		return fmt.Errorf("%w: no MPEG frame header", ErrInvalidAudio)
+	}
+	return nil
+}
+
+func validateMP4(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	boxes, err := readMP4Boxes(file)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidAudio, err)
+	}
+	found := map[string]bool{}
+	for _, box := range boxes {
+		found[box.typ] = true
+	}
+	if len(boxes) == 0 || boxes[0].typ != "ftyp" {
+		return fmt.Errorf("%w: no ftyp box", ErrInvalidAudio)
+	}
+	for _, typ := range []string{"moov", "mdat"} {
+		if !found[typ] {
+			return fmt.Errorf("%w: no %s box", ErrInvalidAudio, typ)
+		}
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package app
 
 import (
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 
@@ -71,14 +70,24 @@ func (t *ToolbarSelectEntry) ToolbarObject() fyne.CanvasObject {
 func newToolbar(
 	window fyne.Window,
 	onOpenFavorite func(),
+	onOpenDownload func(),
+	onOpenSettings func(),
 	onSearch func(keyword string),
 ) *widget.Toolbar {
 	favoriteBtn := &ToolbarAction{theme.StorageIcon(), "收藏", func() {
 		if onOpenFavorite != nil {
 			onOpenFavorite()
 		}
-		dialog := dialog.NewInformation("提示", "还没弄好...", window)
-		dialog.Show()
+	}}
+	downloadBtn := &ToolbarAction{theme.DownloadIcon(), "下载", func() {
+		if onOpenDownload != nil {
+			onOpenDownload()
+		}
+	}}
+	settingsBtn := &ToolbarAction{theme.SettingsIcon(), "设置", func() {
+		if onOpenSettings != nil {
+			onOpenSettings()
+		}
 	}}
 	searchEntry := &ToolbarSelectEntry{
 		OnSearch: onSearch,
@@ -86,6 +95,8 @@ func newToolbar(
 	// Create toolbar.
 	return widget.NewToolbar(
 		favoriteBtn,
+		downloadBtn,
+		settingsBtn,
 		widget.NewToolbarSpacer(),
 		searchEntry,
 	)
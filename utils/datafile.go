@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+)
+
+// Suffix of a data file failed to parse, moved aside to keep the data.
+const CorruptSuffix = ".corrupt"
+
+// SetAsideCorrupt move a data file failed to parse by parseErr aside, so an
+// empty one saved in its place doesn't overwrite the data. Returns whether
+// moved, saving to path must be disabled if not, and the error to show.
+func SetAsideCorrupt(path string, parseErr error) (bool, error) {
+	corrupt := path + CorruptSuffix
+	if err := os.Rename(path, corrupt); err != nil {
+		return false, fmt.Errorf("%s 已损坏, 更改将不会保存: %w", path, parseErr)
+	}
+	return true, fmt.Errorf("%s 已损坏, 已移动到 %s: %w", path, corrupt, parseErr)
+}
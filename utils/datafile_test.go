@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetAsideCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := os.WriteFile(path, []byte("{broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parseErr := errors.New("unexpected end of JSON input")
+	moved, err := SetAsideCorrupt(path, parseErr)
+	if !moved || !errors.Is(err, parseErr) {
+		t.Fatalf("moved = %v, err = %v", moved, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("corrupt file still at %s", path)
+	}
+	if data, err := os.ReadFile(path + CorruptSuffix); err != nil || string(data) != "{broken" {
+		t.Errorf("moved data = %q, %v", data, err)
+	}
+
+	// Not moved, the caller must not save to the path.
+	moved, err = SetAsideCorrupt(filepath.Join(t.TempDir(), "missing.json"), parseErr)
+	if moved || !errors.Is(err, parseErr) {
+		t.Errorf("moved = %v, err = %v for a missing file", moved, err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import "syscall"
+
+// FreeSpace returns the bytes available to the user on the volume of a path.
+func FreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
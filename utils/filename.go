@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Max bytes of a sanitized file name, leaves room for suffixes under the
+// common 255 bytes limit.
+const MaxFilenameBytes = 200
+
+// Characters not allowed in file names on some platforms, replaced with the
+// full width forms which read the same in Chinese text.
+var filenameReplacer = strings.NewReplacer(
+	"/", "／",
+	"\\", "＼",
+	":", "：",
+	"*", "＊",
+	"?", "？",
+	"\"", "＂",
+	"<", "＜",
+	">", "＞",
+	"|", "｜",
+)
+
+// Reserved device names on Windows, case insensitive with any extension.
+var reservedFilenames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename make a name safe to use as a single file or directory
+// name on Windows, Linux and macOS:
+//   - path separators and other reserved characters are replaced
+//   - control and invisible format characters are removed
+//   - leading and trailing spaces and trailing dots are trimmed, so "." and
+//     ".." can never be produced
+//   - reserved Windows device names like "CON" are prefixed with "_"
+//   - long names are truncated to MaxFilenameBytes keeping the extension
+//
+// An empty result is replaced with "_".
+func SanitizeFilename(name string) string {
+	name = filenameReplacer.Replace(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+
+	base := name
+	if dot := strings.Index(base, "."); dot >= 0 {
+		base = base[:dot]
+	}
+	if reservedFilenames[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+
+	if len(name) > MaxFilenameBytes {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		stem := truncateUTF8(strings.TrimSuffix(name, ext), MaxFilenameBytes-len(ext))
+		name = strings.TrimRight(stem, ". ") + ext
+	}
+	return name
+}
+
+// SanitizePath sanitize each element of a slash separated relative path.
+func SanitizePath(path string) string {
+	elements := strings.FieldsFunc(filepath.ToSlash(path), func(r rune) bool {
+		return r == '/'
+	})
+	if len(elements) == 0 {
+		return "_"
+	}
+	for i, element := range elements {
+		elements[i] = SanitizeFilename(element)
+	}
+	return filepath.Join(elements...)
+}
+
+// IsWithin check path is root or inside root.
+func IsWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Truncate a string to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"三体", "三体"},
+		{"a/b\\c", "a／b＼c"},
+		{"第1集: 开始?", "第1集： 开始？"},
+		{`<"*|>`, "＜＂＊｜＞"},
+		// Full width forms are kept as is.
+		{"已是全角／：？", "已是全角／：？"},
+		{"《三体》，第一部。", "《三体》，第一部。"},
+		{"tab\tand\nnewline", "tab and newline"},
+		{"zero\u200bwidth\u202eformat\ufeff", "zerowidthformat"},
+		{"bell\a", "bell"},
+		{"invalid\xffutf8", "invalidutf8"},
+		{"  spaced  ", "spaced"},
+		{"trailing dots...", "trailing dots"},
+		{"trailing dot and space. . ", "trailing dot and space"},
+		{".hidden", ".hidden"},
+		{".", "_"},
+		{"..", "_"},
+		{"   ", "_"},
+		{"", "_"},
+		{"CON", "_CON"},
+		{"con.txt", "_con.txt"},
+		{"Lpt1.mp3", "_Lpt1.mp3"},
+		{"COM10", "COM10"},
+		{"CONSOLE", "CONSOLE"},
+	}
+	for _, test := range tests {
+		if got := SanitizeFilename(test.name); got != test.want {
+			t.Errorf("SanitizeFilename(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSanitizeFilenameTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		ext  string
+	}{
+		{strings.Repeat("字", 100) + ".m4a", ".m4a"},
+		{"a" + strings.Repeat("字", 100) + ".mp3", ".mp3"},
+		{"ab" + strings.Repeat("字", 100), ""},
+		{strings.Repeat("😀", 60), ""},
+		// Too long extension is truncated with the name.
+		{strings.Repeat("字", 100) + "." + strings.Repeat("x", 20), ""},
+	}
+	for _, test := range tests {
+		got := SanitizeFilename(test.name)
+		if len(got) > MaxFilenameBytes {
+			t.Errorf("SanitizeFilename(%q) has %d bytes", test.name, len(got))
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("SanitizeFilename(%q) = %q splits a rune", test.name, got)
+		}
+		if !strings.HasSuffix(got, test.ext) {
+			t.Errorf("SanitizeFilename(%q) = %q lost extension %q", test.name, got, test.ext)
+		}
+		if !strings.HasPrefix(test.name, strings.TrimSuffix(got, test.ext)) {
+			t.Errorf("SanitizeFilename(%q) = %q is not a prefix", test.name, got)
+		}
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"专辑/第1集.m4a", filepath.Join("专辑", "第1集.m4a")},
+		{"/abs//path/", filepath.Join("abs", "path")},
+		{"../../etc/passwd", filepath.Join("_", "_", "etc", "passwd")},
+		{"a/../b", filepath.Join("a", "_", "b")},
+		{"a/./b", filepath.Join("a", "_", "b")},
+		{"a\\b:c", "a＼b：c"},
+		{"album./CON/track .mp3", filepath.Join("album", "_CON", "track .mp3")},
+		{"", "_"},
+		{"///", "_"},
+	}
+	for _, test := range tests {
+		if got := SanitizePath(test.path); got != test.want {
+			t.Errorf("SanitizePath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	root := filepath.Join("data", "downloads")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{root, true},
+		{filepath.Join(root, "album", "track.m4a"), true},
+		{filepath.Join(root, "..dots", "track.m4a"), true},
+		{filepath.Join(root, "..", "other"), false},
+		{filepath.Join(root, "a", "..", "..", "other"), false},
+		{filepath.Join("data", "downloads2"), false},
+		{"data", false},
+		{filepath.Join(root, SanitizePath("../../etc/passwd")), true},
+	}
+	for _, test := range tests {
+		if got := IsWithin(root, test.path); got != test.want {
+			t.Errorf("IsWithin(%q, %q) = %v, want %v", root, test.path, got, test.want)
+		}
+	}
+	if IsWithin(root, "/absolute") {
+		t.Errorf("IsWithin(%q, %q) = true with a relative root", root, "/absolute")
+	}
+}
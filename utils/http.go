@@ -2,8 +2,10 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/funte/xmlymft/common"
 )
@@ -107,6 +109,33 @@ func HTTPGetQueryTrackAddressResponse(url string) (*QueryTrackAddressResponse, e
 	return result, nil
 }
 
+// Host of the album cover images with a relative path.
+const CoverHost = "https://imagev2.xmcdn.com/"
+
+// AlbumCoverURL get the full URL of an album cover path.
+func AlbumCoverURL(cover string) string {
+	if cover == "" || strings.HasPrefix(cover, "http://") || strings.HasPrefix(cover, "https://") {
+		return cover
+	}
+	if strings.HasPrefix(cover, "//") {
+		return "https:" + cover
+	}
+	return CoverHost + strings.TrimLeft(cover, "/")
+}
+
+// HTTPGetBytes get the response body of a successful request.
+func HTTPGetBytes(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
 // // Requires go-1.18
 // // Type contracts for HTTP server response.
 // type HTTPResponseType interface {